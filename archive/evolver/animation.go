@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// AnimationFormatGIF is an animated gif
+	AnimationFormatGIF = "gif"
+	// AnimationFormatAPNG is an animated png
+	AnimationFormatAPNG = "apng"
+)
+
+// AnimationFormat determines the animation format from the name of the
+// output file. An empty string is returned for other formats (such as mp4).
+func AnimationFormat(filename string) string {
+	lower := strings.ToLower(filename)
+	if strings.HasSuffix(lower, ".gif") {
+		return AnimationFormatGIF
+	}
+	if strings.HasSuffix(lower, ".png") || strings.HasSuffix(lower, ".apng") {
+		return AnimationFormatAPNG
+	}
+	return ""
+}
+
+// An AnimationWriter writes frames to an animated image
+type AnimationWriter interface {
+	AddFrame(img image.Image) error
+	// Close finishes the animation. It doesn't close the underlying writer.
+	Close() error
+}
+
+// NewAnimationWriter creates an AnimationWriter for a format. plays is the
+// number of times the animation is played, 0 loops forever.
+func NewAnimationWriter(format string, writer io.Writer, frames int, fps int, plays int) (AnimationWriter, error) {
+	switch format {
+	case AnimationFormatGIF:
+		return NewGIFWriter(writer, fps, plays), nil
+	case AnimationFormatAPNG:
+		return NewAPNGWriter(writer, frames, fps, plays), nil
+	}
+	return nil, fmt.Errorf("Unsupported animation format '%v'", format)
+}
+
+// GIFWriter writes an animated gif. Every frame gets its own palette, so
+// colors stay accurate as the painting evolves. Frames are written as they
+// are added.
+type GIFWriter struct {
+	writer io.Writer
+	// loopCount follows gif.GIF.LoopCount
+	loopCount int
+	delay     int
+	width     int
+	height    int
+	started   bool
+	err       error
+}
+
+// NewGIFWriter creates a new GIFWriter
+func NewGIFWriter(writer io.Writer, fps int, plays int) *GIFWriter {
+	// gif counts repeats instead of plays, and uses -1 to play once
+	var loopCount int
+	switch plays {
+	case 0:
+		loopCount = 0
+	case 1:
+		loopCount = -1
+	default:
+		loopCount = plays - 1
+	}
+	delay := int(math.Round(100.0 / float64(fps)))
+	if delay < 2 {
+		// Most viewers slow down gifs with shorter delays
+		delay = 2
+	}
+	return &GIFWriter{writer: writer, loopCount: loopCount, delay: delay}
+}
+
+// AddFrame adds a frame to the animation. All frames must have the same size.
+func (gifWriter *GIFWriter) AddFrame(img image.Image) error {
+	if gifWriter.err != nil {
+		return gifWriter.err
+	}
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), MedianCutPalette(img, 256))
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), img, bounds.Min)
+	// Frames are encoded as regular gifs, and their palette and image data
+	// are moved into the animation
+	buf := &bytes.Buffer{}
+	err := gif.Encode(buf, paletted, nil)
+	if err != nil {
+		return err
+	}
+	colorTable, imageData, err := readGIFFrame(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if !gifWriter.started {
+		gifWriter.started = true
+		gifWriter.width, gifWriter.height = bounds.Dx(), bounds.Dy()
+		gifWriter.write([]byte("GIF89a"))
+		gifWriter.write(gifUint16(gifWriter.width, gifWriter.height))
+		// No global color table, background color and aspect ratio
+		gifWriter.write([]byte{0, 0, 0})
+		if gifWriter.loopCount >= 0 {
+			gifWriter.write([]byte{0x21, 0xff, 11})
+			gifWriter.write([]byte("NETSCAPE2.0"))
+			gifWriter.write([]byte{3, 1})
+			gifWriter.write(gifUint16(gifWriter.loopCount))
+			gifWriter.write([]byte{0})
+		}
+	} else if bounds.Dx() != gifWriter.width || bounds.Dy() != gifWriter.height {
+		return fmt.Errorf("All frames of an animated gif must have the same size")
+	}
+	// Graphic control extension with the delay of the frame
+	gifWriter.write([]byte{0x21, 0xf9, 4, 0})
+	gifWriter.write(gifUint16(gifWriter.delay))
+	gifWriter.write([]byte{0, 0})
+	// Image descriptor with a local color table
+	gifWriter.write([]byte{0x2c})
+	gifWriter.write(gifUint16(0, 0, gifWriter.width, gifWriter.height))
+	gifWriter.write([]byte{0x80 | gifColorTableSize(len(colorTable))})
+	gifWriter.write(colorTable)
+	gifWriter.write(imageData)
+	return gifWriter.err
+}
+
+// Close writes the end of the animation
+func (gifWriter *GIFWriter) Close() error {
+	if !gifWriter.started {
+		return fmt.Errorf("No frames were added to the animation")
+	}
+	gifWriter.write([]byte{0x3b})
+	return gifWriter.err
+}
+
+func (gifWriter *GIFWriter) write(data []byte) {
+	if gifWriter.err == nil {
+		_, gifWriter.err = gifWriter.writer.Write(data)
+	}
+}
+
+// gifUint16 encodes values as little endian 16 bit integers
+func gifUint16(values ...int) []byte {
+	data := make([]byte, 2*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(value))
+	}
+	return data
+}
+
+// gifColorTableSize returns the size field of a color table of n bytes.
+// Tables hold 2^(size+1) colors.
+func gifColorTableSize(n int) byte {
+	size := byte(0)
+	for 3<<(size+1) < n {
+		size++
+	}
+	return size
+}
+
+// readGIFFrame splits a gif with a single frame into its color table and its
+// image data (the lzw code size and data sub-blocks)
+func readGIFFrame(data []byte) ([]byte, []byte, error) {
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return nil, nil, fmt.Errorf("Invalid gif header")
+	}
+	flags := data[10]
+	data = data[13:]
+	var colorTable []byte
+	if flags&0x80 != 0 {
+		n := 3 << (flags&7 + 1)
+		if n > len(data) {
+			return nil, nil, fmt.Errorf("Invalid gif color table")
+		}
+		colorTable, data = data[:n], data[n:]
+	}
+	for len(data) > 0 {
+		switch data[0] {
+		case 0x21:
+			// Extensions are skipped
+			if len(data) < 2 {
+				return nil, nil, fmt.Errorf("Invalid gif extension")
+			}
+			end, err := gifSubBlocksEnd(data, 2)
+			if err != nil {
+				return nil, nil, err
+			}
+			data = data[end:]
+		case 0x2c:
+			if len(data) < 11 {
+				return nil, nil, fmt.Errorf("Invalid gif image descriptor")
+			}
+			start := 10
+			if flags := data[9]; flags&0x80 != 0 {
+				n := 3 << (flags&7 + 1)
+				if start+n >= len(data) {
+					return nil, nil, fmt.Errorf("Invalid gif color table")
+				}
+				colorTable = data[start : start+n]
+				start += n
+			}
+			if colorTable == nil {
+				return nil, nil, fmt.Errorf("Gif frame has no color table")
+			}
+			// The lzw code size is followed by the data sub-blocks
+			end, err := gifSubBlocksEnd(data, start+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			return colorTable, data[start:end], nil
+		default:
+			return nil, nil, fmt.Errorf("Invalid gif block 0x%x", data[0])
+		}
+	}
+	return nil, nil, fmt.Errorf("No image found in gif")
+}
+
+// gifSubBlocksEnd returns the offset after the sub-blocks that start at
+// offset, including the terminating empty block
+func gifSubBlocksEnd(data []byte, offset int) (int, error) {
+	for offset < len(data) {
+		size := int(data[offset])
+		offset += 1 + size
+		if size == 0 {
+			return offset, nil
+		}
+	}
+	return 0, fmt.Errorf("Truncated gif data")
+}
+
+// APNGWriter writes an animated png. Frames are written as they are added.
+type APNGWriter struct {
+	writer   io.Writer
+	frames   int
+	fps      int
+	plays    int
+	sequence uint32
+	header   []byte // IHDR data of the first frame
+	err      error
+}
+
+// NewAPNGWriter creates a new APNGWriter. The number of frames must be known
+// up front, since it is written before the first frame.
+func NewAPNGWriter(writer io.Writer, frames int, fps int, plays int) *APNGWriter {
+	return &APNGWriter{writer: writer, frames: frames, fps: fps, plays: plays}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// AddFrame adds a frame to the animation. All frames must have the same size.
+func (apngWriter *APNGWriter) AddFrame(img image.Image) error {
+	if apngWriter.err != nil {
+		return apngWriter.err
+	}
+	// Frames are encoded as regular pngs, and their image data is moved into
+	// animation chunks.
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, img)
+	if err != nil {
+		return err
+	}
+	chunks, err := readPNGChunks(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	first := apngWriter.header == nil
+	for _, chunk := range chunks {
+		switch chunk.chunkType {
+		case "IHDR":
+			if first {
+				apngWriter.header = chunk.data
+				apngWriter.write(pngSignature)
+				apngWriter.writeChunk("IHDR", chunk.data)
+				actl := make([]byte, 8)
+				binary.BigEndian.PutUint32(actl[0:], uint32(apngWriter.frames))
+				binary.BigEndian.PutUint32(actl[4:], uint32(apngWriter.plays))
+				apngWriter.writeChunk("acTL", actl)
+			} else if !bytes.Equal(chunk.data, apngWriter.header) {
+				return fmt.Errorf("All frames of an animated png must have the same size and color type")
+			}
+			apngWriter.writeFrameControl(img.Bounds())
+		case "IDAT":
+			if first {
+				apngWriter.writeChunk("IDAT", chunk.data)
+			} else {
+				fdat := make([]byte, 4+len(chunk.data))
+				binary.BigEndian.PutUint32(fdat, apngWriter.sequence)
+				apngWriter.sequence++
+				copy(fdat[4:], chunk.data)
+				apngWriter.writeChunk("fdAT", fdat)
+			}
+		}
+	}
+	return apngWriter.err
+}
+
+// Close writes the end of the animation
+func (apngWriter *APNGWriter) Close() error {
+	if apngWriter.header == nil {
+		return fmt.Errorf("No frames were added to the animation")
+	}
+	apngWriter.writeChunk("IEND", nil)
+	return apngWriter.err
+}
+
+func (apngWriter *APNGWriter) writeFrameControl(bounds image.Rectangle) {
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:], apngWriter.sequence)
+	binary.BigEndian.PutUint32(fctl[4:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(bounds.Dy()))
+	// x and y offsets are zero
+	binary.BigEndian.PutUint16(fctl[20:], 1)
+	binary.BigEndian.PutUint16(fctl[22:], uint16(apngWriter.fps))
+	// dispose and blend operations are zero (none and source)
+	apngWriter.sequence++
+	apngWriter.writeChunk("fcTL", fctl)
+}
+
+func (apngWriter *APNGWriter) writeChunk(chunkType string, data []byte) {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], chunkType)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, crc.Sum32())
+	apngWriter.write(header)
+	apngWriter.write(data)
+	apngWriter.write(footer)
+}
+
+func (apngWriter *APNGWriter) write(data []byte) {
+	if apngWriter.err == nil {
+		_, apngWriter.err = apngWriter.writer.Write(data)
+	}
+}
+
+type pngChunk struct {
+	chunkType string
+	data      []byte
+}
+
+// readPNGChunks splits an encoded png into its chunks
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("Invalid png signature")
+	}
+	data = data[len(pngSignature):]
+	chunks := []pngChunk{}
+	for len(data) >= 12 {
+		length := int(binary.BigEndian.Uint32(data))
+		if 12+length > len(data) {
+			return nil, fmt.Errorf("Invalid png chunk length %v", length)
+		}
+		chunks = append(chunks, pngChunk{
+			chunkType: string(data[4:8]),
+			data:      data[8 : 8+length],
+		})
+		data = data[12+length:]
+	}
+	return chunks, nil
+}
+
+// MedianCutPalette creates a palette of at most the specified number of
+// colors that represents the colors of an image. The color space is split
+// into boxes with the same number of pixels, and each box contributes its
+// average color.
+func MedianCutPalette(img image.Image, colors int) color.Palette {
+	bounds := img.Bounds()
+	// Large images are sampled, to keep this fast
+	step := int(math.Ceil(math.Sqrt(float64(bounds.Dx()*bounds.Dy()) / 65536)))
+	if step < 1 {
+		step = 1
+	}
+	pixels := []color.NRGBA{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			pixels = append(pixels, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+		}
+	}
+	boxes := []medianCutBox{{pixels: pixels}}
+	for len(boxes) < colors {
+		// Split the box with the widest channel range
+		widest, widestRange, channel := -1, 0, 0
+		for i, box := range boxes {
+			if len(box.pixels) < 2 {
+				continue
+			}
+			if c, r := box.widestChannel(); r > widestRange {
+				widest, widestRange, channel = i, r, c
+			}
+		}
+		if widest < 0 {
+			break
+		}
+		box := boxes[widest]
+		sort.Slice(box.pixels, func(i, j int) bool {
+			return colorChannel(box.pixels[i], channel) < colorChannel(box.pixels[j], channel)
+		})
+		median := len(box.pixels) / 2
+		boxes[widest] = medianCutBox{pixels: box.pixels[:median]}
+		boxes = append(boxes, medianCutBox{pixels: box.pixels[median:]})
+	}
+	palette := color.Palette{}
+	for _, box := range boxes {
+		if len(box.pixels) > 0 {
+			palette = append(palette, box.average())
+		}
+	}
+	return palette
+}
+
+type medianCutBox struct {
+	pixels []color.NRGBA
+}
+
+// widestChannel returns the color channel with the largest range, and the range
+func (box medianCutBox) widestChannel() (int, int) {
+	widest, widestRange := 0, -1
+	for channel := 0; channel < 3; channel++ {
+		min, max := 255, 0
+		for _, pixel := range box.pixels {
+			value := colorChannel(pixel, channel)
+			if value < min {
+				min = value
+			}
+			if value > max {
+				max = value
+			}
+		}
+		if max-min > widestRange {
+			widest, widestRange = channel, max-min
+		}
+	}
+	return widest, widestRange
+}
+
+func (box medianCutBox) average() color.Color {
+	var r, g, b int
+	for _, pixel := range box.pixels {
+		r += int(pixel.R)
+		g += int(pixel.G)
+		b += int(pixel.B)
+	}
+	n := len(box.pixels)
+	return color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: 255}
+}
+
+func colorChannel(clr color.NRGBA, channel int) int {
+	switch channel {
+	case 0:
+		return int(clr.R)
+	case 1:
+		return int(clr.G)
+	}
+	return int(clr.B)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// gradientFrame returns a frame with many colors, tinted by a color
+func gradientFrame(tint color.NRGBA) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{tint.R, uint8(x * 6), uint8(y*8) | tint.B, 255})
+		}
+	}
+	return img
+}
+
+func TestGIFWriterStreamsFrames(t *testing.T) {
+	tints := []color.NRGBA{{255, 0, 0, 255}, {0, 0, 0, 255}, {128, 0, 255, 255}}
+	for _, plays := range []int{0, 1, 3} {
+		buf := &bytes.Buffer{}
+		writer := NewGIFWriter(buf, 20, plays)
+		for _, tint := range tints {
+			err := writer.AddFrame(gradientFrame(tint))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := writer.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		anim, err := gif.DecodeAll(buf)
+		if err != nil {
+			t.Fatalf("Error decoding gif: %v", err.Error())
+		}
+		if len(anim.Image) != len(tints) {
+			t.Fatalf("Expected %v frames, got %v", len(tints), len(anim.Image))
+		}
+		expectedLoopCount := map[int]int{0: 0, 1: -1, 3: 2}[plays]
+		if anim.LoopCount != expectedLoopCount {
+			t.Errorf("Expected loop count %v for %v plays, got %v", expectedLoopCount, plays, anim.LoopCount)
+		}
+		for i, frame := range anim.Image {
+			if anim.Delay[i] != 5 {
+				t.Errorf("Expected a delay of 5, got %v", anim.Delay[i])
+			}
+			r, _, _, _ := frame.At(20, 15).RGBA()
+			if diff := int(r>>8) - int(tints[i].R); diff > 16 || diff < -16 {
+				t.Errorf("Frame %v: Expected red %v, got %v", i, tints[i].R, r>>8)
+			}
+		}
+	}
+}
+
+func TestGIFWriterRejectsFramesOfDifferentSizes(t *testing.T) {
+	writer := NewGIFWriter(&bytes.Buffer{}, 10, 0)
+	err := writer.AddFrame(gradientFrame(color.NRGBA{A: 255}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.AddFrame(image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+	if err == nil {
+		t.Error("Expected an error adding a frame of a different size")
+	}
+}
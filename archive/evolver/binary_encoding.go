@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+)
+
+const (
+	// EncodingText is the original encoding of organisms: json for each
+	// instruction, separated by tabs.
+	EncodingText = "text"
+	// EncodingBinary is the compact binary encoding of organisms
+	EncodingBinary = "binary"
+)
+
+// BinaryContentType is the media type of binary encoded organisms and
+// instructions. Clients that send it in the Accept header receive the binary
+// encoding, all other clients receive the text encoding.
+const BinaryContentType = "application/x-evolver-binary"
+
+// binaryOrganismMagic starts every binary encoded organism. Text encoded
+// organisms always start with a type name, so the two can't be confused.
+var binaryOrganismMagic = []byte{0, 'E', 'V', 'O'}
+
+// binaryOrganismVersion is the version of the binary organism encoding
+const binaryOrganismVersion = 1
+
+// fixedPointScale is used to store coordinates as integers. Instruction
+// values are truncated to 4 decimal places (see the mutators), and hashes
+// use the same precision, so encoding doesn't change any hashes.
+const fixedPointScale = 10000
+
+// A BinaryEncoder writes values in a compact binary form. Integers are
+// written as varints, and decimals as fixed-point varints.
+type BinaryEncoder struct {
+	buf *bytes.Buffer
+	// palette maps colors to their index. If it's nil, colors are written inline.
+	palette map[color.NRGBA]int
+	colors  []color.NRGBA
+	scratch [binary.MaxVarintLen64]byte
+}
+
+// NewBinaryEncoder creates an encoder that writes colors inline, for
+// encoding single instructions.
+func NewBinaryEncoder() *BinaryEncoder {
+	return &BinaryEncoder{buf: &bytes.Buffer{}}
+}
+
+// newPaletteEncoder creates an encoder that writes colors as palette indexes
+func newPaletteEncoder() *BinaryEncoder {
+	encoder := NewBinaryEncoder()
+	encoder.palette = map[color.NRGBA]int{}
+	return encoder
+}
+
+// Bytes returns the encoded data
+func (encoder *BinaryEncoder) Bytes() []byte {
+	return encoder.buf.Bytes()
+}
+
+// WriteUvarint writes an unsigned integer
+func (encoder *BinaryEncoder) WriteUvarint(value uint64) {
+	n := binary.PutUvarint(encoder.scratch[:], value)
+	encoder.buf.Write(encoder.scratch[:n])
+}
+
+// WriteVarint writes a signed integer
+func (encoder *BinaryEncoder) WriteVarint(value int64) {
+	n := binary.PutVarint(encoder.scratch[:], value)
+	encoder.buf.Write(encoder.scratch[:n])
+}
+
+// WriteFixed writes a decimal with 4 decimal places of precision
+func (encoder *BinaryEncoder) WriteFixed(value float32) {
+	encoder.WriteVarint(int64(math.Round(float64(value) * fixedPointScale)))
+}
+
+// WriteBool writes a boolean as a single byte
+func (encoder *BinaryEncoder) WriteBool(value bool) {
+	if value {
+		encoder.buf.WriteByte(1)
+	} else {
+		encoder.buf.WriteByte(0)
+	}
+}
+
+// WriteString writes a length-prefixed string
+func (encoder *BinaryEncoder) WriteString(value string) {
+	encoder.WriteUvarint(uint64(len(value)))
+	encoder.buf.WriteString(value)
+}
+
+// WriteColor writes a color, either inline or as a palette index
+func (encoder *BinaryEncoder) WriteColor(clr *color.NRGBA) {
+	if encoder.palette == nil {
+		encoder.buf.Write([]byte{clr.R, clr.G, clr.B, clr.A})
+		return
+	}
+	index, has := encoder.palette[*clr]
+	if !has {
+		index = len(encoder.colors)
+		encoder.palette[*clr] = index
+		encoder.colors = append(encoder.colors, *clr)
+	}
+	encoder.WriteUvarint(uint64(index))
+}
+
+// A BinaryDecoder reads values written by a BinaryEncoder. The first error
+// is remembered, and all reads after an error return zero values. Check Err
+// once decoding is complete.
+type BinaryDecoder struct {
+	reader  *bytes.Reader
+	palette []color.NRGBA // nil if colors are inline
+	err     error
+}
+
+// NewBinaryDecoder creates a decoder for data with inline colors
+func NewBinaryDecoder(data []byte) *BinaryDecoder {
+	return &BinaryDecoder{reader: bytes.NewReader(data)}
+}
+
+// Err returns the first error that occurred while decoding
+func (decoder *BinaryDecoder) Err() error {
+	return decoder.err
+}
+
+func (decoder *BinaryDecoder) fail(err error) {
+	if decoder.err == nil {
+		decoder.err = err
+	}
+}
+
+// ReadUvarint reads an unsigned integer
+func (decoder *BinaryDecoder) ReadUvarint() uint64 {
+	if decoder.err != nil {
+		return 0
+	}
+	value, err := binary.ReadUvarint(decoder.reader)
+	decoder.fail(err)
+	return value
+}
+
+// ReadVarint reads a signed integer
+func (decoder *BinaryDecoder) ReadVarint() int64 {
+	if decoder.err != nil {
+		return 0
+	}
+	value, err := binary.ReadVarint(decoder.reader)
+	decoder.fail(err)
+	return value
+}
+
+// ReadFixed reads a decimal written by WriteFixed
+func (decoder *BinaryDecoder) ReadFixed() float32 {
+	return float32(float64(decoder.ReadVarint()) / fixedPointScale)
+}
+
+// ReadBool reads a boolean
+func (decoder *BinaryDecoder) ReadBool() bool {
+	if decoder.err != nil {
+		return false
+	}
+	value, err := decoder.reader.ReadByte()
+	decoder.fail(err)
+	return value != 0
+}
+
+// ReadString reads a length-prefixed string
+func (decoder *BinaryDecoder) ReadString() string {
+	length := decoder.ReadUvarint()
+	if decoder.err != nil {
+		return ""
+	}
+	if length > uint64(decoder.reader.Len()) {
+		decoder.fail(fmt.Errorf("Invalid string length %v", length))
+		return ""
+	}
+	value := make([]byte, length)
+	io.ReadFull(decoder.reader, value)
+	return string(value)
+}
+
+// ReadColor reads a color written by WriteColor
+func (decoder *BinaryDecoder) ReadColor() *color.NRGBA {
+	if decoder.palette == nil {
+		rgba := make([]byte, 4)
+		if decoder.err == nil {
+			_, err := io.ReadFull(decoder.reader, rgba)
+			decoder.fail(err)
+		}
+		return &color.NRGBA{R: rgba[0], G: rgba[1], B: rgba[2], A: rgba[3]}
+	}
+	index := decoder.ReadUvarint()
+	if decoder.err != nil {
+		return &color.NRGBA{A: 255}
+	}
+	if index >= uint64(len(decoder.palette)) {
+		decoder.fail(fmt.Errorf("Invalid palette index %v", index))
+		return &color.NRGBA{A: 255}
+	}
+	clr := decoder.palette[index]
+	return &clr
+}
+
+// SaveInstructionBinary encodes a single instruction, with an inline color
+func SaveInstructionBinary(instruction Instruction) []byte {
+	encoder := NewBinaryEncoder()
+	instruction.SaveBinary(encoder)
+	return encoder.Bytes()
+}
+
+// LoadInstructionBinary will load an instruction that was saved with SaveInstructionBinary
+func LoadInstructionBinary(instructionType string, data []byte) (Instruction, error) {
+	decoder := NewBinaryDecoder(data)
+	return loadInstructionBinary(instructionType, decoder)
+}
+
+func loadInstructionBinary(instructionType string, decoder *BinaryDecoder) (Instruction, error) {
+	_, err := GetInstructionType(instructionType)
+	if err != nil {
+		return nil, err
+	}
+	instruction := objectPool.BorrowInstruction(instructionType)
+	instruction.LoadBinary(decoder)
+	if decoder.Err() != nil {
+		objectPool.ReturnInstruction(instruction)
+		return nil, fmt.Errorf("Error decoding %v instruction: %v", instructionType, decoder.Err().Error())
+	}
+	return instruction, nil
+}
+
+// IsBinaryOrganism returns true if the data is a binary encoded organism
+func IsBinaryOrganism(data []byte) bool {
+	return bytes.HasPrefix(data, binaryOrganismMagic)
+}
+
+// SaveBinary saves the organism in the compact binary encoding. The layout is:
+//
+//	magic, version
+//	palette: count, then rgba for each color
+//	instruction types: count, then each type name
+//	background: flag, then palette index
+//	instructions: count, then type index and data for each instruction
+func (organism *Organism) SaveBinary() []byte {
+	// Instructions are encoded first to collect the palette and types
+	body := newPaletteEncoder()
+	typeIndexes := map[string]int{}
+	typeNames := []string{}
+	body.WriteBool(organism.Background != nil)
+	if organism.Background != nil {
+		body.WriteColor(organism.Background)
+	}
+	body.WriteUvarint(uint64(len(organism.Instructions)))
+	for _, instruction := range organism.Instructions {
+		index, has := typeIndexes[instruction.Type()]
+		if !has {
+			index = len(typeNames)
+			typeIndexes[instruction.Type()] = index
+			typeNames = append(typeNames, instruction.Type())
+		}
+		body.WriteUvarint(uint64(index))
+		instruction.SaveBinary(body)
+	}
+
+	encoder := NewBinaryEncoder()
+	encoder.buf.Write(binaryOrganismMagic)
+	encoder.WriteUvarint(binaryOrganismVersion)
+	encoder.WriteUvarint(uint64(len(body.colors)))
+	for i := range body.colors {
+		encoder.WriteColor(&body.colors[i])
+	}
+	encoder.WriteUvarint(uint64(len(typeNames)))
+	for _, name := range typeNames {
+		encoder.WriteString(name)
+	}
+	encoder.buf.Write(body.Bytes())
+	return encoder.Bytes()
+}
+
+// LoadBinary loads an organism that was saved with SaveBinary
+func (organism *Organism) LoadBinary(data []byte) error {
+	if !IsBinaryOrganism(data) {
+		return fmt.Errorf("Data is not a binary encoded organism")
+	}
+	decoder := NewBinaryDecoder(data[len(binaryOrganismMagic):])
+	version := decoder.ReadUvarint()
+	if decoder.Err() == nil && version != binaryOrganismVersion {
+		return fmt.Errorf("Binary organism version %v is not supported", version)
+	}
+	colorCount := decoder.ReadUvarint()
+	if colorCount > uint64(len(data)) {
+		return fmt.Errorf("Invalid binary organism palette size %v", colorCount)
+	}
+	palette := make([]color.NRGBA, 0, colorCount)
+	for i := uint64(0); i < colorCount && decoder.Err() == nil; i++ {
+		palette = append(palette, *decoder.ReadColor())
+	}
+	typeCount := decoder.ReadUvarint()
+	typeNames := []string{}
+	for i := uint64(0); i < typeCount && decoder.Err() == nil; i++ {
+		typeNames = append(typeNames, decoder.ReadString())
+	}
+	decoder.palette = palette
+	if decoder.ReadBool() {
+		organism.Background = decoder.ReadColor()
+	}
+	instructionCount := decoder.ReadUvarint()
+	for i := uint64(0); i < instructionCount && decoder.Err() == nil; i++ {
+		typeIndex := decoder.ReadUvarint()
+		if typeIndex >= uint64(len(typeNames)) {
+			return fmt.Errorf("Invalid binary organism type index %v", typeIndex)
+		}
+		instruction, err := loadInstructionBinary(typeNames[typeIndex], decoder)
+		if err != nil {
+			return err
+		}
+		organism.Instructions = append(organism.Instructions, instruction)
+	}
+	if decoder.Err() != nil {
+		return fmt.Errorf("Error decoding binary organism: %v", decoder.Err().Error())
+	}
+	return nil
+}
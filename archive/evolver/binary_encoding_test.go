@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+// testOrganism returns an organism with one instruction of each type that
+// doesn't need a brush set. Values have at most 4 decimal places, like the
+// values of mutated instructions.
+func testOrganism() *Organism {
+	return &Organism{
+		Background: &color.NRGBA{10, 20, 30, 255},
+		Instructions: []Instruction{
+			&Circle{X: 12.5, Y: 7.25, Radius: 4.0625, Color: &color.NRGBA{200, 100, 50, 128}},
+			&Line{StartX: 1, StartY: 2.5, EndX: 30.1234, EndY: 20, Width: 3, Color: &color.NRGBA{0, 255, 0, 64}},
+			&Polygon{
+				X: 20, Y: 15,
+				Points: []Polypoint{{Distance: 5, Angle: 0.5}, {Distance: 7.5, Angle: 2}, {Distance: 6, Angle: 4.25}},
+				Color:  &color.NRGBA{200, 100, 50, 128},
+			},
+			// The same color as the circle, so the palette is shared
+			&Circle{X: 0.0001, Y: 29.9999, Radius: 1, Color: &color.NRGBA{200, 100, 50, 128}},
+		},
+	}
+}
+
+func TestOrganismEncodingsRoundTrip(t *testing.T) {
+	for _, encoding := range []string{EncodingText, EncodingBinary} {
+		original := testOrganism()
+		data := original.SaveEncoded(encoding)
+		if IsBinaryOrganism(data) != (encoding == EncodingBinary) {
+			t.Errorf("%v: IsBinaryOrganism returned %v", encoding, IsBinaryOrganism(data))
+		}
+
+		loaded := &Organism{}
+		err := loaded.Load(data)
+		if err != nil {
+			t.Fatalf("%v: %v", encoding, err.Error())
+		}
+		if loaded.Hash() != original.Hash() {
+			t.Errorf("%v: Expected hash %v, got %v", encoding, original.Hash(), loaded.Hash())
+		}
+		if len(loaded.Instructions) != len(original.Instructions) {
+			t.Errorf("%v: Expected %v instructions, got %v", encoding, len(original.Instructions), len(loaded.Instructions))
+		}
+		if *loaded.Background != *original.Background {
+			t.Errorf("%v: Expected background %v, got %v", encoding, *original.Background, *loaded.Background)
+		}
+	}
+}
+
+func TestBinaryOrganismIsSmaller(t *testing.T) {
+	organism := testOrganism()
+	text, binary := organism.Save(), organism.SaveBinary()
+	if len(binary) >= len(text) {
+		t.Errorf("Expected binary encoding (%v bytes) to be smaller than text encoding (%v bytes)", len(binary), len(text))
+	}
+}
+
+func TestLoadTruncatedBinaryOrganism(t *testing.T) {
+	data := testOrganism().SaveBinary()
+	for _, length := range []int{len(binaryOrganismMagic), len(data) / 2, len(data) - 1} {
+		organism := &Organism{}
+		if err := organism.Load(data[:length]); err == nil {
+			t.Errorf("Expected an error loading %v of %v bytes", length, len(data))
+		}
+	}
+}
+
+func TestPatchOperationEncodingRoundTrip(t *testing.T) {
+	for _, instruction := range testOrganism().Instructions {
+		operation := PatchOperation{
+			OperationType:   PatchOperationAppend,
+			InstructionType: instruction.Type(),
+			InstructionData: instruction.Save(),
+		}
+
+		binary, err := operation.Encode(EncodingBinary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if binary.Encoding != EncodingBinary {
+			t.Errorf("Expected encoding %v, got '%v'", EncodingBinary, binary.Encoding)
+		}
+		text, err := binary.Encode(EncodingText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text.Encoding != "" || !bytes.Equal(text.InstructionData, operation.InstructionData) {
+			t.Errorf("Expected %v, got %v", string(operation.InstructionData), string(text.InstructionData))
+		}
+
+		loaded, err := binary.LoadInstruction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Hash() != instruction.Hash() {
+			t.Errorf("Expected hash %v, got %v", instruction.Hash(), loaded.Hash())
+		}
+	}
+}
+
+func TestPatchEncodeKeepsOperationsWithoutInstructions(t *testing.T) {
+	patch := &Patch{
+		Baseline: "a",
+		Target:   "b",
+		Operations: []PatchOperation{
+			{OperationType: PatchOperationBackground, Background: "#102030"},
+			{OperationType: PatchOperationDelete, InstructionHash1: "c"},
+		},
+	}
+
+	encoded, err := patch.Encode(EncodingBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer objectPool.ReturnPatch(encoded)
+	if encoded.Baseline != "a" || encoded.Target != "b" || len(encoded.Operations) != 2 {
+		t.Fatalf("Expected a copy of %v, got %v", *patch, *encoded)
+	}
+	for i, operation := range encoded.Operations {
+		expected := patch.Operations[i]
+		if operation.OperationType != expected.OperationType || operation.Background != expected.Background ||
+			operation.InstructionHash1 != expected.InstructionHash1 || operation.Encoding != "" || operation.InstructionData != nil {
+			t.Errorf("Expected %v, got %v", expected, operation)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/fogleman/gg"
+	pool "github.com/jolestar/go-commons-pool"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// TypeBrush is the type name for brush strokes
+const TypeBrush = "brush"
+
+func init() {
+	RegisterInstructionType(&InstructionType{
+		Name: TypeBrush,
+		NewFactory: func() pool.PooledObjectFactory {
+			return NewBrushFactory()
+		},
+		NewMutator: func(config *Config, imageWidth float32, imageHeight float32) InstructionMutator {
+			return NewBrushMutator(config, imageWidth, imageHeight)
+		},
+		Load: func(data []byte) Instruction {
+			return loadPooledInstruction(TypeBrush, data)
+		},
+	})
+}
+
+// A Brush is a rotated, tinted sprite from the global brush set. The json
+// form matches the brush strokes of the WebGL evolver.
+type Brush struct {
+	X          float32      `json:"x"`
+	Y          float32      `json:"y"`
+	Rotation   float32      `json:"rotation"` // radians
+	Deleted    bool         `json:"deleted"`
+	SavedColor []float32    `json:"color"` // rgba, 0-1
+	BrushIndex int          `json:"brushIndex"`
+	Color      *color.NRGBA `json:"-"`
+	hash       string
+	bounds     Rect // Cache bounds
+}
+
+// Execute paints the brush sprite onto the canvas of the context. Renderer
+// paints brushes onto its pixels directly, with draw.
+func (brush *Brush) Execute(ctx *gg.Context) {
+	if dst, ok := ctx.Image().(*image.RGBA); ok {
+		brush.draw(dst)
+		return
+	}
+	// Other canvases get the sprite composited over them as a layer
+	layer := image.NewRGBA(ctx.Image().Bounds())
+	brush.draw(layer)
+	ctx.DrawImage(layer, 0, 0)
+}
+
+// draw paints the brush sprite onto the pixels of the canvas
+func (brush *Brush) draw(dst *image.RGBA) {
+	if brush.Deleted {
+		return
+	}
+	sr := brushSet.SourceRect(brush.BrushIndex)
+	scale := float64(brushSet.Shrinkage())
+	sin, cos := math.Sincos(float64(brush.Rotation))
+	// center of the brush in the atlas
+	cx := float64(sr.Min.X+sr.Max.X) / 2.0
+	cy := float64(sr.Min.Y+sr.Max.Y) / 2.0
+	a, b := scale*cos, -scale*sin
+	d, e := scale*sin, scale*cos
+	// Maps atlas coordinates to canvas coordinates
+	transform := f64.Aff3{
+		a, b, float64(brush.X) - a*cx - b*cy,
+		d, e, float64(brush.Y) - d*cx - e*cy,
+	}
+	draw.ApproxBiLinear.Transform(dst, transform, image.NewUniform(brush.Color), sr, draw.Over, &draw.Options{
+		SrcMask: brushSet.Atlas(),
+	})
+}
+
+// Scale returns a scaled copy of the brush. Brush sizes are determined by
+// the canvas size, so only the position is affected.
+func (brush *Brush) Scale(factor float32) Instruction {
+	clone := brush.Clone().(*Brush)
+	clone.X *= factor
+	clone.Y *= factor
+	clone.bounds = Rect{}
+	clone.hash = ""
+	return clone
+}
+
+// Save saves the brush to a persisted form
+func (brush *Brush) Save() []byte {
+	brush.SavedColor = brush.SavedColor[:0]
+	brush.SavedColor = append(
+		brush.SavedColor,
+		float32(brush.Color.R)/255.0,
+		float32(brush.Color.G)/255.0,
+		float32(brush.Color.B)/255.0,
+		float32(brush.Color.A)/255.0,
+	)
+	data, _ := json.Marshal(brush)
+	return data
+}
+
+// Load loads the brush from a persisted form
+func (brush *Brush) Load(data []byte) {
+	json.Unmarshal(data, brush)
+	brush.Color = &color.NRGBA{A: 255}
+	if len(brush.SavedColor) >= 3 {
+		brush.Color.R = uint8(math.Round(float64(brush.SavedColor[0] * 255)))
+		brush.Color.G = uint8(math.Round(float64(brush.SavedColor[1] * 255)))
+		brush.Color.B = uint8(math.Round(float64(brush.SavedColor[2] * 255)))
+	}
+	if len(brush.SavedColor) >= 4 {
+		brush.Color.A = uint8(math.Round(float64(brush.SavedColor[3] * 255)))
+	}
+}
+
+// SaveBinary saves the brush in the compact binary encoding
+func (brush *Brush) SaveBinary(encoder *BinaryEncoder) {
+	encoder.WriteColor(brush.Color)
+	encoder.WriteFixed(brush.X)
+	encoder.WriteFixed(brush.Y)
+	encoder.WriteFixed(brush.Rotation)
+	encoder.WriteUvarint(uint64(brush.BrushIndex))
+	encoder.WriteBool(brush.Deleted)
+}
+
+// LoadBinary loads the brush from the compact binary encoding
+func (brush *Brush) LoadBinary(decoder *BinaryDecoder) {
+	brush.Color = decoder.ReadColor()
+	brush.X = decoder.ReadFixed()
+	brush.Y = decoder.ReadFixed()
+	brush.Rotation = decoder.ReadFixed()
+	brush.BrushIndex = int(decoder.ReadUvarint())
+	brush.Deleted = decoder.ReadBool()
+}
+
+// Type returns "brush" type
+func (brush *Brush) Type() string {
+	return TypeBrush
+}
+
+// Clone returns a deep copy of the brush
+func (brush *Brush) Clone() Instruction {
+	newBrush := objectPool.BorrowInstruction(TypeBrush).(*Brush)
+	newColor := *brush.Color
+	newBrush.Color = &newColor
+	newBrush.X = brush.X
+	newBrush.Y = brush.Y
+	newBrush.Rotation = brush.Rotation
+	newBrush.Deleted = brush.Deleted
+	newBrush.BrushIndex = brush.BrushIndex
+	newBrush.hash = brush.hash
+	newBrush.bounds = brush.bounds
+	return newBrush
+}
+
+// SVG returns an empty string. Brush strokes are sprites from the brush
+// set atlas and have no vector form.
+func (brush *Brush) SVG() string {
+	return ""
+}
+
+// Hash returns a (probably) unique hash that represents this particular brush stroke
+func (brush *Brush) Hash() string {
+	if brush.hash == "" {
+		buf := objectPool.BorrowByteBuffer()
+		buf.WriteString(SaveColorHex(brush.Color))
+		buf.WriteString(fmt.Sprintf("|%.4f|%.4f|%.4f|%v|%v", brush.X, brush.Y, brush.Rotation, brush.BrushIndex, brush.Deleted))
+		brush.hash = fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
+		objectPool.ReturnByteBuffer(buf)
+	}
+	return brush.hash
+}
+
+// RecalculateHash clears the cached hash and calculates it again
+func (brush *Brush) RecalculateHash() {
+	brush.hash = ""
+	brush.Hash()
+}
+
+// GetColor returns the color of the brush
+func (brush *Brush) GetColor() *color.NRGBA {
+	return brush.Color
+}
+
+// SetColor replaces the color of the brush
+func (brush *Brush) SetColor(clr *color.NRGBA) {
+	brush.Color = clr
+	brush.hash = ""
+}
+
+// Bounds returns the rectangular bounds of the rotated brush stroke
+func (brush *Brush) Bounds() Rect {
+	if brush.bounds != (Rect{}) {
+		return brush.bounds
+	}
+	width, height := brushSet.StrokeSize(brush.BrushIndex)
+	sin, cos := math.Sincos(float64(brush.Rotation))
+	// half extents of the rotated rectangle
+	halfWidth := float32(math.Abs(cos)*float64(width)+math.Abs(sin)*float64(height)) / 2.0
+	halfHeight := float32(math.Abs(sin)*float64(width)+math.Abs(cos)*float64(height)) / 2.0
+	brush.bounds = Rect{
+		Left:   brush.X - halfWidth,
+		Top:    brush.Y - halfHeight,
+		Right:  brush.X + halfWidth,
+		Bottom: brush.Y + halfHeight,
+	}
+	return brush.bounds
+}
+
+// SaveBrushStrokes saves the brush strokes from a list of instructions as a json
+// array that can be imported into the WebGL evolver. Other instruction types are skipped.
+func SaveBrushStrokes(instructions []Instruction) []byte {
+	strokes := []json.RawMessage{}
+	for _, instruction := range instructions {
+		if instruction.Type() == TypeBrush {
+			strokes = append(strokes, instruction.Save())
+		}
+	}
+	data, _ := json.Marshal(strokes)
+	return data
+}
+
+// LoadBrushStrokes loads a json array of brush strokes exported from the WebGL evolver
+func LoadBrushStrokes(data []byte) ([]Instruction, error) {
+	strokes := []json.RawMessage{}
+	err := json.Unmarshal(data, &strokes)
+	if err != nil {
+		return nil, err
+	}
+	instructions := make([]Instruction, 0, len(strokes))
+	for _, stroke := range strokes {
+		instruction, err := LoadInstruction(TypeBrush, stroke)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+	}
+	return instructions, nil
+}
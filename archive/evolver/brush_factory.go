@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+
+	pool "github.com/jolestar/go-commons-pool"
+)
+
+// BrushFactory helps pool Brushes
+type BrushFactory struct{}
+
+// NewBrushFactory creates a new BrushFactory
+func NewBrushFactory() *BrushFactory {
+	return &BrushFactory{}
+}
+
+// MakeObject creates new Brushes
+func (f *BrushFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
+	return pool.NewPooledObject(&Brush{}), nil
+}
+
+// DestroyObject destroys objects
+func (f *BrushFactory) DestroyObject(ctx context.Context, object *pool.PooledObject) error {
+	return nil
+}
+
+// ValidateObject validates objects
+func (f *BrushFactory) ValidateObject(ctx context.Context, object *pool.PooledObject) bool {
+	// TODO: should any validation be performed?
+	return true
+}
+
+// ActivateObject activates objects
+func (f *BrushFactory) ActivateObject(ctx context.Context, object *pool.PooledObject) error {
+	return nil
+}
+
+// PassivateObject resets a Brush to its default state.
+func (f *BrushFactory) PassivateObject(ctx context.Context, object *pool.PooledObject) error {
+	obj := object.Object.(*Brush)
+	obj.X = 0
+	obj.Y = 0
+	obj.Rotation = 0
+	obj.Deleted = false
+	obj.BrushIndex = 0
+	obj.SavedColor = obj.SavedColor[:0]
+	obj.Color = nil
+	obj.bounds = Rect{}
+	obj.hash = ""
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+// A BrushMutator creates random mutations in brush stroke instructions.
+type BrushMutator struct {
+	config       *Config
+	imageWidth   float32
+	imageHeight  float32
+	brushIndexes []int // Brushes that are allowed by config.EnabledBrushTags
+}
+
+// NewBrushMutator returns a new instance of `BrushMutator`
+func NewBrushMutator(config *Config, imageWidth float32, imageHeight float32) *BrushMutator {
+	mut := new(BrushMutator)
+	mut.config = config
+	mut.imageWidth = imageWidth
+	mut.imageHeight = imageHeight
+	enabledTags := map[string]bool{}
+	for _, tag := range config.EnabledBrushTags {
+		enabledTags[tag] = true
+	}
+	for i := 0; i < brushSet.BrushCount(); i++ {
+		if len(enabledTags) == 0 || enabledTags[brushSet.BrushTag(i)] {
+			mut.brushIndexes = append(mut.brushIndexes, i)
+		}
+	}
+	if len(mut.brushIndexes) == 0 {
+		panic("No brushes are enabled, check EnabledBrushTags in config.json")
+	}
+	return mut
+}
+
+func (mut *BrushMutator) MutateInstruction(instruction Instruction) {
+	brush := instruction.(*Brush)
+	// color
+	// coordinates
+	// rotation
+	// brush
+	switch rand.Int31n(4) {
+	case 0:
+		mut.mutateColor(brush)
+	case 1:
+		mut.mutateCoordinates(brush)
+	case 2:
+		mut.mutateRotation(brush)
+	default:
+		brush.BrushIndex = mut.randomBrushIndex()
+	}
+	brush.bounds = Rect{}
+	brush.RecalculateHash()
+}
+
+func (mut *BrushMutator) InstructionType() string {
+	return TypeBrush
+}
+
+// Mutate Color
+// Hue, Sat, Val
+
+func (mut *BrushMutator) mutateColor(brush *Brush) {
+	switch rand.Int31n(3) {
+	case 0:
+		mut.mutateHue(brush)
+	case 1:
+		mut.mutateSaturation(brush)
+	default:
+		mut.mutateLightness(brush)
+	}
+}
+
+func (mut *BrushMutator) mutateHue(brush *Brush) {
+	hue, sat, lightness := MakeColor(brush.Color).Hsl()
+	newHue := mut.mutateValue(0, 360, mut.config.MinHueMutation, mut.config.MaxHueMutation, float32(hue))
+	brush.Color = WithAlpha(colorful.Hsl(float64(newHue), sat, lightness), brush.Color.A)
+}
+
+func (mut *BrushMutator) mutateSaturation(brush *Brush) {
+	hue, sat, lightness := MakeColor(brush.Color).Hsl()
+	newSat := mut.mutateValue(0, 1, mut.config.MinSaturationMutation, mut.config.MaxSaturationMutation, float32(sat))
+	brush.Color = WithAlpha(colorful.Hsl(hue, float64(newSat), lightness), brush.Color.A)
+}
+
+func (mut *BrushMutator) mutateLightness(brush *Brush) {
+	hue, sat, lightness := MakeColor(brush.Color).Hsl()
+	newLightness := mut.mutateValue(0, 1, mut.config.MinValueMutation, mut.config.MaxValueMutation, float32(lightness))
+	brush.Color = WithAlpha(colorful.Hsl(hue, sat, float64(newLightness)), brush.Color.A)
+}
+
+// Mutate Coordinates
+// Increase/Decrease X
+// Increase/Decrease Y
+func (mut *BrushMutator) mutateCoordinates(brush *Brush) {
+	brush.X = mut.mutateValue(0, mut.imageWidth, mut.config.MinCoordinateMutation, mut.config.MaxCoordinateMutation, brush.X)
+	brush.Y = mut.mutateValue(0, mut.imageHeight, mut.config.MinCoordinateMutation, mut.config.MaxCoordinateMutation, brush.Y)
+}
+
+func (mut *BrushMutator) mutateRotation(brush *Brush) {
+	brush.Rotation = mut.mutateValue(0, math.Pi*2, mut.config.MinRotationMutation, mut.config.MaxRotationMutation, brush.Rotation)
+}
+
+func (mut *BrushMutator) randomBrushIndex() int {
+	return mut.brushIndexes[rand.Intn(len(mut.brushIndexes))]
+}
+
+func (mut *BrushMutator) RandomInstruction() Instruction {
+	return mut.RandomInstructionAt(rand.Float32()*mut.imageWidth, rand.Float32()*mut.imageHeight)
+}
+
+func (mut *BrushMutator) RandomInstructionAt(x float32, y float32) Instruction {
+	brush := objectPool.BorrowInstruction(TypeBrush).(*Brush)
+	brush.X = mut.trunc(x)
+	brush.Y = mut.trunc(y)
+	brush.Rotation = mut.trunc(rand.Float32() * math.Pi * 2.0)
+	brush.BrushIndex = mut.randomBrushIndex()
+	brush.Color = RandomColor(1, 1)
+	return brush
+}
+
+func (mut *BrushMutator) mutateValue(min float32, max float32, minDelta float32, maxDelta float32, value float32) float32 {
+	amt := rand.Float32()*(maxDelta-minDelta) + minDelta
+	value = value + amt
+	// Make the new value wrap around at the inclusive boundaries
+	for value < min {
+		value = value + (max - min)
+	}
+	for value > max {
+		value = value - (max - min)
+	}
+	return mut.trunc(value)
+}
+
+func (mut *BrushMutator) trunc(value float32) float32 {
+	v, _ := strconv.ParseFloat(fmt.Sprintf("%.4f", value), 32)
+	return float32(v)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+
+	// Brush atlases may be stored in either format
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// BrushSetData is the serializable form of a brush set. It uses the same
+// json layout as the brush sets in the WebGL evolver, so that brush sets
+// can be shared between the two.
+type BrushSetData struct {
+	// BrushDataURI is either a data uri containing the atlas image, or a
+	// path to the atlas image relative to the brush set file.
+	BrushDataURI string      `json:"brushDataUri"`
+	Width        int         `json:"width"`
+	Height       int         `json:"height"`
+	Brushes      []BrushRect `json:"brushes"`
+}
+
+// A BrushRect is the location of a single brush inside of the atlas image.
+type BrushRect struct {
+	Tag    string `json:"tag"`
+	Left   int    `json:"left"`
+	Top    int    `json:"top"`
+	Right  int    `json:"right"`
+	Bottom int    `json:"bottom"`
+}
+
+// A BrushSet is a collection of brush sprites packed into a single atlas image.
+// Only the alpha channel of the atlas is used, brush strokes supply their own color.
+type BrushSet struct {
+	data  *BrushSetData
+	atlas *image.Alpha
+	// shrinkage scales brushes down when painting images that are smaller than the atlas
+	shrinkage float32
+}
+
+// LoadBrushSet loads a brush set json file along with its atlas image
+func LoadBrushSet(filename string) (*BrushSet, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	brushSetData := &BrushSetData{}
+	err = json.Unmarshal(data, brushSetData)
+	if err != nil {
+		return nil, err
+	}
+	if len(brushSetData.Brushes) == 0 {
+		return nil, fmt.Errorf("Brush set '%v' does not contain any brushes", filename)
+	}
+	var imageData []byte
+	if strings.HasPrefix(brushSetData.BrushDataURI, "data:") {
+		parts := strings.SplitN(brushSetData.BrushDataURI, ",", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid data uri in brush set '%v'", filename)
+		}
+		imageData, err = base64.StdEncoding.DecodeString(parts[1])
+	} else {
+		atlasFile := brushSetData.BrushDataURI
+		if !filepath.IsAbs(atlasFile) {
+			atlasFile = filepath.Join(filepath.Dir(filename), atlasFile)
+		}
+		imageData, err = ioutil.ReadFile(atlasFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, err
+	}
+	return NewBrushSet(brushSetData, img), nil
+}
+
+// NewBrushSet creates a new BrushSet from brush definitions and an atlas image.
+func NewBrushSet(data *BrushSetData, img image.Image) *BrushSet {
+	set := new(BrushSet)
+	set.data = data
+	set.atlas = makeBrushAlpha(img)
+	set.shrinkage = 1
+	return set
+}
+
+// makeBrushAlpha converts an atlas image into an alpha mask. If the image
+// has no transparent pixels, a white background is assumed and darker pixels
+// are treated as more opaque. This matches the behavior of the WebGL evolver.
+func makeBrushAlpha(img image.Image) *image.Alpha {
+	bounds := img.Bounds()
+	mask := image.NewAlpha(bounds)
+	transparentBackground := false
+	maxValue := uint32(0)
+	minValue := uint32(255)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, _, _, a := img.At(x, y).RGBA()
+			r, a = r>>8, a>>8
+			if r > maxValue {
+				maxValue = r
+			}
+			if r < minValue {
+				minValue = r
+			}
+			// alpha of less than 10 is as good as fully transparent
+			transparentBackground = transparentBackground || a <= 10
+		}
+	}
+	alphaMultiplier := float64(maxValue-minValue) / 255.0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, _, _, a := img.At(x, y).RGBA()
+			r, a = r>>8, a>>8
+			if !transparentBackground {
+				a = uint32(math.Floor(255.0 - float64(r-minValue)*alphaMultiplier))
+			}
+			mask.Pix[mask.PixOffset(x, y)] = uint8(a)
+		}
+	}
+	return mask
+}
+
+// SetCanvasSize calculates how much to shrink brushes when painting on a
+// canvas of the specified size. The atlas is effectively scaled to be no
+// larger than the canvas.
+func (set *BrushSet) SetCanvasSize(width int, height int) {
+	shrinkage := float32(width) / float32(set.data.Width)
+	if s := float32(height) / float32(set.data.Height); s < shrinkage {
+		shrinkage = s
+	}
+	if shrinkage > 1 {
+		shrinkage = 1
+	}
+	set.shrinkage = shrinkage
+}
+
+// BrushCount returns the number of brushes in the set
+func (set *BrushSet) BrushCount() int {
+	return len(set.data.Brushes)
+}
+
+// BrushTag returns the tag of the brush at the specified index
+func (set *BrushSet) BrushTag(brushIndex int) string {
+	return set.data.Brushes[brushIndex].Tag
+}
+
+// SourceRect returns the location of a brush inside of the atlas image
+func (set *BrushSet) SourceRect(brushIndex int) image.Rectangle {
+	brush := set.data.Brushes[brushIndex]
+	return image.Rect(brush.Left, brush.Top, brush.Right, brush.Bottom)
+}
+
+// StrokeSize returns the size of a brush stroke on the canvas
+func (set *BrushSet) StrokeSize(brushIndex int) (float32, float32) {
+	brush := set.data.Brushes[brushIndex]
+	return float32(brush.Right-brush.Left) * set.shrinkage, float32(brush.Bottom-brush.Top) * set.shrinkage
+}
+
+// Atlas returns the alpha mask of the atlas image
+func (set *BrushSet) Atlas() *image.Alpha {
+	return set.atlas
+}
+
+// Shrinkage returns the current scale factor of brushes on the canvas
+func (set *BrushSet) Shrinkage() float32 {
+	return set.shrinkage
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+// useTestBrushSet replaces the global brush set with an atlas of two brushes
+// on a white background: a black 10x6 block and a block that is left blank
+func useTestBrushSet(t *testing.T) {
+	t.Helper()
+	atlas := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			if x >= 5 && x < 15 && y >= 7 && y < 13 {
+				atlas.Set(x, y, color.Black)
+			} else {
+				atlas.Set(x, y, color.White)
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	png.Encode(buf, atlas)
+	data, _ := json.Marshal(&BrushSetData{
+		BrushDataURI: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Width:        40,
+		Height:       20,
+		Brushes: []BrushRect{
+			{Tag: "block", Left: 0, Top: 0, Right: 20, Bottom: 20},
+			{Tag: "blank", Left: 20, Top: 0, Right: 40, Bottom: 20},
+		},
+	})
+	filename := t.TempDir() + "/brushes.json"
+	err := ioutil.WriteFile(filename, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := LoadBrushSet(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := brushSet
+	brushSet = set
+	t.Cleanup(func() { brushSet = previous })
+	setCanvasSize(100, 100)
+}
+
+func TestLoadBrushSet(t *testing.T) {
+	set, err := LoadBrushSet("brushes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.BrushCount() == 0 {
+		t.Fatal("Expected brushes in the brush set")
+	}
+	atlasBounds := set.Atlas().Bounds()
+	for i := 0; i < set.BrushCount(); i++ {
+		if rect := set.SourceRect(i); !rect.In(atlasBounds) || rect.Empty() {
+			t.Errorf("Expected brush %v (%v) to be inside of the atlas %v", i, rect, atlasBounds)
+		}
+	}
+	set.SetCanvasSize(atlasBounds.Dx()/4, atlasBounds.Dy())
+	if shrinkage := set.Shrinkage(); math.Abs(float64(shrinkage)-0.25) > 0.01 {
+		t.Errorf("Expected brushes to shrink to 0.25 on a quarter width canvas, got %v", shrinkage)
+	}
+}
+
+func TestLoadBrushSetFromDataURI(t *testing.T) {
+	useTestBrushSet(t)
+	atlas := brushSet.Atlas()
+	// Without transparent pixels, dark pixels are opaque and white ones transparent
+	if alpha := atlas.AlphaAt(10, 10).A; alpha != 255 {
+		t.Errorf("Expected the black block to be opaque, got alpha %v", alpha)
+	}
+	if alpha := atlas.AlphaAt(30, 10).A; alpha != 0 {
+		t.Errorf("Expected the white background to be transparent, got alpha %v", alpha)
+	}
+}
+
+func TestBrushJSONMatchesWebGLStrokes(t *testing.T) {
+	// A stroke as saved by brushStroke.ts
+	stroke := `{"x":10.5,"y":20,"rotation":1.5,"deleted":false,"color":[1,0.5,0,0.25],"brushIndex":2}`
+	instructions, err := LoadBrushStrokes([]byte("[" + stroke + "]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	brush := instructions[0].(*Brush)
+	if brush.X != 10.5 || brush.Y != 20 || brush.Rotation != 1.5 || brush.BrushIndex != 2 || brush.Deleted {
+		t.Errorf("Expected the stroke to be loaded, got %+v", *brush)
+	}
+	if *brush.Color != (color.NRGBA{255, 128, 0, 64}) {
+		t.Errorf("Expected color %v, got %v", color.NRGBA{255, 128, 0, 64}, *brush.Color)
+	}
+
+	saved := []map[string]interface{}{}
+	err = json.Unmarshal(SaveBrushStrokes(append(instructions, testOrganism().Instructions...)), &saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 {
+		t.Fatalf("Expected only the brush stroke to be saved, got %v", saved)
+	}
+	expected := map[string]interface{}{}
+	json.Unmarshal([]byte(stroke), &expected)
+	for key, value := range expected {
+		if key == "color" {
+			continue
+		}
+		if !reflect.DeepEqual(saved[0][key], value) {
+			t.Errorf("Expected %v to be saved as %v, got %v", key, value, saved[0][key])
+		}
+	}
+	if len(saved[0]) != len(expected) {
+		t.Errorf("Expected the keys of %v, got %v", expected, saved[0])
+	}
+	reloaded, _ := LoadBrushStrokes(SaveBrushStrokes(instructions))
+	if reloaded[0].Hash() != brush.Hash() {
+		t.Error("Expected the brush to be the same after saving and loading it")
+	}
+}
+
+func TestRenderBrush(t *testing.T) {
+	useTestBrushSet(t)
+	background := &color.NRGBA{0, 0, 255, 255}
+	// The block is 10x6 around (10, 10) of the 20x20 brush, so after rotating
+	// by 90 degrees it covers (47, 35)-(53, 45) on the canvas
+	brush := &Brush{X: 50, Y: 40, Rotation: math.Pi / 2, BrushIndex: 0, Color: &color.NRGBA{255, 0, 0, 255}}
+	blank := &Brush{X: 20, Y: 20, BrushIndex: 1, Color: &color.NRGBA{0, 255, 0, 255}}
+	deleted := &Brush{X: 20, Y: 80, Deleted: true, Color: &color.NRGBA{0, 255, 0, 255}}
+	instructions := []Instruction{brush, blank, deleted}
+
+	renderer := NewRenderer(100, 100)
+	renderer.Render(background, instructions)
+	img := renderer.GetImage()
+	expected := map[image.Point]color.RGBA{
+		{50, 40}: {255, 0, 0, 255},
+		{50, 37}: {255, 0, 0, 255},
+		{50, 32}: {0, 0, 255, 255},
+		{45, 40}: {0, 0, 255, 255},
+		{20, 20}: {0, 0, 255, 255},
+		{20, 80}: {0, 0, 255, 255},
+	}
+	for point, clr := range expected {
+		if actual := img.At(point.X, point.Y); actual != clr {
+			t.Errorf("Expected %v at %v, got %v", clr, point, actual)
+		}
+	}
+	bounds := brush.Bounds()
+	if bounds != (Rect{Left: 40, Top: 30, Right: 60, Bottom: 50}) {
+		t.Errorf("Expected bounds (40, 30)-(60, 50), got %v", bounds)
+	}
+
+	// Painting through a gg context gives the same image
+	ctx := gg.NewContext(100, 100)
+	ctx.SetColor(background)
+	ctx.Clear()
+	for _, instruction := range instructions {
+		instruction.Execute(ctx)
+	}
+	if !bytes.Equal(ctx.Image().(*image.RGBA).Pix, img.(*image.RGBA).Pix) {
+		t.Error("Expected the gg context to be painted the same as the renderer")
+	}
+}
@@ -2,23 +2,40 @@ package main
 
 import (
 	"crypto/md5"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"image/color"
 
 	"github.com/fogleman/gg"
+	pool "github.com/jolestar/go-commons-pool"
 )
 
 const TypeCircle = "circle"
 
+func init() {
+	RegisterInstructionType(&InstructionType{
+		Name: TypeCircle,
+		NewFactory: func() pool.PooledObjectFactory {
+			return NewCircleFactory()
+		},
+		NewMutator: func(config *Config, imageWidth float32, imageHeight float32) InstructionMutator {
+			return NewCircleMutator(config, imageWidth, imageHeight)
+		},
+		Load: func(data []byte) Instruction {
+			return loadPooledInstruction(TypeCircle, data)
+		},
+	})
+}
+
 type Circle struct {
 	X          float32
 	Y          float32
 	Radius     float32
-	Color      color.Color `json:"-"`
-	SavedColor *SavedColor
+	Color      *color.NRGBA `json:"-"`
+	SavedColor *SavedColor  `json:",omitempty"`
+	HexColor   string       `json:",omitempty"`
 	hash       string
+	bounds     Rect // Cache bounds
 }
 
 // Execute draws a circle at point
@@ -31,20 +48,44 @@ func (circle *Circle) Execute(ctx *gg.Context) {
 func (circle *Circle) Scale(factor float32) Instruction {
 	clone := circle.Clone().(*Circle)
 	clone.X *= factor
-	clone.Radius *= factor
 	clone.Y *= factor
+	clone.Radius *= factor
+	clone.bounds = Rect{}
+	clone.hash = ""
 	return clone
 }
 
 func (circle *Circle) Save() []byte {
-	circle.SavedColor = SaveColor(circle.Color)
+	circle.HexColor = SaveColorHex(circle.Color)
 	data, _ := json.Marshal(circle)
 	return data
 }
 
 func (circle *Circle) Load(data []byte) {
 	json.Unmarshal(data, circle)
-	circle.Color = LoadColor(circle.SavedColor)
+	if circle.SavedColor != nil {
+		circle.Color = LoadColor(circle.SavedColor)
+		// The color will be saved as hex from now on
+		circle.SavedColor = nil
+	} else {
+		circle.Color = LoadColorHex(circle.HexColor)
+	}
+}
+
+// SaveBinary saves the circle in the compact binary encoding
+func (circle *Circle) SaveBinary(encoder *BinaryEncoder) {
+	encoder.WriteColor(circle.Color)
+	encoder.WriteFixed(circle.X)
+	encoder.WriteFixed(circle.Y)
+	encoder.WriteFixed(circle.Radius)
+}
+
+// LoadBinary loads the circle from the compact binary encoding
+func (circle *Circle) LoadBinary(decoder *BinaryDecoder) {
+	circle.Color = decoder.ReadColor()
+	circle.X = decoder.ReadFixed()
+	circle.Y = decoder.ReadFixed()
+	circle.Radius = decoder.ReadFixed()
 }
 
 func (circle *Circle) Type() string {
@@ -52,26 +93,64 @@ func (circle *Circle) Type() string {
 }
 
 func (circle *Circle) Clone() Instruction {
-	newCircle := *circle
-	return &newCircle
+	newCircle := objectPool.BorrowInstruction(TypeCircle).(*Circle)
+	// cheap deep copy of color
+	newColor := *circle.Color
+	newCircle.Color = &newColor
+	newCircle.HexColor = circle.HexColor
+	newCircle.X = circle.X
+	newCircle.Y = circle.Y
+	newCircle.Radius = circle.Radius
+	newCircle.hash = circle.hash
+	newCircle.bounds = circle.bounds
+	return newCircle
+}
+
+// SVG returns the circle as an svg circle element
+func (circle *Circle) SVG() string {
+	return fmt.Sprintf(
+		`<circle cx="%v" cy="%v" r="%v" %v/>`,
+		svgNumber(circle.X), svgNumber(circle.Y), svgNumber(circle.Radius), svgPaint("fill", circle.Color),
+	)
 }
 
 func (circle *Circle) Hash() string {
 	if circle.hash == "" {
-		r, g, b, _ := circle.Color.RGBA()
-		value := fmt.Sprintf("%v%v%v%v%v%v", circle.X, circle.Y, circle.Radius, r, g, b)
-		hasher := md5.New()
-		circle.hash = base64.StdEncoding.EncodeToString(hasher.Sum([]byte(value)))
+		buf := objectPool.BorrowByteBuffer()
+		buf.WriteString(SaveColorHex(circle.Color))
+		buf.WriteString(fmt.Sprintf("|%.4f|%.4f|%.4f", circle.X, circle.Y, circle.Radius))
+		circle.hash = fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
+		objectPool.ReturnByteBuffer(buf)
 	}
 	return circle.hash
 }
 
+func (circle *Circle) RecalculateHash() {
+	circle.hash = ""
+	circle.Hash()
+}
+
+// GetColor returns the color of the circle
+func (circle *Circle) GetColor() *color.NRGBA {
+	return circle.Color
+}
+
+// SetColor replaces the color of the circle
+func (circle *Circle) SetColor(clr *color.NRGBA) {
+	circle.Color = clr
+	circle.hash = ""
+}
+
 // Bounds returns the rectangular bounds of the circle
 func (circle *Circle) Bounds() Rect {
-	return Rect{
+	if circle.bounds != (Rect{}) {
+		return circle.bounds
+	}
+	circle.bounds = Rect{
 		Left:   circle.X - circle.Radius,
 		Right:  circle.X + circle.Radius,
 		Top:    circle.Y - circle.Radius,
 		Bottom: circle.Y + circle.Radius,
 	}
+	return circle.bounds
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+
+	pool "github.com/jolestar/go-commons-pool"
+)
+
+// CircleFactory helps pool Circles
+type CircleFactory struct{}
+
+// NewCircleFactory creates a new CircleFactory
+func NewCircleFactory() *CircleFactory {
+	return &CircleFactory{}
+}
+
+// MakeObject creates new Circles
+func (f *CircleFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
+	return pool.NewPooledObject(&Circle{}), nil
+}
+
+// DestroyObject destroys objects
+func (f *CircleFactory) DestroyObject(ctx context.Context, object *pool.PooledObject) error {
+	return nil
+}
+
+// ValidateObject validates objects
+func (f *CircleFactory) ValidateObject(ctx context.Context, object *pool.PooledObject) bool {
+	// TODO: should any validation be performed?
+	return true
+}
+
+// ActivateObject activates objects
+func (f *CircleFactory) ActivateObject(ctx context.Context, object *pool.PooledObject) error {
+	return nil
+}
+
+// PassivateObject resets a Circle to its default state.
+func (f *CircleFactory) PassivateObject(ctx context.Context, object *pool.PooledObject) error {
+	obj := object.Object.(*Circle)
+	obj.X = 0
+	obj.Y = 0
+	obj.Radius = 0
+	obj.Color = nil
+	obj.SavedColor = nil
+	obj.HexColor = ""
+	obj.hash = ""
+	obj.bounds = Rect{}
+	return nil
+}
@@ -1,8 +1,9 @@
 package main
 
 import (
-	"image/color"
+	"fmt"
 	"math/rand"
+	"strconv"
 
 	colorful "github.com/lucasb-eyer/go-colorful"
 )
@@ -37,6 +38,8 @@ func (mut *CircleMutator) MutateInstruction(instruction Instruction) {
 		// radius
 		mut.mutateCircleRadius(circle)
 	}
+	circle.bounds = Rect{}
+	circle.RecalculateHash()
 }
 
 func (mut *CircleMutator) InstructionType() string {
@@ -48,32 +51,44 @@ func (mut *CircleMutator) InstructionType() string {
 // Red, Green, Blue
 
 func (mut *CircleMutator) mutateColor(circle *Circle) {
-	switch rand.Int31n(3) {
+	// Opacity is only mutated if a range of opacities is allowed
+	numColorMutations := int32(3)
+	if mut.config.MaxOpacity > mut.config.MinOpacity {
+		numColorMutations = 4
+	}
+	switch rand.Int31n(numColorMutations) {
 	case 0:
 		mut.mutateHue(circle)
 	case 1:
 		mut.mutateSaturation(circle)
-	default:
+	case 2:
 		mut.mutateLightness(circle)
+	default:
+		mut.mutateOpacity(circle)
 	}
 }
 
 func (mut *CircleMutator) mutateHue(circle *Circle) {
 	hue, sat, lightness := MakeColor(circle.Color).Hsl()
 	newHue := mut.mutateValue(0, 360, mut.config.MinHueMutation, mut.config.MaxHueMutation, float32(hue))
-	circle.Color = LoadColor(SaveColor(colorful.Hsl(float64(newHue), sat, lightness)))
+	circle.Color = WithAlpha(colorful.Hsl(float64(newHue), sat, lightness), circle.Color.A)
 }
 
 func (mut *CircleMutator) mutateSaturation(circle *Circle) {
 	hue, sat, lightness := MakeColor(circle.Color).Hsl()
 	newSat := mut.mutateValue(0, 1, mut.config.MinSaturationMutation, mut.config.MaxSaturationMutation, float32(sat))
-	circle.Color = LoadColor(SaveColor(colorful.Hsl(hue, float64(newSat), lightness)))
+	circle.Color = WithAlpha(colorful.Hsl(hue, float64(newSat), lightness), circle.Color.A)
+}
+
+func (mut *CircleMutator) mutateOpacity(circle *Circle) {
+	opacity := mut.mutateValue(mut.config.MinOpacity, mut.config.MaxOpacity, mut.config.MinOpacityMutation, mut.config.MaxOpacityMutation, float32(circle.Color.A)/255.0)
+	circle.Color.A = uint8(opacity * 255)
 }
 
 func (mut *CircleMutator) mutateLightness(circle *Circle) {
 	hue, sat, lightness := MakeColor(circle.Color).Hsl()
 	newLightness := mut.mutateValue(0, 1, mut.config.MinValueMutation, mut.config.MaxValueMutation, float32(lightness))
-	circle.Color = LoadColor(SaveColor(colorful.Hsl(hue, sat, float64(newLightness))))
+	circle.Color = WithAlpha(colorful.Hsl(hue, sat, float64(newLightness)), circle.Color.A)
 }
 
 // Mutate Brush Size
@@ -95,17 +110,16 @@ func (mut *CircleMutator) mutateCoordinates(circle *Circle) {
 // Remove Instruction
 // Swap Instructions
 func (mut *CircleMutator) RandomInstruction() Instruction {
-	return &Circle{
-		X: rand.Float32() * mut.imageWidth,
-		Y: rand.Float32() * mut.imageHeight,
-		Color: &color.RGBA{
-			A: 255,
-			G: uint8(rand.Int31n(255)),
-			B: uint8(rand.Int31n(255)),
-			R: uint8(rand.Int31n(255)),
-		},
-		Radius: rand.Float32()*(mut.config.MaxCircleRadius-1) + 1,
-	}
+	return mut.RandomInstructionAt(rand.Float32()*mut.imageWidth, rand.Float32()*mut.imageHeight)
+}
+
+func (mut *CircleMutator) RandomInstructionAt(x float32, y float32) Instruction {
+	circle := objectPool.BorrowInstruction(TypeCircle).(*Circle)
+	circle.X = mut.trunc(x)
+	circle.Y = mut.trunc(y)
+	circle.Radius = mut.trunc(rand.Float32()*(mut.config.MaxCircleRadius-1) + 1)
+	circle.Color = RandomColor(mut.config.MinOpacity, mut.config.MaxOpacity)
+	return circle
 }
 
 func (mut *CircleMutator) mutateValue(min float32, max float32, minDelta float32, maxDelta float32, value float32) float32 {
@@ -118,5 +132,10 @@ func (mut *CircleMutator) mutateValue(min float32, max float32, minDelta float32
 	for value > max {
 		value = value - (max - min)
 	}
-	return value
+	return mut.trunc(value)
+}
+
+func (mut *CircleMutator) trunc(value float32) float32 {
+	v, _ := strconv.ParseFloat(fmt.Sprintf("%.4f", value), 32)
+	return float32(v)
 }
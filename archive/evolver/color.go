@@ -1,15 +1,26 @@
 package main
 
 import (
+	"fmt"
+	"image"
 	"image/color"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
 
 	colorful "github.com/lucasb-eyer/go-colorful"
 )
 
-// MakeColor returns a colorful.Color from an RGB color
+// MakeColor returns a colorful.Color from an RGB color. Alpha is ignored,
+// translucent colors are converted to their non-premultiplied form first.
 func MakeColor(clr color.Color) colorful.Color {
-	r, g, b, _ := clr.RGBA()
-	return MakeColorRGB(r, g, b)
+	nrgba := color.NRGBAModel.Convert(clr).(color.NRGBA)
+	return colorful.Color{
+		R: float64(nrgba.R) / 255.0,
+		G: float64(nrgba.G) / 255.0,
+		B: float64(nrgba.B) / 255.0,
+	}
 }
 
 func MakeColorRGB(r uint32, g uint32, b uint32) colorful.Color {
@@ -36,24 +47,29 @@ type SavedColor struct {
 
 // SaveColor saves the color to a persistable form
 // Deprecated: use SaveColorHex instead
-func SaveColor(color color.Color) *SavedColor {
-	r, g, b, a := color.RGBA()
+func SaveColor(clr color.Color) *SavedColor {
+	nrgba := color.NRGBAModel.Convert(clr).(color.NRGBA)
 	return &SavedColor{
-		R: uint8(r),
-		G: uint8(g),
-		B: uint8(b),
-		A: uint8(a),
+		R: nrgba.R,
+		G: nrgba.G,
+		B: nrgba.B,
+		A: nrgba.A,
 	}
 }
 
-// SaveColorHex saves the color to a hex string
+// SaveColorHex saves the color to a hex string. Opaque colors are saved
+// as #rrggbb, translucent colors as #rrggbbaa.
 func SaveColorHex(clr color.Color) string {
-	return MakeColor(clr).Hex()
+	nrgba := color.NRGBAModel.Convert(clr).(color.NRGBA)
+	if nrgba.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", nrgba.R, nrgba.G, nrgba.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", nrgba.R, nrgba.G, nrgba.B, nrgba.A)
 }
 
 // LoadColor loads the color from a persisted form
-func LoadColor(savedColor *SavedColor) *color.RGBA {
-	return &color.RGBA{
+func LoadColor(savedColor *SavedColor) *color.NRGBA {
+	return &color.NRGBA{
 		savedColor.R,
 		savedColor.G,
 		savedColor.B,
@@ -61,15 +77,73 @@ func LoadColor(savedColor *SavedColor) *color.RGBA {
 	}
 }
 
-// LoadColorHex loads the color from a hex string
-func LoadColorHex(encoded string) *color.RGBA {
-	clr, _ := colorful.Hex(encoded)
-	r, g, b, _ := clr.RGBA()
-	return &color.RGBA{
-		uint8(r),
-		uint8(g),
-		uint8(b),
-		uint8(255),
+// LoadColorHex loads the color from a hex string. Colors without
+// an alpha component are loaded as fully opaque. Malformed colors are
+// logged, and whatever could be parsed is returned.
+func LoadColorHex(encoded string) *color.NRGBA {
+	clr, err := ParseColorHex(encoded)
+	if err != nil {
+		log.Printf("Error loading color: %v", err.Error())
+	}
+	return clr
+}
+
+// ParseColorHex parses a #rrggbb or #rrggbbaa color. If the color is
+// malformed, an error is returned along with the parsed components, and a
+// malformed alpha is treated as fully opaque.
+func ParseColorHex(encoded string) (*color.NRGBA, error) {
+	alpha := uint8(255)
+	var err error
+	if len(encoded) == 9 {
+		a, alphaErr := strconv.ParseUint(encoded[7:], 16, 8)
+		if alphaErr == nil {
+			alpha = uint8(a)
+		} else {
+			err = fmt.Errorf("Invalid alpha in color '%v'", encoded)
+		}
+		encoded = encoded[:7]
+	}
+	clr, hexErr := colorful.Hex(encoded)
+	if hexErr != nil {
+		err = fmt.Errorf("Invalid color '%v'", encoded)
+	}
+	r, g, b := clr.RGB255()
+	return &color.NRGBA{r, g, b, alpha}, err
+}
+
+// WithAlpha converts a colorful.Color into a color with the specified alpha
+func WithAlpha(clr colorful.Color, alpha uint8) *color.NRGBA {
+	r, g, b := clr.Clamped().RGB255()
+	return &color.NRGBA{r, g, b, alpha}
+}
+
+// RandomColor returns a random color with an opacity between
+// minOpacity and maxOpacity (0-1)
+func RandomColor(minOpacity float32, maxOpacity float32) *color.NRGBA {
+	alpha := math.Round(float64(rand.Float32()*(maxOpacity-minOpacity)+minOpacity) * 255)
+	return &color.NRGBA{
+		A: uint8(math.Max(0, math.Min(255, alpha))),
+		G: uint8(rand.Int31n(255)),
+		B: uint8(rand.Int31n(255)),
+		R: uint8(rand.Int31n(255)),
+	}
+}
+
+// MeanColor returns the average color of an image
+func MeanColor(img image.Image) *color.NRGBA {
+	var r, g, b, count uint64
+	bounds := img.Bounds()
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			pixel := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			r += uint64(pixel.R)
+			g += uint64(pixel.G)
+			b += uint64(pixel.B)
+			count++
+		}
+	}
+	if count == 0 {
+		return &color.NRGBA{A: 255}
 	}
-	// return MakeColorRGB(r, g, b)
+	return &color.NRGBA{uint8(r / count), uint8(g / count), uint8(b / count), 255}
 }
@@ -0,0 +1,118 @@
+package main
+
+import "math"
+
+const (
+	// ColorDistanceCIE76 is the Euclidean distance in the Lab color space.
+	// It is the fastest, but over-weights differences in saturated colors.
+	ColorDistanceCIE76 = "cie76"
+	// ColorDistanceCIE94 weights chroma and hue differences by the chroma of
+	// the target color.
+	ColorDistanceCIE94 = "cie94"
+	// ColorDistanceCIEDE2000 is the most perceptually uniform, and the slowest.
+	ColorDistanceCIEDE2000 = "ciede2000"
+)
+
+// Weighting factors of CIE94 for graphic arts
+const (
+	cie94K1 = 0.045
+	cie94K2 = 0.015
+)
+
+// pow25To7 is 25^7, used by CIEDE2000
+const pow25To7 = 6103515625.0
+
+// cie94Distance calculates the CIE94 distance of a color from a target color.
+// The weights only depend on the target, and are precalculated with its Lab.
+func cie94Distance(target *Lab, lab *Lab) float32 {
+	lDiff := target.l - lab.l
+	cDiff := target.c - lab.c
+	aDiff := target.a - lab.a
+	bDiff := target.b - lab.b
+	hDiff := aDiff*aDiff + bDiff*bDiff - cDiff*cDiff
+	if hDiff < 0 {
+		hDiff = 0
+	}
+	cDiff /= target.sc
+	return float32(math.Sqrt(float64(lDiff*lDiff + cDiff*cDiff + hDiff/(target.sh*target.sh))))
+}
+
+// ciede2000Distance calculates the CIEDE2000 distance between two colors.
+// See http://www2.ece.rochester.edu/~gsharma/ciede2000/ciede2000noteCRNA.pdf
+func ciede2000Distance(lab1 *Lab, lab2 *Lab) float32 {
+	l1, a1, b1 := float64(lab1.l), float64(lab1.a), float64(lab1.b)
+	l2, a2, b2 := float64(lab2.l), float64(lab2.a), float64(lab2.b)
+
+	cMean := float64(lab1.c+lab2.c) / 2
+	cMean7 := pow7(cMean)
+	g := 0.5 * (1 - math.Sqrt(cMean7/(cMean7+pow25To7)))
+	a1, a2 = a1*(1+g), a2*(1+g)
+	c1, c2 := math.Hypot(a1, b1), math.Hypot(a2, b2)
+	h1, h2 := ciede2000Hue(a1, b1), ciede2000Hue(a2, b2)
+
+	lDiff := l2 - l1
+	cDiff := c2 - c1
+	var hDiff float64
+	if c1*c2 != 0 {
+		hDiff = h2 - h1
+		if hDiff > 180 {
+			hDiff -= 360
+		} else if hDiff < -180 {
+			hDiff += 360
+		}
+	}
+	hDiff = 2 * math.Sqrt(c1*c2) * math.Sin(radians(hDiff/2))
+
+	lMean := (l1 + l2) / 2
+	cMean = (c1 + c2) / 2
+	hMean := h1 + h2
+	if c1*c2 != 0 {
+		if math.Abs(h1-h2) <= 180 {
+			hMean /= 2
+		} else if hMean < 360 {
+			hMean = (hMean + 360) / 2
+		} else {
+			hMean = (hMean - 360) / 2
+		}
+	}
+	t := 1 - 0.17*math.Cos(radians(hMean-30)) +
+		0.24*math.Cos(radians(2*hMean)) +
+		0.32*math.Cos(radians(3*hMean+6)) -
+		0.20*math.Cos(radians(4*hMean-63))
+	theta := (hMean - 275) / 25
+	theta = 30 * math.Exp(-theta*theta)
+	cMean7 = pow7(cMean)
+	rc := 2 * math.Sqrt(cMean7/(cMean7+pow25To7))
+	lMean50 := (lMean - 50) * (lMean - 50)
+	sl := 1 + 0.015*lMean50/math.Sqrt(20+lMean50)
+	sc := 1 + 0.045*cMean
+	sh := 1 + 0.015*cMean*t
+	rt := -math.Sin(radians(2*theta)) * rc
+
+	lDiff /= sl
+	cDiff /= sc
+	hDiff /= sh
+	return float32(math.Sqrt(lDiff*lDiff + cDiff*cDiff + hDiff*hDiff + rt*cDiff*hDiff))
+}
+
+// ciede2000Hue returns the hue angle in degrees (0-360)
+func ciede2000Hue(a float64, b float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// pow7 is a lot faster than math.Pow(x, 7)
+func pow7(x float64) float64 {
+	x2 := x * x
+	return x2 * x2 * x2 * x
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Test data from http://www2.ece.rochester.edu/~gsharma/ciede2000/. The pair
+// with a hue difference of exactly 180 degrees is left out, since Lab colors
+// are stored as float32, which moves it to either side of the discontinuity.
+var ciede2000Pairs = []struct {
+	lab1     [3]float32
+	lab2     [3]float32
+	expected float64
+}{
+	{[3]float32{50, 2.6772, -79.7751}, [3]float32{50, 0, -82.7485}, 2.0425},
+	{[3]float32{50, 3.1571, -77.2803}, [3]float32{50, 0, -82.7485}, 2.8615},
+	{[3]float32{50, 2.8361, -74.0200}, [3]float32{50, 0, -82.7485}, 3.4412},
+	{[3]float32{50, 0, 0}, [3]float32{50, -1, 2}, 2.3669},
+	{[3]float32{50, -1, 2}, [3]float32{50, 0, 0}, 2.3669},
+	{[3]float32{50, 2.4900, -0.0010}, [3]float32{50, -2.4900, 0.0009}, 7.1792},
+	{[3]float32{50, 2.4900, -0.0010}, [3]float32{50, -2.4900, 0.0011}, 7.2195},
+	{[3]float32{50, 2.5, 0}, [3]float32{50, 0, -2.5}, 4.3065},
+	{[3]float32{50, 2.5, 0}, [3]float32{73, 25, -18}, 27.1492},
+	{[3]float32{50, 2.5, 0}, [3]float32{61, -5, 29}, 22.8977},
+	{[3]float32{50, 2.5, 0}, [3]float32{50, 3.1736, 0.5854}, 1.0000},
+	{[3]float32{60.2574, -34.0099, 36.2677}, [3]float32{60.4626, -34.1751, 39.4387}, 1.2644},
+	{[3]float32{63.0109, -31.0961, -5.8663}, [3]float32{62.8187, -29.7946, -4.0864}, 1.2630},
+	{[3]float32{2.0776, 0.0795, -1.1350}, [3]float32{0.9033, -0.0636, -0.5514}, 0.9082},
+}
+
+func TestCIEDE2000ReferencePairs(t *testing.T) {
+	for _, pair := range ciede2000Pairs {
+		lab1 := NewLab(pair.lab1[0], pair.lab1[1], pair.lab1[2])
+		lab2 := NewLab(pair.lab2[0], pair.lab2[1], pair.lab2[2])
+		distance := float64(ciede2000Distance(lab1, lab2))
+		if math.Abs(distance-pair.expected) > 0.0005 {
+			t.Errorf("Expected distance %v between %v and %v, got %v", pair.expected, pair.lab1, pair.lab2, distance)
+		}
+	}
+}
+
+func TestCIE94Distance(t *testing.T) {
+	target := NewLab(50, 60, 20)
+	lighter := NewLab(60, 60, 20)
+	if distance := cie94Distance(target, lighter); math.Abs(float64(distance)-10) > 0.0001 {
+		t.Errorf("Expected lightness differences to count fully, got %v for a difference of 10", distance)
+	}
+	lessSaturated := NewLab(50, 50, 20*50/60.0)
+	cie76 := math.Sqrt(float64((60-50)*(60-50)) + float64((20-20*50/60.0)*(20-20*50/60.0)))
+	if distance := float64(cie94Distance(target, lessSaturated)); distance >= cie76/2 {
+		t.Errorf("Expected chroma differences of saturated colors to count less than %v, got %v", cie76/2, distance)
+	}
+	if distance := cie94Distance(target, target); distance != 0 {
+		t.Errorf("Expected no distance between equal colors, got %v", distance)
+	}
+}
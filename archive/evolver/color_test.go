@@ -0,0 +1,59 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestRandomColorOpacity(t *testing.T) {
+	cases := []struct {
+		minOpacity float32
+		maxOpacity float32
+		expected   uint8
+	}{
+		{0, 0, 0},
+		{0.5, 0.5, 128},
+		{1, 1, 255},
+		// Opacities outside of 0-1 are clamped instead of wrapping around
+		{1.2, 1.2, 255},
+		{-0.2, -0.2, 0},
+	}
+	for _, c := range cases {
+		if alpha := RandomColor(c.minOpacity, c.maxOpacity).A; alpha != c.expected {
+			t.Errorf("Expected alpha %v for opacity %v-%v, got %v", c.expected, c.minOpacity, c.maxOpacity, alpha)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if alpha := RandomColor(0.2, 0.4).A; alpha < 51 || alpha > 102 {
+			t.Fatalf("Expected alpha between 51 and 102, got %v", alpha)
+		}
+	}
+}
+
+func TestParseColorHex(t *testing.T) {
+	cases := []struct {
+		encoded  string
+		expected color.NRGBA
+		valid    bool
+	}{
+		{"#102030", color.NRGBA{16, 32, 48, 255}, true},
+		{"#10203080", color.NRGBA{16, 32, 48, 128}, true},
+		{"#102030zz", color.NRGBA{16, 32, 48, 255}, false},
+		{"#1020zz", color.NRGBA{0, 0, 0, 255}, false},
+	}
+	for _, c := range cases {
+		clr, err := ParseColorHex(c.encoded)
+		if (err == nil) != c.valid {
+			t.Errorf("%v: Expected valid to be %v, got error %v", c.encoded, c.valid, err)
+		}
+		if *clr != c.expected {
+			t.Errorf("%v: Expected %v, got %v", c.encoded, c.expected, *clr)
+		}
+		if loaded := LoadColorHex(c.encoded); *loaded != *clr {
+			t.Errorf("%v: Expected LoadColorHex to load %v, got %v", c.encoded, *clr, *loaded)
+		}
+	}
+	if encoded := SaveColorHex(LoadColorHex("#a0b0c0d0")); encoded != "#a0b0c0d0" {
+		t.Errorf("Expected the color to be saved as it was loaded, got %v", encoded)
+	}
+}
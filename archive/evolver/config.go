@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 )
@@ -15,6 +16,10 @@ type Config struct {
 	MaxValueMutation      float32
 	MinSaturationMutation float32
 	MaxSaturationMutation float32
+	MinOpacity            float32 // Lower bound of instruction opacity (0-1)
+	MaxOpacity            float32 // Upper bound of instruction opacity (0-1)
+	MinOpacityMutation    float32
+	MaxOpacityMutation    float32
 	// Coordinates
 	MinCoordinateMutation float32
 	MaxCoordinateMutation float32
@@ -36,18 +41,43 @@ type Config struct {
 	MaxPolygonAngleMutation  float32
 	MinPolygonPoints         int
 	MaxPolygonPoints         int
+	// Brushes
+	BrushSetFile        string   // Brush set json file, in the same format as the WebGL evolver
+	EnabledBrushTags    []string // Only brushes with these tags are used. If empty, all brushes are used.
+	MinRotationMutation float32
+	MaxRotationMutation float32
 	// Other stuff
-	InstructionTypes      []string
-	ComplexityThreshold   int     // An organism can reach this many instructions before score penalties are applied
-	ComplexityPenalty     float32 // For each instruction over the threshold, this amount is added to the diff
-	MaxPopulation         int     // When repopulating, don't create more than this many organisms
-	MinComplexity         int     // Lower bound of default complexity when creating random organisms
-	MaxComplexity         int     // Upper bound of default complexity when creating random organisms
-	MinMutations          int     // Minimum number of mutations applied to an organism
-	MaxMutations          int     // Maximum number of mutations applied to an organism
-	WorkerCount           int     // At most this many workers. If less than or equal to zero, all cpus are applied to worker pool.
-	SyncFrequency         int     // Wait at most this many iterations before fetching top organisms from the server
-	OptimizationFrequency int     // Wait this many iterations before triggering an optimization run.
+	InstructionTypes       []string
+	ComplexityThreshold    int               // An organism can reach this many instructions before score penalties are applied
+	ComplexityPenalty      float32           // For each instruction over the threshold, this amount is added to the diff
+	MaxPopulation          int               // When repopulating, don't create more than this many organisms
+	MinComplexity          int               // Lower bound of default complexity when creating random organisms
+	MaxComplexity          int               // Upper bound of default complexity when creating random organisms
+	MinMutations           int               // Minimum number of mutations applied to an organism
+	MaxMutations           int               // Maximum number of mutations applied to an organism
+	WorkerCount            int               // At most this many workers. If less than or equal to zero, all cpus are applied to worker pool.
+	SyncFrequency          int               // Wait at most this many iterations before fetching top organisms from the server
+	OptimizationFrequency  int               // Wait this many iterations before triggering an optimization run.
+	FitnessMetric          string            // "lab", "ssim" or "pyramid". The server and its workers must use the same metric.
+	PyramidWeights         []float32         // Weights of the levels of the pyramid metric, starting at full resolution
+	ColorDistance          string            // How the lab and pyramid metrics compare colors: "cie76", "cie94" or "ciede2000"
+	EdgeWeight             float32           // Weight of the Sobel edge term of the lab metric. 0 disables it.
+	FocusFitnessWeight     float32           // Pixels at full focus count 1+FocusFitnessWeight times as much. If zero, the focus map only filters mutations.
+	MutationImportance     string            // Where mutations go: "focus", "error" or "uniform"
+	InstructionPlacement   map[string]string // Placement of new instructions per type, "importance" (the default) or "error"
+	TargetColorRate        float32           // Fraction (0-1) of new instructions that are colored from the target
+	TargetColorSample      string            // "mean" (under the bounds of the instruction) or "center"
+	TargetColorJitter      float32           // Randomly change each channel of target colors by up to this fraction (0-1)
+	OptimalColors          bool              // Solve the best color of new and moved instructions before they are scored
+	OptimalColorStep       int               // Quantize optimal colors to multiples of this value, so that hashes stay stable
+	RefineRounds           int               // Hill-climbing rounds for each appended instruction. If less than or equal to zero, instructions are not refined.
+	MutationStatsFrequency int               // Log mutation statistics every this many iterations. If less than or equal to zero, they are not logged.
+	// Population files
+	PopulationFileEncoding   string  // Encoding of organisms in population files, "text" or "binary". Files in either encoding can always be loaded.
+	CheckpointCount          int     // Number of verified copies of the population file to fall back to if it is corrupt. If less than or equal to zero, no checkpoints are made.
+	CheckpointMinutes        int     // Make a checkpoint at least this often
+	CheckpointSimilarityStep float32 // Also make a checkpoint each time the similarity passes a multiple of this percentage
+	JournalSnapshotFrequency int     // Write a full organism to the journal every this many iterations. If less than or equal to zero, only when the top organism is loaded or replaced.
 }
 
 // LoadConfig loads the application config from a file
@@ -79,6 +109,26 @@ func SaveConfig(filename string, config *Config) error {
 	return err
 }
 
+// SameFitness determines if diffs calculated with two configs can be
+// compared. Settings that were added later default to their old behavior.
+func (config *Config) SameFitness(other *Config) bool {
+	fitness := func(config *Config) string {
+		metric, colorDistance := config.FitnessMetric, config.ColorDistance
+		if metric == "" {
+			metric = FitnessMetricLab
+		}
+		if colorDistance == "" {
+			colorDistance = ColorDistanceCIE76
+		}
+		pyramidWeights := ""
+		if metric == FitnessMetricPyramid {
+			pyramidWeights = fmt.Sprint(config.PyramidWeights)
+		}
+		return fmt.Sprintf("%v|%v|%v|%v|%v", metric, colorDistance, config.EdgeWeight, pyramidWeights, config.FocusFitnessWeight)
+	}
+	return fitness(config) == fitness(other)
+}
+
 // DefaultConfig returns the default application configuration
 func DefaultConfig() *Config {
 	return &Config{
@@ -88,6 +138,10 @@ func DefaultConfig() *Config {
 		MaxValueMutation:        0.1,
 		MinSaturationMutation:   0,
 		MaxSaturationMutation:   0.1,
+		MinOpacity:              1,
+		MaxOpacity:              1,
+		MinOpacityMutation:      0,
+		MaxOpacityMutation:      0.1,
 		MinCoordinateMutation:   0,
 		MaxCoordinateMutation:   100,
 		MinLineWidthMutation:    0,
@@ -106,6 +160,10 @@ func DefaultConfig() *Config {
 		MinPolygonPoints:         3,
 		MaxPolygonPoints:         7,
 
+		BrushSetFile:        "brushes.json",
+		MinRotationMutation: 0,
+		MaxRotationMutation: 0.5,
+
 		MaxLineLength:       50,
 		MaxLineArea:         250,
 		ComplexityThreshold: 10000,
@@ -120,5 +178,19 @@ func DefaultConfig() *Config {
 		},
 		WorkerCount:   0,
 		SyncFrequency: 50,
+
+		FitnessMetric:          FitnessMetricLab,
+		ColorDistance:          ColorDistanceCIE76,
+		PyramidWeights:         []float32{1, 1, 1},
+		MutationImportance:     MutationImportanceFocus,
+		TargetColorSample:      TargetColorMean,
+		TargetColorJitter:      0.05,
+		MutationStatsFrequency: 100,
+		OptimalColorStep:       4,
+		PopulationFileEncoding: EncodingText,
+
+		CheckpointCount:          10,
+		CheckpointMinutes:        60,
+		CheckpointSimilarityStep: 1,
 	}
 }
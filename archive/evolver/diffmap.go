@@ -4,6 +4,9 @@ import "math"
 
 const granularity float32 = 10000
 
+// weightGranularity is the average weight of DiffWeights
+const weightGranularity = 1000
+
 // DiffMap provides a way to keep track of organism diffs
 // by pixel. This avoids having to recalculate them later.
 // Diffs are stored as int64 values to avoid weird floating point
@@ -14,24 +17,101 @@ type DiffMap struct {
 	// Not sure yet if this will work with very large images.
 	Diffs [][]int64
 	Total int64
+	// WeightedTotal is the total of the diffs multiplied by their weights,
+	// if the map is weighted
+	WeightedTotal int64
+	weights       *DiffWeights
+}
+
+// DiffWeights weight the diffs of the pixels of DiffMaps, so that some areas
+// of an image count more than others. They are shared by all DiffMaps of an
+// image, and must not be modified.
+type DiffWeights struct {
+	// Weights by pixel. Access by `Weights[x][y]`.
+	// The average weight is weightGranularity.
+	Weights [][]int64
+	Total   int64
+}
+
+// NewDiffWeights creates DiffWeights from relative weights by pixel
+func NewDiffWeights(weights [][]float32) *DiffWeights {
+	var sum float64
+	for x := range weights {
+		for y := range weights[x] {
+			sum += float64(weights[x][y])
+		}
+	}
+	mean := sum / float64(len(weights)*len(weights[0]))
+	diffWeights := &DiffWeights{Weights: make([][]int64, len(weights))}
+	for x := range weights {
+		diffWeights.Weights[x] = make([]int64, len(weights[x]))
+		for y := range weights[x] {
+			weight := int64(math.Round(float64(weights[x][y]) / mean * weightGranularity))
+			diffWeights.Weights[x][y] = weight
+			diffWeights.Total += weight
+		}
+	}
+	return diffWeights
+}
+
+// NewDiffMap creates a new DiffMap for an image of the specified size
+func NewDiffMap(width int, height int) *DiffMap {
+	diffMap := &DiffMap{
+		Diffs: make([][]int64, width),
+	}
+	for x := 0; x < width; x++ {
+		diffMap.Diffs[x] = make([]int64, height)
+	}
+	return diffMap
 }
 
 // SetDiff updates the diff at the specified coordinates
 func (d *DiffMap) SetDiff(x int, y int, diff float32) {
 	newValue := int64(math.Ceil(float64(diff * granularity)))
+	if d.weights != nil {
+		d.WeightedTotal += d.weights.Weights[x][y] * (newValue - d.Diffs[x][y])
+	}
 	d.Total -= d.Diffs[x][y]
 	d.Diffs[x][y] = newValue
 	d.Total += newValue
 }
 
+// SetWeights weights the diffs of the map. nil removes the weights.
+func (d *DiffMap) SetWeights(weights *DiffWeights) {
+	if d.weights == weights {
+		return
+	}
+	d.weights = weights
+	d.RecalculateTotal()
+}
+
+// CopyFrom copies the diffs of another map of the same size
+func (d *DiffMap) CopyFrom(other *DiffMap) {
+	for x := 0; x < len(other.Diffs); x++ {
+		copy(d.Diffs[x], other.Diffs[x])
+	}
+	d.Total = other.Total
+	d.WeightedTotal = other.WeightedTotal
+	d.weights = other.weights
+}
+
 // GetDiff returns the diff at the specified coordinates
 func (d *DiffMap) GetDiff(x int, y int) float32 {
 	return float32(d.Diffs[x][y]) / granularity
 }
 
 // GetAverageDiff returns the average diff based on total divided
-// by total number of pixels
+// by total number of pixels. If the map is weighted, the weighted
+// average is returned.
 func (d *DiffMap) GetAverageDiff() float32 {
+	if d.weights != nil {
+		return float32(d.WeightedTotal/d.weights.Total) / granularity
+	}
+	return d.GetUnweightedAverageDiff()
+}
+
+// GetUnweightedAverageDiff returns the average diff, ignoring weights
+func (d *DiffMap) GetUnweightedAverageDiff() float32 {
 	width := len(d.Diffs)
 	height := len(d.Diffs[0])
 	avg := d.Total / int64(width*height)
@@ -46,14 +126,19 @@ func (d *DiffMap) Clear() {
 		}
 	}
 	d.Total = 0
+	d.WeightedTotal = 0
 }
 
-// RecalculateTotal updates the Total based on pixel values
+// RecalculateTotal updates the Total and WeightedTotal based on pixel values
 func (d *DiffMap) RecalculateTotal() {
 	d.Total = 0
+	d.WeightedTotal = 0
 	for x := 0; x < len(d.Diffs); x++ {
 		for y := 0; y < len(d.Diffs[0]); y++ {
 			d.Total += d.Diffs[x][y]
+			if d.weights != nil {
+				d.WeightedTotal += d.weights.Weights[x][y] * d.Diffs[x][y]
+			}
 		}
 	}
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// edgeRadius is the radius of the Sobel kernel
+const edgeRadius = 1
+
+// gradients holds the Sobel gradient of each pixel of an image, indexed by
+// y*width+x
+type gradients struct {
+	width  int
+	height int
+	x      []float32
+	y      []float32
+}
+
+// newTargetGradients calculates the gradients of the lightness of the
+// precalculated target image
+func newTargetGradients(labs [][]*Lab) *gradients {
+	width, height := len(labs), len(labs[0])
+	target := &gradients{
+		width:  width,
+		height: height,
+		x:      make([]float32, width*height),
+		y:      make([]float32, width*height),
+	}
+	lightness := func(x int, y int) float32 {
+		return labs[x][y].l
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			target.x[i], target.y[i] = sobel(lightness, x, y, width, height)
+		}
+	}
+	return target
+}
+
+// sobel calculates the gradient of a pixel. Pixels outside of the image are
+// clamped to the border. The gradient is scaled to lightness units, so that
+// it is comparable with color distances.
+func sobel(lightness func(x int, y int) float32, x int, y int, width int, height int) (float32, float32) {
+	left, right := maxInt(x-1, 0), minInt(x+1, width-1)
+	top, bottom := maxInt(y-1, 0), minInt(y+1, height-1)
+	topLeft, topRight := lightness(left, top), lightness(right, top)
+	bottomLeft, bottomRight := lightness(left, bottom), lightness(right, bottom)
+	gx := topRight + 2*lightness(right, y) + bottomRight - topLeft - 2*lightness(left, y) - bottomLeft
+	gy := bottomLeft + 2*lightness(x, bottom) + bottomRight - topLeft - 2*lightness(x, top) - topRight
+	return gx / 4, gy / 4
+}
+
+// edgeDistanceBounds updates the diff map with the color distance plus the
+// weighted edge distance of each pixel. The edge distance is the length of
+// the difference between the gradients of the target and the image, so both
+// the strength and the orientation of edges are compared. Changed pixels
+// affect the gradients of their neighbours, so a margin of edgeRadius is
+// scored around each bounds.
+func (ranker *Ranker) edgeDistanceBounds(img image.Image, boundAreas []Rect, diffMap *DiffMap) {
+	target := ranker.targetGradients
+	// Keep a cache of color mappings for this image
+	cache := map[uint32]*Lab{}
+	for _, bounds := range boundAreas {
+		left, top, right, bottom := bounds.PixelArea(edgeRadius)
+		left, top = maxInt(left, 0), maxInt(top, 0)
+		right, bottom = minInt(right, target.width), minInt(bottom, target.height)
+		if left >= right || top >= bottom {
+			continue
+		}
+		// Labs of the area and the pixels around it that the kernel reaches
+		labLeft, labTop := maxInt(left-edgeRadius, 0), maxInt(top-edgeRadius, 0)
+		labRight, labBottom := minInt(right+edgeRadius, target.width), minInt(bottom+edgeRadius, target.height)
+		labWidth := labRight - labLeft
+		labs := make([]*Lab, labWidth*(labBottom-labTop))
+		for y := labTop; y < labBottom; y++ {
+			for x := labLeft; x < labRight; x++ {
+				clr := img.At(x, y)
+				key := ColorKey(clr)
+				lab, has := cache[key]
+				if !has {
+					lab = ranker.getLab(clr)
+					cache[key] = lab
+				}
+				labs[(y-labTop)*labWidth+(x-labLeft)] = lab
+			}
+		}
+		lightness := func(x int, y int) float32 {
+			return labs[(y-labTop)*labWidth+(x-labLeft)].l
+		}
+		for y := top; y < bottom; y++ {
+			for x := left; x < right; x++ {
+				gx, gy := sobel(lightness, x, y, target.width, target.height)
+				i := y*target.width + x
+				dx, dy := target.x[i]-gx, target.y[i]-gy
+				edgeDiff := float32(math.Sqrt(float64(dx*dx + dy*dy)))
+				colorDiff := ranker.colorDistance(ranker.precalculatedImage[x][y], labs[(y-labTop)*labWidth+(x-labLeft)])
+				diffMap.SetDiff(x, y, colorDiff+ranker.edgeWeight*edgeDiff)
+			}
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -9,12 +8,16 @@ import (
 	"image/png"
 	"io/ioutil"
 	"log"
+	"math"
 	"math/rand"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fogleman/gg"
@@ -27,7 +30,7 @@ const gcFrequency = 100
 
 var cwd, _ = os.Getwd()
 
-// framesPerSecond is used for video rendering
+// framesPerSecond is the default frame rate for video rendering
 const framesPerSecond = 30
 
 // profileDuration is the amount of time before program terminates, if profiling is enabled
@@ -43,19 +46,32 @@ var (
 	targetFile       = serverCmd.Arg("target", "File containing the target image").Required().String()
 	focusFile        = serverCmd.Flag("focus", "File containing a focus map").String()
 	serverMaxSeconds = serverCmd.Flag("max_seconds", "Maximum number of seconds to run").Int()
+	serverJournal    = serverCmd.Flag("journal", "Record every change of the top organism in a journal file next to the population file, so that it can be replayed").Default("true").Bool()
 
-	compareCmd   = app.Command("compare", "Compares two image files for difference and prints the result")
-	compareFile1 = compareCmd.Arg("file1", "First file to compare").Required().String()
-	compareFile2 = compareCmd.Arg("file2", "Second file to compare").Required().String()
+	compareCmd           = app.Command("compare", "Compares two image files for difference and prints the result")
+	compareFile1         = compareCmd.Arg("file1", "First file to compare").Required().String()
+	compareFile2         = compareCmd.Arg("file2", "Second file to compare").Required().String()
+	compareMetric        = compareCmd.Flag("metric", "Fitness metric to compare with (lab, ssim or pyramid). Defaults to FitnessMetric in config.json").String()
+	compareColorDistance = compareCmd.Flag("color-distance", "Color distance of the lab metric (cie76, cie94 or ciede2000). Defaults to ColorDistance in config.json").String()
 
 	workerCmd = app.Command("worker", "Run a worker process")
 	endpoint  = workerCmd.Arg("endpoint", "Endpoint of the server process").Required().String()
 
-	genvideoCmd          = app.Command("genvideo", "Generates an mp4 video file from a sequence of rendered organisms, showing the path of evolution to the final image (requires ffmpeg and linux).")
-	genvideoCmdPrefix    = genvideoCmd.Flag("prefix", "Prefix of the png files that will be used for the video").Required().String()
-	genvideoCmdSourceDir = genvideoCmd.Flag("folder", "Folder containing the png files. Defaults to current working directory").Default(cwd).String()
-	genvideoCmdLength    = genvideoCmd.Flag("length", "The length of the video in seconds. The input files will be skipped in a time-lapse fashion to speed up the video to the desired duration (defaults to 60 seconds)").Default("60").Int()
-	genvideoCmdOutfile   = genvideoCmd.Flag("outfile", "Name of output video file").Default("video.mp4").String()
+	genvideoCmd             = app.Command("genvideo", "Generates a video file from a sequence of rendered organisms, showing the path of evolution to the final image. Mp4 videos require ffmpeg and linux, animated gif and png files are written directly.")
+	genvideoCmdPrefix       = genvideoCmd.Flag("prefix", "Prefix of the png files that will be used for the video. Not needed with --journal").String()
+	genvideoCmdSourceDir    = genvideoCmd.Flag("folder", "Folder containing the png files. Defaults to current working directory").Default(cwd).String()
+	genvideoCmdLength       = genvideoCmd.Flag("length", "The length of the video in seconds. The input files will be skipped in a time-lapse fashion to speed up the video to the desired duration (defaults to 60 seconds)").Default("60").Int()
+	genvideoCmdOutfile      = genvideoCmd.Flag("outfile", "Name of output video file. Files ending in .gif or .png (or .apng) are written as animated gif or png files, other files are encoded by ffmpeg").Default("video.mp4").String()
+	genvideoCmdJournal      = genvideoCmd.Flag("journal", "Journal file to render the frames from, instead of the png files saved by the server").String()
+	genvideoCmdFrames       = genvideoCmd.Flag("frames", "Number of frames to render from the journal. Defaults to the length of the video at the frame rate").Int()
+	genvideoCmdWidth        = genvideoCmd.Flag("width", "Width of frames rendered from the journal. Defaults to the width of the target image").Short('w').Int()
+	genvideoCmdHeight       = genvideoCmd.Flag("height", "Height of frames rendered from the journal. Defaults to the height of the target image").Short('h').Int()
+	genvideoCmdEasing       = genvideoCmd.Flag("easing", "Spacing of frames rendered from the journal. 1 spaces frames evenly over the iterations, larger values put more frames at the start of the run").Default("2").Float64()
+	genvideoCmdFramesFolder = genvideoCmd.Flag("frames-folder", "Folder that the numbered png frames are written to").Default("tmp").String()
+	genvideoCmdFramesOnly   = genvideoCmd.Flag("frames-only", "Only write the numbered png frames, without running ffmpeg").Bool()
+	genvideoCmdFPS          = genvideoCmd.Flag("fps", "Frame rate of the video").Default(fmt.Sprint(framesPerSecond)).Int()
+	genvideoCmdLoop         = genvideoCmd.Flag("loop", "Number of times an animated gif or png is played. 0 loops forever").Default("0").Int()
+	genvideoCmdMaxSize      = genvideoCmd.Flag("max-size", "Maximum width and height of animated gif and png frames in pixels. Larger frames are scaled down").Int()
 
 	scaleCmd           = app.Command("scale", "Scales a population file by a specified factor")
 	scaleCmdFile       = scaleCmd.Flag("file", "Path to the population file to scale").Required().String()
@@ -65,18 +81,56 @@ var (
 	renderCmd           = app.Command("render", "Renders thie top organism from a population file")
 	renderCmdFile       = renderCmd.Flag("file", "Path to the population file to render").Required().String()
 	renderCmdOutputFile = renderCmd.Flag("output-file", "Path of the output file to create").Required().Short('o').String()
-	// TODO: embed image width and height in population file so that it can scale more sanely
-	renderCmdWidth  = renderCmd.Flag("width", "Width of output image in pixels").Short('w').Required().Int()
-	renderCmdHeight = renderCmd.Flag("height", "Height of output image in pixels").Short('h').Required().Int()
+	renderCmdWidth      = renderCmd.Flag("width", "Width of output image in pixels. Defaults to the width in the population file").Short('w').Int()
+	renderCmdHeight     = renderCmd.Flag("height", "Height of output image in pixels. Defaults to the height in the population file").Short('h').Int()
+
+	exportCmd        = app.Command("export", "Exports the top organism from a population file to an image file")
+	exportCmdFile    = exportCmd.Flag("file", "Path to the population file to export").Required().String()
+	exportCmdOutfile = exportCmd.Flag("output-file", "Path of the output file to create").Short('o').Required().String()
+	exportCmdFormat  = exportCmd.Flag("format", "Format of the output file (svg or png)").Default("svg").Enum("svg", "png")
+	exportCmdWidth   = exportCmd.Flag("width", "Width of output image in pixels. Defaults to the width in the population file").Short('w').Int()
+	exportCmdHeight  = exportCmd.Flag("height", "Height of output image in pixels. Defaults to the height in the population file").Short('h').Int()
 
 	downloadCmd      = app.Command("download", "Downloads a number of top organisms from the server and saves to a local file")
 	downloadEndpoint = downloadCmd.Flag("endpoint", "Endpoint of server to download from").Required().String()
 	downloadOutfile  = downloadCmd.Flag("outfile", "Output file to save downloaded organisms to").Required().String()
 	downloadCount    = downloadCmd.Flag("count", "Number of top organisms to download").Default("1").Int()
 
+	exportStrokesCmd        = app.Command("export-strokes", "Exports the brush strokes of the top organism from a population file, so they can be imported into the WebGL evolver")
+	exportStrokesCmdFile    = exportStrokesCmd.Flag("file", "Path to the population file to export").Required().String()
+	exportStrokesCmdOutfile = exportStrokesCmd.Flag("output-file", "Path of the brush strokes json file to create").Short('o').Required().String()
+
+	importStrokesCmd        = app.Command("import-strokes", "Imports brush strokes exported from the WebGL evolver into a population file")
+	importStrokesCmdFile    = importStrokesCmd.Flag("file", "Path to the brush strokes json file to import").Required().String()
+	importStrokesCmdOutfile = importStrokesCmd.Flag("output-file", "Path of the population file to create").Short('o').Required().String()
+
+	importSVGCmd        = app.Command("import-svg", "Imports the shapes in an svg file into a population file, so that evolution can refine them")
+	importSVGCmdFile    = importSVGCmd.Arg("file", "Path to the svg file to import").Required().String()
+	importSVGCmdTarget  = importSVGCmd.Flag("target", "File containing the target image. Shapes are rescaled to the size of the target").Required().String()
+	importSVGCmdOutfile = importSVGCmd.Flag("output-file", "Path of the population file to create. Defaults to the population file that the server uses for the target").Short('o').String()
+
+	replayCmd           = app.Command("replay", "Rebuilds the top organism at any point of a run from a journal file, and saves it as a population file")
+	replayCmdFile       = replayCmd.Arg("file", "Path to the journal file to replay").Required().String()
+	replayCmdOutfile    = replayCmd.Flag("output-file", "Path of the population file to create").Short('o').Required().String()
+	replayCmdIteration  = replayCmd.Flag("iteration", "Rebuild the top organism as it was at this iteration").Int()
+	replayCmdSimilarity = replayCmd.Flag("similarity", "Rebuild the first top organism that reached this similarity percentage").Float32()
+
+	speedpaintCmd         = app.Command("speedpaint", "Creates an animation of the top organism from a population file being painted one instruction at a time")
+	speedpaintCmdFile     = speedpaintCmd.Flag("file", "Path to the population file to paint").Required().String()
+	speedpaintCmdOutfile  = speedpaintCmd.Flag("output-file", "Animated gif or png file to create (ending in .gif, .png or .apng), or a folder to write numbered png frames to").Short('o').Required().String()
+	speedpaintCmdPerFrame = speedpaintCmd.Flag("per-frame", "Number of instructions painted in each frame. Defaults to spreading the instructions over the length of the animation").Int()
+	speedpaintCmdLength   = speedpaintCmd.Flag("length", "Length of the animation in seconds").Default("10").Int()
+	speedpaintCmdFPS      = speedpaintCmd.Flag("fps", "Frame rate of the animation").Default(fmt.Sprint(framesPerSecond)).Int()
+	speedpaintCmdLoop     = speedpaintCmd.Flag("loop", "Number of times an animated gif or png is played. 0 loops forever").Default("0").Int()
+	speedpaintCmdWidth    = speedpaintCmd.Flag("width", "Width of the frames in pixels. Defaults to the width in the population file").Short('w').Int()
+	speedpaintCmdHeight   = speedpaintCmd.Flag("height", "Height of the frames in pixels. Defaults to the height in the population file").Short('h').Int()
+
 	config *Config
 	// objectPool is global to allow easy access
 	objectPool *ObjectPool
+	// brushSet is global so that brush instructions can find their sprites.
+	// It is nil if the brush set could not be loaded.
+	brushSet *BrushSet
 )
 
 func init() {
@@ -129,13 +183,40 @@ func loadImage(imageFile string) image.Image {
 
 func createObjectPool() *ObjectPool {
 	p := NewObjectPool()
-	p.AddInstructionFactory(TypePolygon, NewPolygonFactory())
+	for _, name := range InstructionTypeNames() {
+		instructionType, _ := GetInstructionType(name)
+		p.AddInstructionFactory(name, instructionType.NewFactory())
+	}
 	return p
 }
 
+// loadBrushSet loads the brush set specified in the config. The brush set is
+// only required if brush instructions are enabled.
+func loadBrushSet() *BrushSet {
+	set, err := LoadBrushSet(config.BrushSetFile)
+	if err != nil {
+		for _, instructionType := range config.InstructionTypes {
+			if instructionType == TypeBrush {
+				log.Fatalf("Error loading brush set '%v': %v", config.BrushSetFile, err.Error())
+			}
+		}
+		return nil
+	}
+	return set
+}
+
+// setCanvasSize prepares global state for rendering images of the specified size
+func setCanvasSize(width int, height int) {
+	objectPool.SetRendererBounds(width, height)
+	if brushSet != nil {
+		brushSet.SetCanvasSize(width, height)
+	}
+}
+
 func main() {
 	config = loadConfig()
 	objectPool = createObjectPool()
+	brushSet = loadBrushSet()
 	cmd := kingpin.MustParse(app.Parse(os.Args[1:]))
 	if *prof != "" {
 		f, err := os.Create(*prof)
@@ -171,8 +252,20 @@ func main() {
 		scale()
 	case renderCmd.FullCommand():
 		render()
+	case exportCmd.FullCommand():
+		export()
 	case downloadCmd.FullCommand():
 		download()
+	case exportStrokesCmd.FullCommand():
+		exportStrokes()
+	case importStrokesCmd.FullCommand():
+		importStrokes()
+	case importSVGCmd.FullCommand():
+		importSVG()
+	case replayCmd.FullCommand():
+		replay()
+	case speedpaintCmd.FullCommand():
+		speedpaint()
 	default:
 		log.Fatalf("Unimplemented command: %v", cmd)
 	}
@@ -181,7 +274,28 @@ func main() {
 func compare() {
 	image1 := loadImage(*compareFile1)
 	image2 := loadImage(*compareFile2)
-	ranker := &Ranker{}
+	metric := config.FitnessMetric
+	if *compareMetric != "" {
+		metric = *compareMetric
+	}
+	colorDistance := config.ColorDistance
+	if *compareColorDistance != "" {
+		colorDistance = *compareColorDistance
+	}
+	ranker := NewRanker()
+	err := ranker.SetMetric(metric)
+	if err == nil {
+		err = ranker.SetColorDistance(colorDistance)
+	}
+	if err == nil {
+		err = ranker.SetEdgeWeight(config.EdgeWeight)
+	}
+	if err == nil {
+		err = ranker.SetPyramidWeights(config.PyramidWeights)
+	}
+	if err != nil {
+		log.Fatalf("Error comparing images: %v", err.Error())
+	}
 	diff, err := ranker.Distance(image1, image2)
 	if err != nil {
 		log.Fatalf("Error comparing images: %v", err.Error())
@@ -191,182 +305,519 @@ func compare() {
 
 func download() {
 	workerClient := NewWorkerClient(*downloadEndpoint)
+	targetData, err := workerClient.GetTargetImageData()
+	if err != nil {
+		panic(err)
+	}
+	target, err := png.Decode(bytes.NewReader(targetData))
+	if err != nil {
+		panic(err)
+	}
+	width, height := target.Bounds().Size().X, target.Bounds().Size().Y
+	// Organisms can't be borrowed until the canvas size is known
+	setCanvasSize(width, height)
 	organism, err := workerClient.GetTopOrganism()
 	if err != nil {
 		panic(err)
 	}
-	file, err := os.Create(*downloadOutfile)
+	// The server doesn't send the diff, so it is calculated here, with the
+	// focus map that the server weights diffs with
+	var focusImage image.Image
+	focusImageData, err := workerClient.GetFocusImageData()
+	if err != nil {
+		panic(err)
+	}
+	if focusImageData != nil {
+		focusImage, err = png.Decode(bytes.NewReader(focusImageData))
+		if err != nil {
+			panic(err)
+		}
+	}
+	renderer := NewRenderer(width, height)
+	renderer.Render(organism.Background, organism.Instructions)
+	ranker := createRanker(focusImage)
+	diff, err := ranker.Distance(target, renderer.GetImage())
+	if err != nil {
+		panic(err)
+	}
+	header := NewPopulationHeader(0, width, height)
+	header.TargetHash = ImageHash(target)
+	header.Diff = diff
+	header.InstructionCount = len(organism.Instructions)
+	// The diff was calculated with this config
+	header.Config = config
+	err = SavePopulationFile(*downloadOutfile, header, organism.SaveEncoded(config.PopulationFileEncoding))
 	if err != nil {
 		panic(err)
 	}
-	defer file.Close()
-	line := organism.SaveV2()
-	file.Write(line)
-	file.WriteString("\n")
 }
 
 func scale() {
-	file, err := os.Open(*scaleCmdFile)
+	header, organism := loadPopulation(*scaleCmdFile)
+	for i, instruction := range organism.Instructions {
+		instruction = instruction.Scale(*scaleCmdFactor)
+		organism.Instructions[i] = instruction
+	}
+	if header.HasDimensions() {
+		header.Width = int(math.Round(float64(header.Width) * float64(*scaleCmdFactor)))
+		header.Height = int(math.Round(float64(header.Height) * float64(*scaleCmdFactor)))
+	}
+	header.Version = PopulationFormatVersion
+	header.InstructionCount = len(organism.Instructions)
+	header.Created = time.Now()
+	err := SavePopulationFile(*scaleCmdOutputFile, header, organism.SaveEncoded(config.PopulationFileEncoding))
 	if err != nil {
-		panic(err.Error())
+		log.Fatalf("Error saving population file: %v", err.Error())
 	}
-	defer file.Close()
-	outfile, err := os.Create(*scaleCmdOutputFile)
+}
+
+func render() {
+	header, organism := loadPopulation(*renderCmdFile)
+	width, height := outputSize(header, *renderCmdWidth, *renderCmdHeight)
+	scaleToOutputSize(header, organism, width)
+	setCanvasSize(width, height)
+	renderer := NewRenderer(width, height)
+	renderer.Render(organism.Background, organism.Instructions)
+	renderer.SaveToFile(*renderCmdOutputFile)
+}
+
+// loadPopulation loads the header and top organism from a population file
+func loadPopulation(filename string) (*PopulationHeader, *Organism) {
+	header, organism, err := LoadPopulationFile(filename)
 	if err != nil {
-		panic(err.Error())
+		log.Fatalf("Error loading population file: %v", err.Error())
+	}
+	return header, organism
+}
+
+// outputSize determines the size of an image created from a population file.
+// By default, the dimensions embedded in the population file are used. If only
+// one dimension is specified, the other one keeps the original aspect ratio.
+func outputSize(header *PopulationHeader, width int, height int) (int, int) {
+	if !header.HasDimensions() {
+		if width <= 0 || height <= 0 {
+			log.Fatalln("The population file doesn't include dimensions, --width and --height are required")
+		}
+		return width, height
+	}
+	if width <= 0 && height <= 0 {
+		return header.Width, header.Height
+	}
+	if height <= 0 {
+		height = int(math.Round(float64(width) * float64(header.Height) / float64(header.Width)))
+	} else if width <= 0 {
+		width = int(math.Round(float64(height) * float64(header.Width) / float64(header.Height)))
+	}
+	return width, height
+}
+
+// scaleToOutputSize scales the instructions of an organism from the dimensions
+// embedded in the population file to the output width.
+func scaleToOutputSize(header *PopulationHeader, organism *Organism, width int) {
+	if !header.HasDimensions() || width == header.Width {
+		return
+	}
+	factor := float32(width) / float32(header.Width)
+	for i, instruction := range organism.Instructions {
+		organism.Instructions[i] = instruction.Scale(factor)
 	}
-	defer outfile.Close()
-	reader := bufio.NewScanner(file)
-	// 10 MB buffer for organisms, they might be really big. Adjust as needed.
-	buf := make([]byte, 1024*1024*10)
-	reader.Buffer(buf, len(buf))
-	reader.Scan()
-	iterationLine := reader.Text()
+}
 
-	for reader.Scan() {
-		line := reader.Bytes()
-		if len(line) == 0 {
-			continue
+func export() {
+	header, organism := loadPopulation(*exportCmdFile)
+	width, height := outputSize(header, *exportCmdWidth, *exportCmdHeight)
+	scaleToOutputSize(header, organism, width)
+	switch *exportCmdFormat {
+	case "svg":
+		data, skipped := RenderSVG(width, height, organism.Background, organism.Instructions)
+		if skipped == len(organism.Instructions) && skipped > 0 {
+			log.Fatalf("None of the %v instructions have an svg form, export to png instead", skipped)
+		}
+		if skipped > 0 {
+			log.Printf("Warning: %v of %v instructions have no svg form and were left out", skipped, len(organism.Instructions))
+		}
+		err := ioutil.WriteFile(*exportCmdOutfile, data, 0644)
+		if err != nil {
+			log.Fatalf("Error writing svg file: %v", err.Error())
 		}
-		organism := &Organism{}
-		organism.Load(line)
-		for i, instruction := range organism.Instructions {
-			instruction = instruction.Scale(*scaleCmdFactor)
-			organism.Instructions[i] = instruction
+	case "png":
+		setCanvasSize(width, height)
+		renderer := NewRenderer(width, height)
+		renderer.Render(organism.Background, organism.Instructions)
+		err := renderer.SaveToFile(*exportCmdOutfile)
+		if err != nil {
+			log.Fatalf("Error writing png file: %v", err.Error())
 		}
-		line = organism.Save()
-		outfile.WriteString(iterationLine)
-		// TODO: verify if this newline is needed or not...
-		outfile.WriteString("\n")
-		outfile.Write(line)
-		outfile.WriteString("\n")
 	}
 }
 
-func render() {
-	file, err := os.Open(*renderCmdFile)
+func exportStrokes() {
+	_, organism := loadPopulation(*exportStrokesCmdFile)
+	err := ioutil.WriteFile(*exportStrokesCmdOutfile, SaveBrushStrokes(organism.Instructions), 0644)
 	if err != nil {
-		panic(err.Error())
+		log.Fatalf("Error writing brush strokes: %v", err.Error())
 	}
-	defer file.Close()
-	reader := bufio.NewScanner(file)
-	// 10 MB buffer for organisms, they might be really big. Adjust as needed.
-	buf := make([]byte, 1024*1024*10)
-	reader.Buffer(buf, len(buf))
-	// skip first line
-	reader.Scan()
-	if reader.Scan() {
-		line := reader.Bytes()
-		organism := &Organism{}
-		organism.Load(line)
-		renderer := NewRenderer(*renderCmdWidth, *renderCmdHeight)
-		renderer.Render(organism.Instructions)
-		renderer.SaveToFile(*renderCmdOutputFile)
-	} else {
-		log.Println("No organisms found in file")
+}
+
+func importStrokes() {
+	data, err := ioutil.ReadFile(*importStrokesCmdFile)
+	if err != nil {
+		log.Fatalf("Error reading brush strokes: %v", err.Error())
+	}
+	instructions, err := LoadBrushStrokes(data)
+	if err != nil {
+		log.Fatalf("Error parsing brush strokes: %v", err.Error())
+	}
+	organism := &Organism{Instructions: instructions}
+	// Brush strokes don't include the size of the canvas
+	header := NewPopulationHeader(0, 0, 0)
+	header.InstructionCount = len(organism.Instructions)
+	err = SavePopulationFile(*importStrokesCmdOutfile, header, organism.SaveEncoded(config.PopulationFileEncoding))
+	if err != nil {
+		log.Fatalf("Error saving population file: %v", err.Error())
+	}
+}
+
+func importSVG() {
+	data, err := ioutil.ReadFile(*importSVGCmdFile)
+	if err != nil {
+		log.Fatalf("Error reading svg file: %v", err.Error())
+	}
+	target := loadImage(*importSVGCmdTarget)
+	width, height := target.Bounds().Size().X, target.Bounds().Size().Y
+	organism, err := ImportSVG(data, width, height)
+	if err != nil {
+		log.Fatalf("Error importing svg file: %v", err.Error())
+	}
+	if organism.Background == nil {
+		// Same as a random organism
+		organism.Background = MeanColor(target)
+	}
+	outfile := *importSVGCmdOutfile
+	if outfile == "" {
+		outfile = populationFilename(*importSVGCmdTarget)
+	}
+	header := NewPopulationHeader(0, width, height)
+	header.TargetHash = ImageHash(target)
+	header.InstructionCount = len(organism.Instructions)
+	err = SavePopulationFile(outfile, header, organism.SaveEncoded(config.PopulationFileEncoding))
+	if err != nil {
+		log.Fatalf("Error saving population file: %v", err.Error())
+	}
+	log.Printf("Imported %v instructions into %v", len(organism.Instructions), outfile)
+}
+
+// targetBaseFilename returns the name of the target file without any directories
+func targetBaseFilename(targetFile string) string {
+	if strings.Contains(targetFile, "\\") {
+		parts := strings.Split(targetFile, "\\")
+		return parts[len(parts)-1]
+	} else if strings.Contains(targetFile, "/") {
+		parts := strings.Split(targetFile, "/")
+		return parts[len(parts)-1]
+	}
+	return targetFile
+}
+
+// populationFilename returns the name of the population file that the
+// server saves to and resumes from for a target file.
+func populationFilename(targetFile string) string {
+	return targetBaseFilename(targetFile) + ".population.txt"
+}
+
+func replay() {
+	if *replayCmdIteration > 0 && *replayCmdSimilarity > 0 {
+		log.Fatalln("Only one of --iteration and --similarity can be specified")
+	}
+	var snapshot *JournalEntry
+	reached := false
+	organism, last, err := ReplayJournal(*replayCmdFile, func(entry *JournalEntry) bool {
+		if *replayCmdIteration > 0 && entry.Iteration > *replayCmdIteration {
+			return false
+		}
+		if *replayCmdSimilarity > 0 {
+			if reached {
+				return false
+			}
+			reached = Similarity(entry.Diff) >= *replayCmdSimilarity
+		}
+		if entry.IsSnapshot() {
+			// Organisms can't be borrowed until the canvas size is known
+			if snapshot == nil {
+				setCanvasSize(entry.Width, entry.Height)
+			}
+			snapshot = entry
+		}
+		return true
+	})
+	if _, mismatch := err.(*JournalMismatchError); mismatch {
+		log.Printf("Warning: %v", err.Error())
+	} else if err != nil {
+		log.Fatalf("Error replaying journal: %v", err.Error())
+	}
+	if *replayCmdSimilarity > 0 && !reached {
+		log.Printf("Warning: similarity %v%% was never reached, using the last organism in the journal", *replayCmdSimilarity)
 	}
+	header := NewPopulationHeader(last.Iteration, snapshot.Width, snapshot.Height)
+	header.TargetHash = snapshot.TargetHash
+	header.InstructionCount = len(organism.Instructions)
+	// Older journals don't record the config that the diffs were calculated
+	// with, so their diffs can't be verified
+	if snapshot.Config != nil {
+		header.Diff = last.Diff
+		header.Config = snapshot.Config
+	}
+	err = SavePopulationFile(*replayCmdOutfile, header, organism.SaveEncoded(config.PopulationFileEncoding))
+	if err != nil {
+		log.Fatalf("Error saving population file: %v", err.Error())
+	}
+	log.Printf("Replayed iteration %v (similarity %v, instructions=%v)", last.Iteration, FormatProgress(last.Diff, last.Diff), len(organism.Instructions))
+}
+
+// journalFilename returns the name of the journal file that the server
+// records changes of the top organism in for a target file.
+func journalFilename(targetFile string) string {
+	return targetBaseFilename(targetFile) + ".journal.txt"
 }
 
 func server() {
 	start := time.Now()
 	target := loadImage(*targetFile)
-	objectPool.SetRendererBounds(target.Bounds().Size().X, target.Bounds().Size().Y)
+	setCanvasSize(target.Bounds().Size().X, target.Bounds().Size().Y)
 	var focusImage image.Image
 	if *focusFile != "" {
 		focusImage = loadImage(*focusFile)
 	}
-	targetFilename := *targetFile
-	if strings.Contains(targetFilename, "\\") {
-		parts := strings.Split(targetFilename, "\\")
-		targetFilename = parts[len(parts)-1]
-	} else if strings.Contains(targetFilename, "/") {
-		parts := strings.Split(targetFilename, "/")
-		targetFilename = parts[len(parts)-1]
-	}
+	targetFilename := targetBaseFilename(*targetFile)
 	log.Printf("Target file: %v", targetFilename)
-	incubatorFilename := targetFilename + ".population.txt"
+	incubatorFilename := populationFilename(*targetFile)
 	renderer := NewRenderer(target.Bounds().Size().X, target.Bounds().Size().Y)
 	mutator := createMutator(target, focusImage)
 
-	ranker := NewRanker()
+	ranker := createRanker(focusImage)
 	incubator := NewIncubator(config, target, mutator, ranker)
+	if *serverJournal {
+		journal, err := OpenPatchJournal(journalFilename(*targetFile))
+		if err != nil {
+			log.Fatalf("Error opening journal: %v", err.Error())
+		}
+		defer journal.Close()
+		incubator.SetJournal(journal)
+	}
 	incubator.Start()
 	bestDiff := float32(1000.0)
+	bestUnweightedDiff := bestDiff
 	instructionCount := 0
+	lastMilestone := -1
 	_, err := os.Stat(incubatorFilename)
-	if err == nil {
+	checkpoints, _ := ListCheckpoints(incubatorFilename)
+	if err == nil || len(checkpoints) > 0 {
 		log.Println("Loading previous population")
-		incubator.Load(incubatorFilename)
+		loadLatestPopulation(incubator, incubatorFilename)
 		topOrganism := incubator.GetTopOrganism()
 		bestDiff = topOrganism.Diff
+		bestUnweightedDiff = topOrganism.UnweightedDiff()
 		instructionCount = len(topOrganism.Instructions)
 		log.Printf("Hash=%v, Initial diff: %v", topOrganism.Hash(), bestDiff)
 		objectPool.ReturnOrganism(topOrganism)
+		lastMilestone = similarityMilestone(bestDiff)
 	}
 
 	// Launch external server handler
 	serverPortal := NewServerPortal(incubator, focusImage)
 	serverPortal.Start()
 
+	signals := notifyShutdown()
 	lastSave := time.Now()
+	lastCheckpoint := time.Now()
+	saveProgress := func(topOrganism *Organism) {
+		err := incubator.Save(incubatorFilename)
+		if err != nil {
+			// Keep evolving, the next save might work
+			log.Printf("Error saving population file: %v", err.Error())
+		}
+		// incubator.Load(incubatorFilename)
+
+		renderer = objectPool.BorrowRenderer() //NewRenderer(target.Bounds().Size().X, target.Bounds().Size().Y)
+		renderer.Render(topOrganism.Background, topOrganism.Instructions)
+		renderer.SaveToFile(fmt.Sprintf("%v.%07d.png", targetFilename, incubator.Iteration))
+		lastSave = time.Now()
+		log.Printf("%v updated", incubatorFilename)
+		objectPool.ReturnRenderer(renderer)
+	}
 	for {
+		stop := shutdownRequested(signals)
+		if !stop && *serverMaxSeconds != 0 && time.Since(start) >= time.Second*time.Duration(*serverMaxSeconds) {
+			log.Printf("Stopping after %v seconds", *serverMaxSeconds)
+			stop = true
+		}
+		if stop {
+			// Keep accepting patches for a moment, so that workers that are
+			// stopped at the same time can flush theirs. Then stop the
+			// listener and apply the patches that are still queued before
+			// the final save, so that no improvements are lost.
+			drainEnd := time.Now().Add(serverDrainTime)
+			for time.Now().Before(drainEnd) {
+				incubator.Iterate()
+				serverPortal.Update()
+			}
+			serverPortal.Stop()
+			incubator.Iterate()
+			topOrganism := incubator.GetTopOrganism()
+			saveProgress(topOrganism)
+			objectPool.ReturnOrganism(topOrganism)
+			log.Println("Server stopped")
+			return
+		}
 		if incubator.Iteration%gcFrequency == 0 {
 			log.Println("Running garbage collection")
 			runtime.GC()
 			log.Println("garbage collection completed")
 		}
-		if *serverMaxSeconds != 0 && time.Since(start) >= time.Second*time.Duration(*serverMaxSeconds) {
-			return
-		}
 		// if (memprof != nil || prof != nil) && time.Since(start) >= profileDuration {
 		// 	return
 		// }
 		incubator.Iterate()
 		serverPortal.Update()
 		// stats := incubator.GetIncubatorStats()
-		displayProgress(bestDiff, instructionCount)
+		displayProgress(bestDiff, bestUnweightedDiff, instructionCount)
 		topOrganism := incubator.GetTopOrganism()
 		if topOrganism.Diff < bestDiff {
 			bestDiff = topOrganism.Diff
+			bestUnweightedDiff = topOrganism.UnweightedDiff()
 			instructionCount = len(topOrganism.Instructions)
+			milestone := similarityMilestone(bestDiff)
+			if lastMilestone < 0 {
+				lastMilestone = milestone
+			}
+			if config.CheckpointCount > 0 && (milestone > lastMilestone ||
+				time.Since(lastCheckpoint) >= time.Minute*time.Duration(config.CheckpointMinutes)) {
+				err := incubator.Checkpoint(incubatorFilename)
+				if err != nil {
+					log.Printf("Error making checkpoint: %v", err.Error())
+				} else {
+					log.Printf("Checkpoint made at %v", FormatProgress(bestDiff, bestUnweightedDiff))
+				}
+				lastCheckpoint = time.Now()
+				lastMilestone = milestone
+			}
 			if time.Since(lastSave) > time.Minute {
-				incubator.Save(incubatorFilename)
-				// incubator.Load(incubatorFilename)
-
-				renderer = objectPool.BorrowRenderer() //NewRenderer(target.Bounds().Size().X, target.Bounds().Size().Y)
-				renderer.Render(topOrganism.Instructions)
-				renderer.SaveToFile(fmt.Sprintf("%v.%07d.png", targetFilename, incubator.Iteration))
-				lastSave = time.Now()
-				log.Printf("%v updated", incubatorFilename)
-				objectPool.ReturnRenderer(renderer)
+				saveProgress(topOrganism)
 			}
 		}
 		objectPool.ReturnOrganism(topOrganism)
 	}
 }
 
+// notifyShutdown returns a channel that receives SIGINT and SIGTERM
+func notifyShutdown() chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	return signals
+}
+
+// shutdownRequested checks if a shutdown signal was received. After the
+// first signal, a second one terminates the process immediately.
+func shutdownRequested(signals chan os.Signal) bool {
+	select {
+	case sig := <-signals:
+		log.Printf("Received %v, shutting down. Repeat to exit immediately", sig)
+		signal.Stop(signals)
+		return true
+	default:
+		return false
+	}
+}
+
+// loadLatestPopulation loads a population file into the incubator. If the
+// population file is corrupt, the newest valid checkpoint is loaded instead.
+func loadLatestPopulation(incubator *Incubator, populationFile string) {
+	err := incubator.Load(populationFile)
+	if err == nil {
+		return
+	}
+	log.Printf("Error loading population file '%v': %v", populationFile, err.Error())
+	checkpoints, _ := ListCheckpoints(populationFile)
+	for _, checkpoint := range checkpoints {
+		log.Printf("Falling back to checkpoint '%v'", checkpoint)
+		err = incubator.Load(checkpoint)
+		if err == nil {
+			return
+		}
+		log.Printf("Error loading checkpoint '%v': %v", checkpoint, err.Error())
+	}
+	log.Fatalf("No valid population file or checkpoint found for '%v'. Move the files away to start a new population", populationFile)
+}
+
+// similarityMilestone returns the number of Config.CheckpointSimilarityStep
+// percentages that a diff has passed.
+func similarityMilestone(diff float32) int {
+	if config.CheckpointSimilarityStep <= 0 {
+		return 0
+	}
+	return int(math.Floor(float64(Similarity(diff) / config.CheckpointSimilarityStep)))
+}
+
+func createRanker(focusImage image.Image) *Ranker {
+	ranker := NewRanker()
+	err := ranker.SetMetric(config.FitnessMetric)
+	if err != nil {
+		log.Fatalf("Error in config.json FitnessMetric: %v", err.Error())
+	}
+	err = ranker.SetColorDistance(config.ColorDistance)
+	if err != nil {
+		log.Fatalf("Error in config.json ColorDistance: %v", err.Error())
+	}
+	err = ranker.SetEdgeWeight(config.EdgeWeight)
+	if err != nil {
+		log.Fatalf("Error in config.json EdgeWeight: %v", err.Error())
+	}
+	err = ranker.SetPyramidWeights(config.PyramidWeights)
+	if err != nil {
+		log.Fatalf("Error in config.json PyramidWeights: %v", err.Error())
+	}
+	err = ranker.SetFocusMap(focusImage, config.FocusFitnessWeight)
+	if err != nil {
+		log.Fatalf("Error in config.json FocusFitnessWeight: %v", err.Error())
+	}
+	return ranker
+}
+
 func createMutator(target image.Image, focusImage image.Image) *Mutator {
-	lineMutator := NewLineMutator(config, float32(target.Bounds().Size().X), float32(target.Bounds().Size().Y))
-	circleMutator := NewCircleMutator(config, float32(target.Bounds().Size().X), float32(target.Bounds().Size().Y))
-	polygonMutator := NewPolygonMutator(config, float32(target.Bounds().Size().X), float32(target.Bounds().Size().Y))
-	instructionMutators := []InstructionMutator{}
-	for _, instructionType := range config.InstructionTypes {
-		if instructionType == TypeCircle {
-			instructionMutators = append(instructionMutators, circleMutator)
+	switch config.MutationImportance {
+	case MutationImportanceFocus, MutationImportanceError, MutationImportanceUniform:
+	default:
+		log.Fatalf("Error in config.json MutationImportance: Unknown mutation importance '%v'", config.MutationImportance)
+	}
+	for name, placement := range config.InstructionPlacement {
+		if _, err := GetInstructionType(name); err != nil {
+			log.Fatalf("Error in config.json InstructionPlacement: %v", err.Error())
 		}
-		if instructionType == TypeLine {
-			instructionMutators = append(instructionMutators, lineMutator)
+		if placement != PlacementImportance && placement != PlacementError {
+			log.Fatalf("Error in config.json InstructionPlacement: Unknown placement '%v' for %v", placement, name)
 		}
-		if instructionType == TypePolygon {
-			instructionMutators = append(instructionMutators, polygonMutator)
+	}
+	if config.TargetColorSample != TargetColorMean && config.TargetColorSample != TargetColorCenter {
+		log.Fatalf("Error in config.json TargetColorSample: Unknown target color sample '%v'", config.TargetColorSample)
+	}
+	if config.OptimalColors && (config.OptimalColorStep < 1 || config.OptimalColorStep > 255) {
+		log.Fatalf("Error in config.json OptimalColorStep: Must be between 1 and 255, was %v", config.OptimalColorStep)
+	}
+	instructionMutators := []InstructionMutator{}
+	for _, name := range config.InstructionTypes {
+		instructionType, err := GetInstructionType(name)
+		if err != nil {
+			log.Fatalf("Error in config.json InstructionTypes: %v", err.Error())
 		}
+		instructionMut := instructionType.NewMutator(config, float32(target.Bounds().Size().X), float32(target.Bounds().Size().Y))
+		instructionMutators = append(instructionMutators, instructionMut)
 	}
-	mutator := NewMutator(instructionMutators, focusImage)
+	mutator := NewMutator(config, instructionMutators, target, focusImage)
 	return mutator
 }
 
-func displayProgress(bestDiff float32, instructionCount int) {
-	log.Printf("Similarity: %v (diff=%v, instructions=%v)", FormatProgress(bestDiff), bestDiff, instructionCount)
+func displayProgress(bestDiff float32, bestUnweightedDiff float32, instructionCount int) {
+	log.Printf("Similarity: %v (diff=%v, instructions=%v)", FormatProgress(bestDiff, bestUnweightedDiff), bestDiff, instructionCount)
 }
 
 func worker() {
@@ -380,7 +831,7 @@ func worker() {
 	if err != nil {
 		log.Fatalf("Error reading image: '%v'", err.Error())
 	}
-	objectPool.SetRendererBounds(target.Bounds().Size().X, target.Bounds().Size().Y)
+	setCanvasSize(target.Bounds().Size().X, target.Bounds().Size().Y)
 	var focusImage image.Image
 	focusImageData, err := client.GetFocusImageData()
 	if err != nil {
@@ -394,7 +845,7 @@ func worker() {
 		}
 	}
 	mutator := createMutator(target, focusImage)
-	ranker := NewRanker()
+	ranker := createRanker(focusImage)
 	incubator := NewIncubator(config, target, mutator, ranker)
 	incubator.Start()
 
@@ -414,17 +865,25 @@ func worker() {
 	portal.Start()
 
 	bestDiff := float32(1000.0)
+	bestUnweightedDiff := bestDiff
 	instructionCount := 0
 
 	if err == nil {
 		topOrganism := incubator.GetTopOrganism()
 		bestDiff = topOrganism.Diff
+		bestUnweightedDiff = topOrganism.UnweightedDiff()
 		instructionCount = len(topOrganism.Instructions)
 		log.Printf("Initial similarity: %.15f%%", (1.0-(bestDiff/maxImageDiff))*100)
 		objectPool.ReturnOrganism(topOrganism)
 	}
 
+	signals := notifyShutdown()
 	for {
+		if shutdownRequested(signals) {
+			portal.Stop()
+			log.Println("Worker stopped")
+			return
+		}
 		if incubator.Iteration%gcFrequency == 0 {
 			log.Println("Running garbage collection")
 			runtime.GC()
@@ -439,6 +898,7 @@ func worker() {
 		topOrganism := incubator.GetTopOrganism()
 		if topOrganism.Diff < bestDiff && topOrganism.Diff != -1 {
 			bestDiff = topOrganism.Diff
+			bestUnweightedDiff = topOrganism.UnweightedDiff()
 			instructionCount = len(topOrganism.Instructions)
 			portal.Export(topOrganism)
 		}
@@ -449,31 +909,190 @@ func worker() {
 			incubator.Iterate()
 			topOrganism := incubator.GetTopOrganism()
 			bestDiff = topOrganism.Diff
+			bestUnweightedDiff = topOrganism.UnweightedDiff()
 			instructionCount = len(topOrganism.Instructions)
 			objectPool.ReturnOrganism(topOrganism)
 			objectPool.ReturnOrganism(imported)
 		}
 
-		displayProgress(bestDiff, instructionCount)
+		displayProgress(bestDiff, bestUnweightedDiff, instructionCount)
 	}
 }
 
 // Generates an mp4 video file from a sequence of rendered organisms, showing
 // the path of evolution to the final image.
 func genvideo() {
-	// Create a local tmp dir
-	os.RemoveAll("tmp")
-	err := os.MkdirAll("tmp", 0755)
+	if *genvideoCmdFPS <= 0 {
+		log.Fatalln("--fps must be greater than zero")
+	}
+	if *genvideoCmdLength <= 0 && (*genvideoCmdJournal == "" || *genvideoCmdFrames <= 0) {
+		log.Fatalln("--length must be greater than zero, unless --frames is set with --journal")
+	}
+	prepareFramesFolder(*genvideoCmdFramesFolder)
+	if *genvideoCmdJournal != "" {
+		genvideoFramesFromJournal()
+	} else if *genvideoCmdPrefix != "" {
+		genvideoFramesFromFiles()
+	} else {
+		log.Fatalln("Either --prefix or --journal is required")
+	}
+	if *genvideoCmdFramesOnly {
+		log.Printf("Frames written to '%v'", *genvideoCmdFramesFolder)
+		return
+	}
+	if format := AnimationFormat(*genvideoCmdOutfile); format != "" {
+		genvideoAnimation(format)
+		return
+	}
+	// ffmpeg -framerate 10 -pattern_type glob -i "(prefix)*.png" video.mp4
+	ffmpeg := exec.Command(
+		"ffmpeg",
+		"-y",
+		"-framerate",
+		fmt.Sprint(*genvideoCmdFPS),
+		"-i",
+		// -i C:\myimages\img%03d.png
+		fmt.Sprintf("%v/%%05d.png", *genvideoCmdFramesFolder),
+		*genvideoCmdOutfile,
+	)
+	log.Printf("Running video encoder command...")
+	ffmpeg.Stderr = os.Stderr
+	ffmpeg.Stdout = os.Stdout
+	err := ffmpeg.Run()
+	if err != nil {
+		log.Fatalf("Error running video encoder: '%v'", err.Error())
+	}
+}
+
+// frameFilePattern matches the numbered png frames that videos are made from
+const frameFilePattern = "[0-9][0-9][0-9][0-9][0-9].png"
+
+// frameFilename returns the name of a numbered png frame
+func frameFilename(folder string, index int) string {
+	return fmt.Sprintf("%v/%05d.png", folder, index)
+}
+
+// prepareFramesFolder creates a folder for numbered png frames, and clears
+// out the frames of earlier runs.
+func prepareFramesFolder(folder string) {
+	err := os.MkdirAll(folder, 0755)
 	if err != nil {
 		log.Fatalf("Error getting temporary folder: '%v'", err.Error())
 	}
+	oldFrames, _ := filepath.Glob(filepath.Join(folder, frameFilePattern))
+	for _, oldFrame := range oldFrames {
+		os.Remove(oldFrame)
+	}
+}
+
+func speedpaint() {
+	header, organism := loadPopulation(*speedpaintCmdFile)
+	width, height := outputSize(header, *speedpaintCmdWidth, *speedpaintCmdHeight)
+	scaleToOutputSize(header, organism, width)
+	setCanvasSize(width, height)
+	if len(organism.Instructions) == 0 {
+		log.Fatalln("The population file doesn't have any instructions to paint")
+	}
+	if *speedpaintCmdFPS <= 0 {
+		log.Fatalln("--fps must be greater than zero")
+	}
+	perFrame := *speedpaintCmdPerFrame
+	if perFrame <= 0 {
+		frames := *speedpaintCmdFPS * *speedpaintCmdLength
+		if frames <= 0 {
+			log.Fatalln("--length must be greater than zero, unless --per-frame is set")
+		}
+		perFrame = (len(organism.Instructions) + frames - 1) / frames
+		if perFrame < 1 {
+			perFrame = 1
+		}
+	}
+	frames := (len(organism.Instructions) + perFrame - 1) / perFrame
+	log.Printf("Painting %v instructions in %v frames at %vx%v", len(organism.Instructions), frames, width, height)
+
+	format := AnimationFormat(*speedpaintCmdOutfile)
+	if format == "" {
+		prepareFramesFolder(*speedpaintCmdOutfile)
+		err := RenderPaintingFrames(organism, perFrame, width, height, func(index int, img image.Image) error {
+			return gg.SavePNG(frameFilename(*speedpaintCmdOutfile, index), img)
+		})
+		if err != nil {
+			log.Fatalf("Error rendering frames: %v", err.Error())
+		}
+		log.Printf("Frames written to '%v'", *speedpaintCmdOutfile)
+		return
+	}
+	file, err := os.Create(*speedpaintCmdOutfile)
+	if err != nil {
+		log.Fatalf("Error creating '%v': %v", *speedpaintCmdOutfile, err.Error())
+	}
+	defer file.Close()
+	writer, err := NewAnimationWriter(format, file, frames, *speedpaintCmdFPS, *speedpaintCmdLoop)
+	if err != nil {
+		log.Fatalf("Error creating animation: %v", err.Error())
+	}
+	err = RenderPaintingFrames(organism, perFrame, width, height, func(index int, img image.Image) error {
+		return writer.AddFrame(img)
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		log.Fatalf("Error writing '%v': %v", *speedpaintCmdOutfile, err.Error())
+	}
+	log.Printf("Wrote %v frames to '%v'", frames, *speedpaintCmdOutfile)
+}
+
+// genvideoAnimation encodes the frames as an animated gif or png, without ffmpeg
+func genvideoAnimation(format string) {
+	frameFiles, _ := filepath.Glob(filepath.Join(*genvideoCmdFramesFolder, frameFilePattern))
+	if len(frameFiles) == 0 {
+		log.Fatalf("No frames found in '%v'", *genvideoCmdFramesFolder)
+	}
+	file, err := os.Create(*genvideoCmdOutfile)
+	if err != nil {
+		log.Fatalf("Error creating '%v': %v", *genvideoCmdOutfile, err.Error())
+	}
+	defer file.Close()
+	writer, err := NewAnimationWriter(format, file, len(frameFiles), *genvideoCmdFPS, *genvideoCmdLoop)
+	if err != nil {
+		log.Fatalf("Error creating animation: %v", err.Error())
+	}
+	for i, frameFile := range frameFiles {
+		if i%100 == 0 {
+			log.Printf("Encoding frame %v/%v", i, len(frameFiles))
+		}
+		frame := loadImage(frameFile)
+		size := frame.Bounds().Size()
+		if *genvideoCmdMaxSize > 0 && (size.X > *genvideoCmdMaxSize || size.Y > *genvideoCmdMaxSize) {
+			factor := float64(*genvideoCmdMaxSize) / math.Max(float64(size.X), float64(size.Y))
+			ctx := gg.NewContext(int(math.Round(float64(size.X)*factor)), int(math.Round(float64(size.Y)*factor)))
+			ctx.Scale(factor, factor)
+			ctx.DrawImage(frame, 0, 0)
+			frame = ctx.Image()
+		}
+		err = writer.AddFrame(frame)
+		if err != nil {
+			log.Fatalf("Error encoding frame '%v': %v", frameFile, err.Error())
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		log.Fatalf("Error writing '%v': %v", *genvideoCmdOutfile, err.Error())
+	}
+	log.Printf("Wrote %v frames to '%v'", len(frameFiles), *genvideoCmdOutfile)
+}
+
+// genvideoFramesFromFiles copies the png files saved by the server into the
+// frames folder, skipping files to reach the length of the video.
+func genvideoFramesFromFiles() {
 	// Get list of existing files
 	files, err := ioutil.ReadDir(*genvideoCmdSourceDir)
 	if err != nil {
 		log.Fatalf("Error getting list of files for '%v': '%v'", *genvideoCmdSourceDir, err.Error())
 	}
 	srcNumFrames := len(files)
-	destNumFrames := framesPerSecond * *genvideoCmdLength
+	destNumFrames := *genvideoCmdFPS * *genvideoCmdLength
 	skip := srcNumFrames / destNumFrames
 	if skip < 1 {
 		skip = 1
@@ -484,7 +1103,7 @@ func genvideo() {
 		if strings.HasPrefix(fileinfo.Name(), *genvideoCmdPrefix) {
 			if count%skip == 0 {
 				sourceFilename := fmt.Sprintf("%v/%v", *genvideoCmdSourceDir, fileinfo.Name())
-				destinationFilename := fmt.Sprintf("%v/%v", "tmp", fmt.Sprintf("%05v.png", outputNum))
+				destinationFilename := frameFilename(*genvideoCmdFramesFolder, outputNum)
 				log.Printf("Copying '%v' to '%v'", sourceFilename, destinationFilename)
 				// read data
 				data, err := ioutil.ReadFile(sourceFilename)
@@ -500,22 +1119,32 @@ func genvideo() {
 			count++
 		}
 	}
-	// ffmpeg -framerate 10 -pattern_type glob -i "(prefix)*.png" video.mp4
-	ffmpeg := exec.Command(
-		"ffmpeg",
-		"-y",
-		"-framerate",
-		fmt.Sprint(framesPerSecond),
-		"-i",
-		// -i C:\myimages\img%03d.png
-		fmt.Sprintf("%v/%%05d.png", "tmp"),
-		*genvideoCmdOutfile,
-	)
-	log.Printf("Running video encoder command...")
-	ffmpeg.Stderr = os.Stderr
-	ffmpeg.Stdout = os.Stdout
-	err = ffmpeg.Run()
+}
+
+// genvideoFramesFromJournal re-renders frames from a journal file into the
+// frames folder, at any resolution and frame count.
+func genvideoFramesFromJournal() {
+	summary, err := SummarizeJournal(*genvideoCmdJournal)
 	if err != nil {
-		log.Fatalf("Error running video encoder: '%v'", err.Error())
+		log.Fatalf("Error reading journal: %v", err.Error())
+	}
+	frames := *genvideoCmdFrames
+	if frames <= 0 {
+		frames = *genvideoCmdFPS * *genvideoCmdLength
+	}
+	width, height := outputSize(&PopulationHeader{Width: summary.Width, Height: summary.Height}, *genvideoCmdWidth, *genvideoCmdHeight)
+	if brushSet != nil {
+		brushSet.SetCanvasSize(width, height)
+	}
+	log.Printf("Rendering %v frames at %vx%v from iterations %v to %v", frames, width, height, summary.FirstIteration, summary.LastIteration)
+	iterations := FrameIterations(summary.FirstIteration, summary.LastIteration, frames, *genvideoCmdEasing)
+	err = RenderJournalFrames(*genvideoCmdJournal, iterations, width, height, func(index int, img image.Image) error {
+		if index%100 == 0 {
+			log.Printf("Rendering frame %v/%v (iteration %v)", index, frames, iterations[index])
+		}
+		return gg.SavePNG(frameFilename(*genvideoCmdFramesFolder, index), img)
+	})
+	if err != nil {
+		log.Fatalf("Error rendering frames: %v", err.Error())
 	}
 }
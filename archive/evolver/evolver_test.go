@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain sets up the global state that the evolver commands set up
+func TestMain(m *testing.M) {
+	config = DefaultConfig()
+	objectPool = createObjectPool()
+	os.Exit(m.Run())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"image"
+	"math/rand"
+	"sort"
+)
+
+// An ImportanceMap samples positions in an image in proportion to the weight
+// of each pixel, so that mutations go where they matter most.
+type ImportanceMap struct {
+	width  int
+	height int
+	// cumulative holds the running total of the weights, row by row
+	cumulative []float64
+}
+
+// NewImportanceMap creates an ImportanceMap from the weight of each pixel.
+// Weights must not be negative.
+func NewImportanceMap(width int, height int, weight func(x int, y int) float64) *ImportanceMap {
+	importanceMap := &ImportanceMap{
+		width:      width,
+		height:     height,
+		cumulative: make([]float64, width*height),
+	}
+	var total float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			total += weight(x, y)
+			importanceMap.cumulative[y*width+x] = total
+		}
+	}
+	return importanceMap
+}
+
+// NewFocusImportanceMap weights pixels by the brightness (red channel) of a
+// focus map
+func NewFocusImportanceMap(focusMap image.Image) *ImportanceMap {
+	size := focusMap.Bounds().Size()
+	return NewImportanceMap(size.X, size.Y, func(x int, y int) float64 {
+		r, _, _, _ := focusMap.At(x, y).RGBA()
+		return float64(r)
+	})
+}
+
+// NewDiffImportanceMap weights pixels by their diff
+func NewDiffImportanceMap(diffMap *DiffMap) *ImportanceMap {
+	return NewImportanceMap(len(diffMap.Diffs), len(diffMap.Diffs[0]), func(x int, y int) float64 {
+		return float64(diffMap.Diffs[x][y])
+	})
+}
+
+func (importanceMap *ImportanceMap) total() float64 {
+	return importanceMap.cumulative[len(importanceMap.cumulative)-1]
+}
+
+// Sample returns a random position, in proportion to the weight of the
+// pixels. If all weights are zero, the position is uniformly random.
+func (importanceMap *ImportanceMap) Sample() (float32, float32) {
+	total := importanceMap.total()
+	if total <= 0 {
+		return rand.Float32() * float32(importanceMap.width), rand.Float32() * float32(importanceMap.height)
+	}
+	value := rand.Float64() * total
+	// The first pixel whose range contains the value
+	i := sort.Search(len(importanceMap.cumulative), func(i int) bool {
+		return importanceMap.cumulative[i] > value
+	})
+	if i >= len(importanceMap.cumulative) {
+		i = len(importanceMap.cumulative) - 1
+	}
+	// Spread positions over the whole pixel
+	x, y := i%importanceMap.width, i/importanceMap.width
+	return float32(x) + rand.Float32(), float32(y) + rand.Float32()
+}
+
+// Weight returns the weight of the pixel at a position. Positions outside of
+// the image are clamped to its border.
+func (importanceMap *ImportanceMap) Weight(x float32, y float32) float64 {
+	px := minInt(maxInt(int(x), 0), importanceMap.width-1)
+	py := minInt(maxInt(int(y), 0), importanceMap.height-1)
+	i := py*importanceMap.width + px
+	if i == 0 {
+		return importanceMap.cumulative[0]
+	}
+	return importanceMap.cumulative[i] - importanceMap.cumulative[i-1]
+}
+
+// MeanWeight returns the average weight of the pixels
+func (importanceMap *ImportanceMap) MeanWeight() float64 {
+	return importanceMap.total() / float64(len(importanceMap.cumulative))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestImportanceMapSamplesInProportion(t *testing.T) {
+	// Only the pixels (1, 0) and (2, 3) have a weight, and (2, 3) has three
+	// times as much
+	importanceMap := NewImportanceMap(4, 5, func(x int, y int) float64 {
+		if x == 1 && y == 0 {
+			return 1
+		}
+		if x == 2 && y == 3 {
+			return 3
+		}
+		return 0
+	})
+
+	counts := map[[2]int]int{}
+	const samples = 10000
+	for i := 0; i < samples; i++ {
+		x, y := importanceMap.Sample()
+		counts[[2]int{int(x), int(y)}]++
+	}
+	if len(counts) != 2 {
+		t.Fatalf("Expected only weighted pixels to be sampled, got %v", counts)
+	}
+	if share := float64(counts[[2]int{2, 3}]) / samples; math.Abs(share-0.75) > 0.03 {
+		t.Errorf("Expected about 75%% of the samples at (2, 3), got %v%%", share*100)
+	}
+}
+
+func TestImportanceMapWithoutWeightsSamplesUniformly(t *testing.T) {
+	importanceMap := NewImportanceMap(4, 5, func(x int, y int) float64 { return 0 })
+	for i := 0; i < 1000; i++ {
+		x, y := importanceMap.Sample()
+		if x < 0 || x >= 4 || y < 0 || y >= 5 {
+			t.Fatalf("Expected a position within the map, got (%v, %v)", x, y)
+		}
+	}
+}
+
+func TestImportanceMapWeight(t *testing.T) {
+	importanceMap := NewImportanceMap(3, 2, func(x int, y int) float64 { return float64(x + y*3) })
+	if weight := importanceMap.Weight(2.5, 1.5); weight != 5 {
+		t.Errorf("Expected weight 5, got %v", weight)
+	}
+	if weight := importanceMap.Weight(-10, 100); weight != 3 {
+		t.Errorf("Expected positions outside of the map to be clamped to weight 3, got %v", weight)
+	}
+	if mean := importanceMap.MeanWeight(); mean != 2.5 {
+		t.Errorf("Expected mean weight 2.5, got %v", mean)
+	}
+}
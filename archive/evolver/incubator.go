@@ -5,47 +5,51 @@ import (
 	"fmt"
 	"image"
 	"image/png"
-	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
 	"sort"
-	"strconv"
 )
 
 // An Incubator contains a population of Organisms and provides
 // functionality to incrementally improve the population's fitness.
 type Incubator struct {
-	Iteration             int
-	config                *Config
-	target                image.Image
-	topOrganism           *Organism
-	currentGeneration     []*Organism
-	currentGenerationMap  map[string]*Organism
-	incomingPatches       []*Patch
-	mutator               *Mutator
-	ranker                *Ranker
-	nextOptimization      int // Keeps track of how many iterations before an optimization should kick off.
-	organismRecord        map[string]bool
-	workerCloneChan       chan *Organism
-	workerCloneResultChan chan *Organism
-	workerHashChan        chan *Organism
-	workerHashResultChan  chan bool
-	workerRankChan        chan *Organism
-	workerRankResultChan  chan WorkItemResult
-	workerSaveChan        chan *Organism
-	workerSaveResultChan  chan []byte
-	workerLoadChan        chan []byte
-	workerLoadResultChan  chan *Organism
-	egressChan            chan *GetOrganismRequest
-	incomingPatchChan     chan *Patch
-	incomingOrganismChan  chan *Organism
-	saveChan              chan *SaveRequest
-	loadChan              chan *LoadRequest
-	iterateChan           chan VoidCallback
-	getTargetDataChan     chan *TargetImageDataRequest
-	scaleChan             chan *IncubatorScaleRequest
-	optimizeChan          <-chan PatchOperation
+	Iteration              int
+	config                 *Config
+	target                 image.Image
+	targetHash             string
+	topOrganism            *Organism
+	currentGeneration      []*Organism
+	currentGenerationMap   map[string]*Organism
+	incomingPatches        []*Patch
+	mutator                *Mutator
+	ranker                 *Ranker
+	nextOptimization       int // Keeps track of how many iterations before an optimization should kick off.
+	organismRecord         map[string]bool
+	workerCloneChan        chan *Organism
+	workerCloneResultChan  chan *Organism
+	workerHashChan         chan *Organism
+	workerHashResultChan   chan bool
+	workerRankChan         chan *Organism
+	workerRankResultChan   chan WorkItemResult
+	workerSaveChan         chan *Organism
+	workerSaveResultChan   chan []byte
+	workerLoadChan         chan []byte
+	workerLoadResultChan   chan *Organism
+	workerRefineChan       chan *Organism
+	workerRefineResultChan chan *Organism
+	egressChan             chan *GetOrganismRequest
+	incomingPatchChan      chan *Patch
+	incomingOrganismChan   chan *Organism
+	saveChan               chan *SaveRequest
+	checkpointChan         chan *SaveRequest
+	loadChan               chan *LoadRequest
+	iterateChan            chan VoidCallback
+	getTargetDataChan      chan *TargetImageDataRequest
+	scaleChan              chan *IncubatorScaleRequest
+	optimizeChan           <-chan PatchOperation
+	journal                *PatchJournal // optional, records changes of the top organism
+	mutationStats          *MutationStats
 }
 
 // NewIncubator returns a new `Incubator`
@@ -53,6 +57,7 @@ func NewIncubator(config *Config, target image.Image, mutator *Mutator, ranker *
 	incubator := new(Incubator)
 	incubator.config = config
 	incubator.target = target
+	incubator.targetHash = ImageHash(target)
 	incubator.mutator = mutator
 	incubator.ranker = ranker
 	incubator.ranker.PrecalculateLabs(target)
@@ -61,6 +66,7 @@ func NewIncubator(config *Config, target image.Image, mutator *Mutator, ranker *
 	incubator.incomingPatches = make([]*Patch, 0, 100)
 
 	incubator.organismRecord = map[string]bool{}
+	incubator.mutationStats = NewMutationStats()
 
 	// Communication channels
 	incubator.workerCloneChan = make(chan *Organism, config.MaxPopulation)
@@ -73,10 +79,13 @@ func NewIncubator(config *Config, target image.Image, mutator *Mutator, ranker *
 	incubator.workerSaveResultChan = make(chan []byte, 1)
 	incubator.workerLoadChan = make(chan []byte, 1)
 	incubator.workerLoadResultChan = make(chan *Organism, 1)
+	incubator.workerRefineChan = make(chan *Organism, config.MaxPopulation)
+	incubator.workerRefineResultChan = make(chan *Organism, config.MaxPopulation)
 	incubator.egressChan = make(chan *GetOrganismRequest)
 	incubator.incomingPatchChan = make(chan *Patch)
 	incubator.incomingOrganismChan = make(chan *Organism)
 	incubator.saveChan = make(chan *SaveRequest)
+	incubator.checkpointChan = make(chan *SaveRequest)
 	incubator.loadChan = make(chan *LoadRequest)
 	incubator.iterateChan = make(chan VoidCallback)
 	incubator.getTargetDataChan = make(chan *TargetImageDataRequest)
@@ -86,6 +95,7 @@ func NewIncubator(config *Config, target image.Image, mutator *Mutator, ranker *
 		target.Bounds().Size().X,
 		target.Bounds().Size().Y,
 		ranker,
+		mutator,
 		incubator.workerCloneChan,
 		incubator.workerCloneResultChan,
 		incubator.workerHashChan,
@@ -96,12 +106,20 @@ func NewIncubator(config *Config, target image.Image, mutator *Mutator, ranker *
 		incubator.workerSaveResultChan,
 		incubator.workerLoadChan,
 		incubator.workerLoadResultChan,
+		incubator.workerRefineChan,
+		incubator.workerRefineResultChan,
 		config.WorkerCount,
 	)
 	localPool.Start()
 	return incubator
 }
 
+// SetJournal makes the incubator record every change of the top organism
+// in a journal. It must be called before Start.
+func (incubator *Incubator) SetJournal(journal *PatchJournal) {
+	incubator.journal = journal
+}
+
 // Start fires up the incubator thread
 func (incubator *Incubator) Start() {
 	go func() {
@@ -121,11 +139,11 @@ func (incubator *Incubator) Start() {
 				incubator.iterate()
 				cb <- nil
 			case req := <-incubator.saveChan:
-				incubator.save(req.Filename)
-				req.Callback <- nil
+				req.Callback <- incubator.save(req.Filename)
+			case req := <-incubator.checkpointChan:
+				req.Callback <- incubator.checkpoint(req.Filename)
 			case req := <-incubator.loadChan:
-				incubator.load(req.Filename)
-				req.Callback <- nil
+				req.Callback <- incubator.load(req.Filename)
 			}
 		}
 	}()
@@ -154,6 +172,7 @@ func (incubator *Incubator) iterate() {
 		incubator.addOrganism(incubator.topOrganism)
 		incubator.scorePopulation()
 		log.Printf("initial diff (incubator)=%v", incubator.topOrganism.Diff)
+		incubator.writeJournalSnapshot()
 		incubator.currentGeneration = incubator.currentGeneration[:0]
 		delete(incubator.currentGenerationMap, incubator.topOrganism.Hash())
 	}
@@ -165,6 +184,7 @@ func (incubator *Incubator) iterate() {
 	// top organism so that no improvements are lost.
 	improved := []*Organism{}
 	for _, organism := range incubator.currentGeneration {
+		incubator.mutationStats.Record(organism.mutation, organism.Diff < incubator.topOrganism.Diff)
 		if organism.Diff < incubator.topOrganism.Diff {
 			// log.Printf("Improved organism: %v - %v, current=%v", organism.Hash(), FormatProgress(organism.Diff), FormatProgress(incubator.topOrganism.Diff))
 			improved = append(improved, organism)
@@ -214,6 +234,12 @@ func (incubator *Incubator) iterate() {
 	}
 	// log.Printf("End iteration %v", incubator.Iteration)
 	incubator.Iteration++
+	if incubator.config.JournalSnapshotFrequency > 0 && incubator.Iteration%incubator.config.JournalSnapshotFrequency == 0 {
+		incubator.writeJournalSnapshot()
+	}
+	if incubator.config.MutationStatsFrequency > 0 && incubator.Iteration%incubator.config.MutationStatsFrequency == 0 {
+		log.Printf("Mutation stats: %v", incubator.mutationStats)
+	}
 }
 
 func (incubator *Incubator) clearCurrentGeneration() {
@@ -244,61 +270,135 @@ func (incubator *Incubator) applyIncomingPatches() {
 }
 
 // Save saves the current population to the specified file
-func (incubator *Incubator) Save(filename string) {
+func (incubator *Incubator) Save(filename string) error {
 	callback := make(chan error)
 	request := &SaveRequest{
 		Filename: filename,
 		Callback: callback,
 	}
 	incubator.saveChan <- request
-	<-callback
+	return <-callback
 }
 
-func (incubator *Incubator) save(filename string) {
-	file, err := os.Create(filename)
-	if err != nil {
-		log.Fatalf("Error saving incubator: %v", err.Error())
-	}
-	defer file.Close()
-	file.WriteString(fmt.Sprintf("%v\n", incubator.Iteration))
+func (incubator *Incubator) save(filename string) error {
+	header := NewPopulationHeader(
+		incubator.Iteration,
+		incubator.target.Bounds().Size().X,
+		incubator.target.Bounds().Size().Y,
+	)
+	header.TargetHash = incubator.targetHash
+	header.Diff = incubator.topOrganism.Diff
+	header.InstructionCount = len(incubator.topOrganism.Instructions)
+	header.Config = incubator.config
 	incubator.workerSaveChan <- incubator.topOrganism
 	saved := <-incubator.workerSaveResultChan
-	file.Write(saved)
+	return SavePopulationFile(filename, header, saved)
 }
 
-// Load loads a population from the specified filename
-func (incubator *Incubator) Load(filename string) {
+// Checkpoint saves the current population to a new checkpoint of a
+// population file. The checkpoint is verified by loading and scoring it
+// again, and the oldest checkpoints are deleted to keep
+// Config.CheckpointCount checkpoints.
+func (incubator *Incubator) Checkpoint(populationFile string) error {
+	callback := make(chan error)
+	request := &SaveRequest{
+		Filename: populationFile,
+		Callback: callback,
+	}
+	incubator.checkpointChan <- request
+	return <-callback
+}
+
+func (incubator *Incubator) checkpoint(populationFile string) error {
+	filename := CheckpointFilename(populationFile, incubator.Iteration)
+	err := incubator.save(filename)
+	if err != nil {
+		return err
+	}
+	_, organism, err := incubator.loadOrganism(filename)
+	if err != nil {
+		os.Remove(filename)
+		return fmt.Errorf("Checkpoint '%v' failed verification: %v", filename, err.Error())
+	}
+	incubator.disposeOrganism(organism)
+	return RotateCheckpoints(populationFile, incubator.config.CheckpointCount)
+}
+
+// Load loads a population from the specified filename. If the file is
+// corrupt, an error is returned and the current population is kept.
+func (incubator *Incubator) Load(filename string) error {
 	callback := make(chan error)
 	request := &LoadRequest{
 		Filename: filename,
 		Callback: callback,
 	}
 	incubator.loadChan <- request
-	<-callback
+	return <-callback
 }
 
-func (incubator *Incubator) load(filename string) {
+func (incubator *Incubator) load(filename string) error {
+	header, organism, err := incubator.loadOrganism(filename)
+	if err != nil {
+		return err
+	}
+	if header.TargetHash != "" && header.TargetHash != incubator.targetHash {
+		log.Printf("Warning: population file '%v' was evolved against a different target image", filename)
+	}
+	if header.HasDimensions() && !incubator.target.Bounds().Size().Eq(image.Pt(header.Width, header.Height)) {
+		log.Printf("Warning: population file '%v' has dimensions %vx%v, target image is %vx%v",
+			filename, header.Width, header.Height, incubator.target.Bounds().Size().X, incubator.target.Bounds().Size().Y)
+	}
 	incubator.organismRecord = map[string]bool{}
-	data, err := ioutil.ReadFile(filename)
+	incubator.organismRecord[organism.Hash()] = true
+	incubator.Iteration = header.Iteration
+	if incubator.topOrganism != nil {
+		incubator.disposeOrganism(incubator.topOrganism)
+	}
+	incubator.topOrganism = organism
+	incubator.writeJournalSnapshot()
+	return nil
+}
+
+// populationDiffTolerance is how much worse a loaded population may score
+// than the diff recorded in its file, before the file is considered corrupt.
+const populationDiffTolerance = 0.05
+
+// loadOrganism loads and scores the top organism of a population file. An
+// error is returned if the file can't be loaded, or if the organism scores
+// worse than the diff recorded in the file.
+func (incubator *Incubator) loadOrganism(filename string) (*PopulationHeader, *Organism, error) {
+	header, data, err := ReadPopulationFile(filename)
 	if err != nil {
-		log.Fatalf("Error loading incubator: %v", err.Error())
-	}
-	lines := bytes.Split(data, []byte("\n"))
-	header := string(bytes.TrimSpace(lines[0]))
-	iteration, _ := strconv.ParseInt(header, 10, 32)
-	incubator.Iteration = int(iteration)
-	// TODO: refactor this for a single organism
-	incubator.workerLoadChan <- lines[1]
+		return nil, nil, err
+	}
+	incubator.workerLoadChan <- data
 	organism := <-incubator.workerLoadResultChan
 	if organism == nil {
-		panic("Loaded nil organism from file")
+		return nil, nil, fmt.Errorf("Error loading organism from '%v'", filename)
+	}
+	if header.InstructionCount > 0 && len(organism.Instructions) != header.InstructionCount {
+		incubator.disposeOrganism(organism)
+		return nil, nil, fmt.Errorf("Population file '%v' has %v instructions, but %v were recorded", filename, len(organism.Instructions), header.InstructionCount)
 	}
-	organism.Diff = -1
 	organism.CleanupInstructions()
-	incubator.topOrganism = organism
-	// add for scoring
-	incubator.addOrganism(organism)
+	incubator.scoreOrganism(organism)
+	// The recorded diff can only be checked if it was scored the same way
+	headerConfig := header.Config
+	if headerConfig == nil {
+		headerConfig = DefaultConfig()
+	}
+	if header.Diff > 0 && header.TargetHash == incubator.targetHash && headerConfig.SameFitness(incubator.config) &&
+		organism.Diff > header.Diff+populationDiffTolerance {
+		incubator.disposeOrganism(organism)
+		return nil, nil, fmt.Errorf("Population file '%v' scored %v, but %v was recorded", filename, organism.Diff, header.Diff)
+	}
+	return header, organism, nil
+}
 
+// scoreOrganism scores a single organism, outside of the current generation
+func (incubator *Incubator) scoreOrganism(organism *Organism) {
+	incubator.currentGeneration = append(incubator.currentGeneration, organism)
+	incubator.currentGenerationMap[organism.Hash()] = organism
 	incubator.scorePopulation()
 	incubator.clearCurrentGeneration()
 }
@@ -369,15 +469,25 @@ func (incubator *Incubator) growPopulation() {
 	if incubator.topOrganism == nil {
 		incubator.topOrganism = incubator.createRandomOrganism()
 	}
+	incubator.mutator.Prepare(incubator.topOrganism)
 
 	for len(incubator.currentGeneration) < incubator.config.MaxPopulation {
 		for i := len(incubator.currentGeneration); i < incubator.config.MaxPopulation; i++ {
 			incubator.workerCloneChan <- incubator.topOrganism
 		}
+		refining := 0
 		for i := len(incubator.currentGeneration); i < incubator.config.MaxPopulation; i++ {
 			organism := <-incubator.workerCloneResultChan
 			incubator.applyMutations(organism)
-			incubator.addOrganism(organism)
+			if incubator.config.RefineRounds > 0 {
+				incubator.workerRefineChan <- organism
+				refining++
+			} else {
+				incubator.addOrganism(organism)
+			}
+		}
+		for ; refining > 0; refining-- {
+			incubator.addOrganism(<-incubator.workerRefineResultChan)
 		}
 	}
 	// Purge organismRecord occasionally
@@ -404,6 +514,7 @@ func (incubator *Incubator) applyMutations(organism *Organism) {
 
 func (incubator *Incubator) createRandomOrganism() *Organism {
 	organism := objectPool.BorrowOrganism()
+	organism.Background = MeanColor(incubator.target)
 	numInstructions := int(rand.Int31n(int32(incubator.config.MaxComplexity-incubator.config.MinComplexity)) + int32(incubator.config.MinComplexity))
 	for i := 0; i < numInstructions; i++ {
 		organism.Instructions = append(organism.Instructions, incubator.mutator.RandomInstruction())
@@ -440,15 +551,39 @@ func (incubator *Incubator) submitPatch(patch *Patch) {
 }
 
 func (incubator *Incubator) setTopOrganism(organism *Organism, requireScoring bool) {
+	baseline := ""
 	if incubator.topOrganism != nil {
+		baseline = incubator.topOrganism.Hash()
 		objectPool.ReturnOrganism(incubator.topOrganism)
 	}
 	incubator.topOrganism = organism
 	if requireScoring {
-		incubator.currentGeneration = append(incubator.currentGeneration, organism)
-		incubator.currentGenerationMap[organism.Hash()] = organism
-		incubator.scorePopulation()
-		incubator.clearCurrentGeneration()
+		incubator.scoreOrganism(organism)
+	}
+	if organism.Patch != nil && organism.Patch.Baseline == baseline {
+		incubator.writeJournalPatch(organism.Patch)
+	} else {
+		incubator.writeJournalSnapshot()
+	}
+}
+
+func (incubator *Incubator) writeJournalPatch(patch *Patch) {
+	if incubator.journal == nil {
+		return
+	}
+	err := incubator.journal.WritePatch(incubator.Iteration, incubator.topOrganism.Diff, patch)
+	if err != nil {
+		log.Printf("Error writing to journal: %v", err.Error())
+	}
+}
+
+func (incubator *Incubator) writeJournalSnapshot() {
+	if incubator.journal == nil {
+		return
+	}
+	err := incubator.journal.WriteSnapshot(incubator.Iteration, incubator.topOrganism, incubator.targetHash, incubator.config)
+	if err != nil {
+		log.Printf("Error writing to journal: %v", err.Error())
 	}
 }
 
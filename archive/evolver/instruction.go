@@ -1,6 +1,8 @@
 package main
 
 import (
+	"image/color"
+
 	"github.com/fogleman/gg"
 )
 
@@ -11,10 +13,22 @@ type Instruction interface {
 	Execute(ctx *gg.Context)
 	Save() []byte
 	Load([]byte)
+	// SaveBinary and LoadBinary use the compact binary encoding
+	SaveBinary(encoder *BinaryEncoder)
+	LoadBinary(decoder *BinaryDecoder)
 	Clone() Instruction
 	Hash() string
 	Scale(factor float32) Instruction
 	Bounds() Rect
+	// SVG returns an svg element that draws the same thing as Execute
+	SVG() string
+}
+
+// A ColoredInstruction is drawn in a single color
+type ColoredInstruction interface {
+	Instruction
+	GetColor() *color.NRGBA
+	SetColor(clr *color.NRGBA)
 }
 
 // InstructionList provides convenience methods for instruction lists
@@ -32,10 +46,12 @@ func (lst InstructionList) Insert(i int, item Instruction) []Instruction {
 	return append(a[:i], append([]Instruction{item}, a[i:]...)...)
 }
 
-// LoadInstruction will load a previously saved Instruction
-// TODO: engineer a more plugin-friendly way of hydrating instructions
-func LoadInstruction(instructionType string, data []byte) Instruction {
-	instruction := objectPool.BorrowInstruction(instructionType)
-	instruction.Load(data)
-	return instruction
+// LoadInstruction will load a previously saved Instruction using
+// the loader of its registered instruction type.
+func LoadInstruction(instructionType string, data []byte) (Instruction, error) {
+	registration, err := GetInstructionType(instructionType)
+	if err != nil {
+		return nil, err
+	}
+	return registration.Load(data), nil
 }
@@ -3,5 +3,7 @@ package main
 type InstructionMutator interface {
 	MutateInstruction(instruction Instruction)
 	RandomInstruction() Instruction
+	// RandomInstructionAt returns a new random instruction centered at a position
+	RandomInstructionAt(x float32, y float32) Instruction
 	InstructionType() string
 }
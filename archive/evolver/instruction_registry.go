@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	pool "github.com/jolestar/go-commons-pool"
+)
+
+// An InstructionType describes everything needed to support a type of
+// Instruction. Each instruction type registers itself with
+// RegisterInstructionType, and the rest of the program looks it up by name.
+type InstructionType struct {
+	// Name is the type name, as returned by Instruction.Type()
+	Name string
+	// NewFactory creates a factory to pool instructions of this type
+	NewFactory func() pool.PooledObjectFactory
+	// NewMutator creates an InstructionMutator for this type
+	NewMutator func(config *Config, imageWidth float32, imageHeight float32) InstructionMutator
+	// Load hydrates a previously saved instruction of this type
+	Load func(data []byte) Instruction
+}
+
+var instructionTypes = map[string]*InstructionType{}
+
+// RegisterInstructionType adds an instruction type to the registry
+func RegisterInstructionType(instructionType *InstructionType) {
+	if _, has := instructionTypes[instructionType.Name]; has {
+		panic(fmt.Sprintf("Instruction type '%v' is already registered", instructionType.Name))
+	}
+	instructionTypes[instructionType.Name] = instructionType
+}
+
+// GetInstructionType looks up a registered instruction type by name
+func GetInstructionType(name string) (*InstructionType, error) {
+	instructionType, has := instructionTypes[name]
+	if !has {
+		return nil, fmt.Errorf("Unknown instruction type '%v', valid types are: %v", name, strings.Join(InstructionTypeNames(), ", "))
+	}
+	return instructionType, nil
+}
+
+// InstructionTypeNames returns the sorted names of all registered instruction types
+func InstructionTypeNames() []string {
+	names := make([]string, 0, len(instructionTypes))
+	for name := range instructionTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadPooledInstruction borrows an instruction of the specified type from
+// the object pool and loads the saved data into it.
+func loadPooledInstruction(instructionType string, data []byte) Instruction {
+	instruction := objectPool.BorrowInstruction(instructionType)
+	instruction.Load(data)
+	return instruction
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// A JournalEntry records a change of the top organism. Most entries hold the
+// patch that was applied to the previous top organism. Snapshot entries hold
+// the whole organism instead, and are written whenever the top organism can't
+// be reached with a patch (on startup, or when it's replaced by a worker).
+type JournalEntry struct {
+	Iteration int
+	Diff      float32
+	Time      time.Time
+	Patch     *Patch `json:",omitempty"`
+	// Organism is a snapshot of the top organism, saved with Organism.Save
+	Organism string `json:",omitempty"`
+	// Width and Height are the dimensions of the canvas, set on snapshots
+	Width  int `json:",omitempty"`
+	Height int `json:",omitempty"`
+	// TargetHash identifies the target image, set on snapshots
+	TargetHash string `json:",omitempty"`
+	// Config is the config that the diffs of the snapshot and the entries
+	// after it were calculated with, set on snapshots
+	Config *Config `json:",omitempty"`
+}
+
+// IsSnapshot returns true if the entry holds a whole organism
+func (entry *JournalEntry) IsSnapshot() bool {
+	return entry.Organism != ""
+}
+
+// A PatchJournal is an append-only file of JournalEntries, one json
+// document per line. It allows the evolution of a population to be
+// replayed long after the run.
+type PatchJournal struct {
+	file *os.File
+}
+
+// OpenPatchJournal opens a journal file for appending, creating it if needed
+func OpenPatchJournal(filename string) (*PatchJournal, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &PatchJournal{file: file}, nil
+}
+
+// WritePatch records a patch that was applied to the top organism
+func (journal *PatchJournal) WritePatch(iteration int, diff float32, patch *Patch) error {
+	return journal.write(&JournalEntry{
+		Iteration: iteration,
+		Diff:      diff,
+		Time:      time.Now(),
+		Patch:     patch,
+	})
+}
+
+// WriteSnapshot records the whole top organism, and the config that its diff
+// was calculated with
+func (journal *PatchJournal) WriteSnapshot(iteration int, organism *Organism, targetHash string, config *Config) error {
+	bounds := organism.CanvasBounds()
+	return journal.write(&JournalEntry{
+		Iteration:  iteration,
+		Diff:       organism.Diff,
+		Time:       time.Now(),
+		Organism:   string(organism.Save()),
+		Width:      int(bounds.Right),
+		Height:     int(bounds.Bottom),
+		TargetHash: targetHash,
+		Config:     config,
+	})
+}
+
+func (journal *PatchJournal) write(entry *JournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = journal.file.Write(data)
+	return err
+}
+
+// Close closes the journal file
+func (journal *PatchJournal) Close() error {
+	return journal.file.Close()
+}
+
+// A JournalReader reads the entries of a journal file in order, and
+// replays them on a copy of the top organism.
+type JournalReader struct {
+	file       *os.File
+	reader     *bufio.Reader
+	lineNumber int
+	organism   *Organism
+	// Mismatches counts the patches that didn't produce the recorded organism
+	Mismatches int
+}
+
+// OpenJournalReader opens a journal file for reading
+func OpenJournalReader(filename string) (*JournalReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &JournalReader{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+// Next reads the next entry. io.EOF is returned at the end of the journal.
+func (journalReader *JournalReader) Next() (*JournalEntry, error) {
+	for {
+		line, err := journalReader.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		journalReader.lineNumber++
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			entry := &JournalEntry{}
+			jsonErr := json.Unmarshal(line, entry)
+			if jsonErr == nil {
+				return entry, nil
+			}
+			// The last line can be partially written if the server was killed
+			if err != io.EOF {
+				return nil, fmt.Errorf("Invalid journal entry on line %v: %v", journalReader.lineNumber, jsonErr.Error())
+			}
+		}
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+	}
+}
+
+// Apply applies an entry to the replayed organism. Organisms are borrowed
+// from the object pool, so the canvas size must be set before the first
+// snapshot is applied.
+func (journalReader *JournalReader) Apply(entry *JournalEntry) error {
+	if entry.IsSnapshot() {
+		organism := objectPool.BorrowOrganism()
+		err := organism.Load([]byte(entry.Organism))
+		if err != nil {
+			objectPool.ReturnOrganism(organism)
+			return fmt.Errorf("Error loading snapshot on line %v: %v", journalReader.lineNumber, err.Error())
+		}
+		if journalReader.organism != nil {
+			objectPool.ReturnOrganism(journalReader.organism)
+		}
+		journalReader.organism = organism
+	} else if entry.Patch != nil {
+		organism := journalReader.organism
+		if organism == nil {
+			return fmt.Errorf("Journal entry on line %v has no snapshot to apply to", journalReader.lineNumber)
+		}
+		for _, operation := range entry.Patch.Operations {
+			operation.Apply(organism)
+		}
+		organism.CleanupInstructions()
+		organism.hash = ""
+		if organism.Hash() != entry.Patch.Target {
+			journalReader.Mismatches++
+		}
+	}
+	if journalReader.organism != nil {
+		journalReader.organism.Diff = entry.Diff
+	}
+	return nil
+}
+
+// Organism returns the replayed organism. It is nil until the first
+// snapshot has been applied.
+func (journalReader *JournalReader) Organism() *Organism {
+	return journalReader.organism
+}
+
+// Close closes the journal file
+func (journalReader *JournalReader) Close() error {
+	return journalReader.file.Close()
+}
+
+// ReplayJournal rebuilds the top organism from a journal file. accept is
+// called with each entry before it is applied, and replay stops at the first
+// entry that isn't accepted. The canvas size must be set before the first
+// snapshot is applied (accept can take care of this). The organism and the
+// last applied entry are returned.
+func ReplayJournal(filename string, accept func(entry *JournalEntry) bool) (*Organism, *JournalEntry, error) {
+	journalReader, err := OpenJournalReader(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer journalReader.Close()
+	var last *JournalEntry
+	for {
+		entry, err := journalReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if !accept(entry) {
+			break
+		}
+		err = journalReader.Apply(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		last = entry
+	}
+	organism := journalReader.Organism()
+	if organism == nil {
+		return nil, nil, fmt.Errorf("No snapshots found in journal '%v'", filename)
+	}
+	if journalReader.Mismatches > 0 {
+		return organism, last, &JournalMismatchError{Mismatches: journalReader.Mismatches}
+	}
+	return organism, last, nil
+}
+
+// A JournalSummary describes the range of a journal
+type JournalSummary struct {
+	FirstIteration int
+	LastIteration  int
+	Entries        int
+	// Width and Height are the canvas dimensions of the first snapshot
+	Width  int
+	Height int
+}
+
+// SummarizeJournal reads through a journal file to find its range
+func SummarizeJournal(filename string) (*JournalSummary, error) {
+	journalReader, err := OpenJournalReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer journalReader.Close()
+	summary := &JournalSummary{}
+	for {
+		entry, err := journalReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if summary.Entries == 0 {
+			summary.FirstIteration = entry.Iteration
+		}
+		if entry.Iteration > summary.LastIteration {
+			summary.LastIteration = entry.Iteration
+		}
+		if entry.IsSnapshot() && summary.Width == 0 {
+			summary.Width, summary.Height = entry.Width, entry.Height
+		}
+		summary.Entries++
+	}
+	if summary.Width == 0 {
+		return nil, fmt.Errorf("No snapshots found in journal '%v'", filename)
+	}
+	return summary, nil
+}
+
+// A JournalMismatchError is returned by ReplayJournal if some patches didn't
+// produce the recorded organism. The replayed organism is still usable, but
+// it may differ slightly from the original.
+type JournalMismatchError struct {
+	Mismatches int
+}
+
+func (err *JournalMismatchError) Error() string {
+	return fmt.Sprintf("%v journal entries did not match the recorded organism", err.Mismatches)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestJournal writes a snapshot of the test organism, followed by a
+// patch that appends a circle and a patch that changes the background.
+// The organism that the journal replays to is returned.
+func writeTestJournal(t *testing.T, filename string) *Organism {
+	t.Helper()
+	setCanvasSize(40, 30)
+	journal, err := OpenPatchJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	organism := testOrganism()
+	baseline := organism.Hash()
+	err = journal.WriteSnapshot(10, organism, "targethash", DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	circle := &Circle{X: 5, Y: 5, Radius: 3, Color: testOrganism().Background}
+	organism.Instructions = append(organism.Instructions, circle)
+	organism.hash = ""
+	appended := &Patch{
+		Baseline: baseline,
+		Target:   organism.Hash(),
+		Operations: []PatchOperation{
+			{OperationType: PatchOperationAppend, InstructionType: circle.Type(), InstructionData: circle.Save()},
+		},
+	}
+	err = journal.WritePatch(11, 0.5, appended)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	organism.Background = LoadColorHex("#405060")
+	organism.hash = ""
+	background := &Patch{
+		Baseline: appended.Target,
+		Target:   organism.Hash(),
+		Operations: []PatchOperation{
+			{OperationType: PatchOperationBackground, Background: "#405060"},
+		},
+	}
+	err = journal.WritePatch(15, 0.25, background)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return organism
+}
+
+func TestReplayJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "target.png.journal")
+	expected := writeTestJournal(t, filename)
+
+	organism, last, err := ReplayJournal(filename, func(entry *JournalEntry) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if organism.Hash() != expected.Hash() {
+		t.Errorf("Expected hash %v, got %v", expected.Hash(), organism.Hash())
+	}
+	if last.Iteration != 15 || organism.Diff != 0.25 {
+		t.Errorf("Expected iteration 15 with diff 0.25, got iteration %v with diff %v", last.Iteration, organism.Diff)
+	}
+}
+
+func TestReplayJournalStopsAtUnacceptedEntry(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "target.png.journal")
+	writeTestJournal(t, filename)
+
+	organism, last, err := ReplayJournal(filename, func(entry *JournalEntry) bool { return entry.Iteration <= 12 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Iteration != 11 || len(organism.Instructions) != len(testOrganism().Instructions)+1 {
+		t.Errorf("Expected iteration 11 with one appended instruction, got iteration %v with %v instructions", last.Iteration, len(organism.Instructions))
+	}
+	if SaveColorHex(organism.Background) != SaveColorHex(testOrganism().Background) {
+		t.Errorf("Expected the background of iteration 15 not to be applied, got %v", SaveColorHex(organism.Background))
+	}
+}
+
+func TestReplayJournalWithPartialLastLine(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "target.png.journal")
+	expected := writeTestJournal(t, filename)
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"Iteration":16,"Diff":0.1,"Patch":{"ope`)
+	file.Close()
+
+	organism, last, err := ReplayJournal(filename, func(entry *JournalEntry) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Iteration != 15 || organism.Hash() != expected.Hash() {
+		t.Errorf("Expected the organism of iteration 15, got iteration %v", last.Iteration)
+	}
+}
+
+func TestReplayJournalCountsMismatches(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "target.png.journal")
+	writeTestJournal(t, filename)
+	journal, err := OpenPatchJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = journal.WritePatch(20, 0.2, &Patch{
+		Target:     "wrong",
+		Operations: []PatchOperation{{OperationType: PatchOperationBackground, Background: "#000000"}},
+	})
+	journal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	organism, _, err := ReplayJournal(filename, func(entry *JournalEntry) bool { return true })
+	mismatchErr, ok := err.(*JournalMismatchError)
+	if !ok || mismatchErr.Mismatches != 1 {
+		t.Fatalf("Expected one mismatch, got %v", err)
+	}
+	if organism == nil {
+		t.Error("Expected the replayed organism to be returned with the mismatch")
+	}
+}
+
+func TestSummarizeJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "target.png.journal")
+	writeTestJournal(t, filename)
+
+	summary, err := SummarizeJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := JournalSummary{FirstIteration: 10, LastIteration: 15, Entries: 3, Width: 40, Height: 30}
+	if *summary != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *summary)
+	}
+}
+
+func TestJournalSnapshotRecordsConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "target.png.journal")
+	setCanvasSize(40, 30)
+	journal, err := OpenPatchJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotConfig := DefaultConfig()
+	snapshotConfig.FitnessMetric = FitnessMetricPyramid
+	snapshotConfig.FocusFitnessWeight = 2
+	err = journal.WriteSnapshot(1, testOrganism(), "targethash", snapshotConfig)
+	journal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journalReader, err := OpenJournalReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journalReader.Close()
+	entry, err := journalReader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Config == nil || !entry.Config.SameFitness(snapshotConfig) {
+		t.Errorf("Expected the snapshot to record the fitness config, got %+v", entry.Config)
+	}
+}
@@ -2,18 +2,33 @@ package main
 
 import (
 	"crypto/md5"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"image/color"
 	"math"
 
 	"github.com/fogleman/gg"
+	pool "github.com/jolestar/go-commons-pool"
 )
 
 // TypeLine is a constant describing the "line" type
 const TypeLine = "line"
 
+func init() {
+	RegisterInstructionType(&InstructionType{
+		Name: TypeLine,
+		NewFactory: func() pool.PooledObjectFactory {
+			return NewLineFactory()
+		},
+		NewMutator: func(config *Config, imageWidth float32, imageHeight float32) InstructionMutator {
+			return NewLineMutator(config, imageWidth, imageHeight)
+		},
+		Load: func(data []byte) Instruction {
+			return loadPooledInstruction(TypeLine, data)
+		},
+	})
+}
+
 // Line represents an instruction that draws a line between two points
 type Line struct {
 	StartX     float32
@@ -21,9 +36,11 @@ type Line struct {
 	EndX       float32
 	EndY       float32
 	Width      float32
-	Color      color.Color `json:"-"`
-	SavedColor *SavedColor
+	Color      *color.NRGBA `json:"-"`
+	SavedColor *SavedColor  `json:",omitempty"`
+	HexColor   string       `json:",omitempty"`
 	hash       string
+	bounds     Rect // Cache bounds
 }
 
 // Execute draws a line between two points
@@ -34,19 +51,22 @@ func (line *Line) Execute(ctx *gg.Context) {
 	ctx.Stroke()
 }
 
+// Scale returns a scaled copy of the line
 func (line *Line) Scale(factor float32) Instruction {
 	clone := line.Clone().(*Line)
-	line.StartX *= factor
-	line.StartY *= factor
-	line.EndX *= factor
-	line.EndY *= factor
-	line.Width *= factor
+	clone.StartX *= factor
+	clone.StartY *= factor
+	clone.EndX *= factor
+	clone.EndY *= factor
+	clone.Width *= factor
+	clone.bounds = Rect{}
+	clone.hash = ""
 	return clone
 }
 
 // Save saves the line to a persisted form
 func (line *Line) Save() []byte {
-	line.SavedColor = SaveColor(line.Color)
+	line.HexColor = SaveColorHex(line.Color)
 	data, _ := json.Marshal(line)
 	return data
 }
@@ -54,7 +74,33 @@ func (line *Line) Save() []byte {
 // Load loads the line from a persisted form
 func (line *Line) Load(data []byte) {
 	json.Unmarshal(data, line)
-	line.Color = LoadColor(line.SavedColor)
+	if line.SavedColor != nil {
+		line.Color = LoadColor(line.SavedColor)
+		// The color will be saved as hex from now on
+		line.SavedColor = nil
+	} else {
+		line.Color = LoadColorHex(line.HexColor)
+	}
+}
+
+// SaveBinary saves the line in the compact binary encoding
+func (line *Line) SaveBinary(encoder *BinaryEncoder) {
+	encoder.WriteColor(line.Color)
+	encoder.WriteFixed(line.StartX)
+	encoder.WriteFixed(line.StartY)
+	encoder.WriteFixed(line.EndX)
+	encoder.WriteFixed(line.EndY)
+	encoder.WriteFixed(line.Width)
+}
+
+// LoadBinary loads the line from the compact binary encoding
+func (line *Line) LoadBinary(decoder *BinaryDecoder) {
+	line.Color = decoder.ReadColor()
+	line.StartX = decoder.ReadFixed()
+	line.StartY = decoder.ReadFixed()
+	line.EndX = decoder.ReadFixed()
+	line.EndY = decoder.ReadFixed()
+	line.Width = decoder.ReadFixed()
 }
 
 // Type returns "line" type
@@ -64,33 +110,76 @@ func (line *Line) Type() string {
 
 // Clone returns a deep copy of the instruction
 func (line *Line) Clone() Instruction {
-	// Cheap deep copy!
-	newLine := *line
-	return &newLine
+	newLine := objectPool.BorrowInstruction(TypeLine).(*Line)
+	// cheap deep copy of color
+	newColor := *line.Color
+	newLine.Color = &newColor
+	newLine.HexColor = line.HexColor
+	newLine.StartX = line.StartX
+	newLine.StartY = line.StartY
+	newLine.EndX = line.EndX
+	newLine.EndY = line.EndY
+	newLine.Width = line.Width
+	newLine.hash = line.hash
+	newLine.bounds = line.bounds
+	return newLine
+}
+
+// SVG returns the line as an svg line element. Lines are drawn
+// with round caps, the same as gg.
+func (line *Line) SVG() string {
+	return fmt.Sprintf(
+		`<line x1="%v" y1="%v" x2="%v" y2="%v" stroke-width="%v" stroke-linecap="round" %v/>`,
+		svgNumber(line.StartX), svgNumber(line.StartY), svgNumber(line.EndX), svgNumber(line.EndY),
+		svgNumber(line.Width), svgPaint("stroke", line.Color),
+	)
 }
 
 // Hash returns a (probably) unique hash that represents this particular instruction
 func (line *Line) Hash() string {
 	if line.hash == "" {
-		r, g, b, _ := line.Color.RGBA()
-		value := fmt.Sprintf(
-			"%v%v%v%v%v%v%v%v", line.StartX, line.StartY, line.EndX, line.EndY, line.Width, r, g, b)
-		hasher := md5.New()
-		line.hash = base64.StdEncoding.EncodeToString(hasher.Sum([]byte(value)))
+		buf := objectPool.BorrowByteBuffer()
+		buf.WriteString(SaveColorHex(line.Color))
+		buf.WriteString(fmt.Sprintf("|%.4f|%.4f|%.4f|%.4f|%.4f", line.StartX, line.StartY, line.EndX, line.EndY, line.Width))
+		line.hash = fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
+		objectPool.ReturnByteBuffer(buf)
 	}
 	return line.hash
 }
 
-// Bounds returns the rectangular bounds of the line
+// RecalculateHash clears the cached hash and calculates it again
+func (line *Line) RecalculateHash() {
+	line.hash = ""
+	line.Hash()
+}
+
+// GetColor returns the color of the line
+func (line *Line) GetColor() *color.NRGBA {
+	return line.Color
+}
+
+// SetColor replaces the color of the line
+func (line *Line) SetColor(clr *color.NRGBA) {
+	line.Color = clr
+	line.hash = ""
+}
+
+// Bounds returns the rectangular bounds of the line, including
+// the width of the stroke.
 func (line *Line) Bounds() Rect {
-	left := math.Min(float64(line.StartX), float64(line.EndX))
-	right := math.Max(float64(line.StartX), float64(line.EndX))
-	top := math.Min(float64(line.StartY), float64(line.EndY))
-	bottom := math.Max(float64(line.StartY), float64(line.EndY))
-	return Rect{
+	if line.bounds != (Rect{}) {
+		return line.bounds
+	}
+	halfWidth := float64(line.Width) / 2.0
+	left := math.Min(float64(line.StartX), float64(line.EndX)) - halfWidth
+	right := math.Max(float64(line.StartX), float64(line.EndX)) + halfWidth
+	top := math.Min(float64(line.StartY), float64(line.EndY)) - halfWidth
+	bottom := math.Max(float64(line.StartY), float64(line.EndY)) + halfWidth
+	line.bounds = Rect{
 		Left:   float32(left),
 		Right:  float32(right),
 		Top:    float32(top),
 		Bottom: float32(bottom),
 	}
+	return line.bounds
 }
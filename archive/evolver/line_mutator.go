@@ -1,9 +1,10 @@
 package main
 
 import (
-	"image/color"
+	"fmt"
 	"math"
 	"math/rand"
+	"strconv"
 
 	"github.com/lucasb-eyer/go-colorful"
 )
@@ -76,6 +77,8 @@ func (mut *LineMutator) MutateInstruction(instruction Instruction) {
 	default:
 		mut.mutateLineWidth(line)
 	}
+	line.bounds = Rect{}
+	line.RecalculateHash()
 }
 
 func (mut *LineMutator) InstructionType() string {
@@ -87,32 +90,44 @@ func (mut *LineMutator) InstructionType() string {
 // Red, Green, Blue
 
 func (mut *LineMutator) mutateColor(line *Line) {
-	switch rand.Int31n(3) {
+	// Opacity is only mutated if a range of opacities is allowed
+	numColorMutations := int32(3)
+	if mut.config.MaxOpacity > mut.config.MinOpacity {
+		numColorMutations = 4
+	}
+	switch rand.Int31n(numColorMutations) {
 	case 0:
 		mut.mutateHue(line)
 	case 1:
 		mut.mutateSaturation(line)
-	default:
+	case 2:
 		mut.mutateLightness(line)
+	default:
+		mut.mutateOpacity(line)
 	}
 }
 
 func (mut *LineMutator) mutateHue(line *Line) {
 	hue, sat, lightness := MakeColor(line.Color).Hsl()
 	newHue := mut.mutateValue(0, 360, mut.config.MinHueMutation, mut.config.MaxHueMutation, float32(hue))
-	line.Color = colorful.Hsl(float64(newHue), sat, lightness)
+	line.Color = WithAlpha(colorful.Hsl(float64(newHue), sat, lightness), line.Color.A)
 }
 
 func (mut *LineMutator) mutateSaturation(line *Line) {
 	hue, sat, lightness := MakeColor(line.Color).Hsl()
 	newSat := mut.mutateValue(0, 1, mut.config.MinSaturationMutation, mut.config.MaxSaturationMutation, float32(sat))
-	line.Color = colorful.Hsl(hue, float64(newSat), lightness)
+	line.Color = WithAlpha(colorful.Hsl(hue, float64(newSat), lightness), line.Color.A)
+}
+
+func (mut *LineMutator) mutateOpacity(line *Line) {
+	opacity := mut.mutateValue(mut.config.MinOpacity, mut.config.MaxOpacity, mut.config.MinOpacityMutation, mut.config.MaxOpacityMutation, float32(line.Color.A)/255.0)
+	line.Color.A = uint8(opacity * 255)
 }
 
 func (mut *LineMutator) mutateLightness(line *Line) {
 	hue, sat, lightness := MakeColor(line.Color).Hsl()
 	newLightness := mut.mutateValue(0, 1, mut.config.MinValueMutation, mut.config.MaxValueMutation, float32(lightness))
-	line.Color = colorful.Hsl(hue, sat, float64(newLightness))
+	line.Color = WithAlpha(colorful.Hsl(hue, sat, float64(newLightness)), line.Color.A)
 }
 
 // Mutate Brush Size
@@ -145,6 +160,10 @@ func (mut *LineMutator) mutateEnd(line *Line) {
 }
 
 func (mut *LineMutator) RandomInstruction() Instruction {
+	return mut.RandomInstructionAt(rand.Float32()*mut.imageWidth, rand.Float32()*mut.imageHeight)
+}
+
+func (mut *LineMutator) RandomInstructionAt(x float32, y float32) Instruction {
 	// Favor shorter lines
 	lineLength := rand.Float32()*(mut.config.MaxLineLength-2) + 2.0
 	lineWidth := rand.Float32()*(mut.config.MaxLineWidth-1) + 1
@@ -153,23 +172,19 @@ func (mut *LineMutator) RandomInstruction() Instruction {
 		lineWidth *= 0.95
 	}
 	angle := rand.Float32() * math.Pi * 2.0
-	startX := rand.Float32() * mut.imageWidth
-	startY := rand.Float32() * mut.imageHeight
-	endY := float32(math.Sin(float64(angle)))*lineLength + startY
-	endX := float32(math.Cos(float64(angle)))*lineLength + startX
-	return &Line{
-		StartX: startX,
-		StartY: startY,
-		EndX:   endX,
-		EndY:   endY,
-		Color: &color.RGBA{
-			A: 255,
-			G: uint8(rand.Int31n(255)),
-			B: uint8(rand.Int31n(255)),
-			R: uint8(rand.Int31n(255)),
-		},
-		Width: lineWidth,
-	}
+	// The line is centered at the position
+	dx := float32(math.Cos(float64(angle))) * lineLength / 2
+	dy := float32(math.Sin(float64(angle))) * lineLength / 2
+	startX, startY := x-dx, y-dy
+	endX, endY := x+dx, y+dy
+	line := objectPool.BorrowInstruction(TypeLine).(*Line)
+	line.StartX = mut.trunc(startX)
+	line.StartY = mut.trunc(startY)
+	line.EndX = mut.trunc(endX)
+	line.EndY = mut.trunc(endY)
+	line.Width = mut.trunc(lineWidth)
+	line.Color = RandomColor(mut.config.MinOpacity, mut.config.MaxOpacity)
+	return line
 }
 
 func (mut *LineMutator) mutateValue(min float32, max float32, minDelta float32, maxDelta float32, value float32) float32 {
@@ -182,5 +197,10 @@ func (mut *LineMutator) mutateValue(min float32, max float32, minDelta float32,
 	for value > max {
 		value = value - (max - min)
 	}
-	return value
+	return mut.trunc(value)
+}
+
+func (mut *LineMutator) trunc(value float32) float32 {
+	v, _ := strconv.ParseFloat(fmt.Sprintf("%.4f", value), 32)
+	return float32(v)
 }
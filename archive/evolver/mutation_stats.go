@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of mutations, for mutation statistics
+const (
+	MutationInsertRandomColor  = "insert (random color)"
+	MutationInsertTargetColor  = "insert (target color)"
+	MutationInsertOptimalColor = "insert (optimal color)"
+	MutationDuplicate          = "duplicate"
+	MutationDelete             = "delete"
+	MutationMutate             = "mutate"
+	MutationSwap               = "swap"
+	MutationBackground         = "background"
+)
+
+// MutationStats counts how often each kind of mutation is tried, and how
+// often it improves the top organism
+type MutationStats struct {
+	Attempts     map[string]int
+	Improvements map[string]int
+}
+
+// NewMutationStats returns empty MutationStats
+func NewMutationStats() *MutationStats {
+	return &MutationStats{
+		Attempts:     map[string]int{},
+		Improvements: map[string]int{},
+	}
+}
+
+// Record counts an attempt of a kind of mutation. Organisms that weren't
+// created by a single mutation have no kind and aren't counted.
+func (stats *MutationStats) Record(mutation string, improved bool) {
+	if mutation == "" {
+		return
+	}
+	stats.Attempts[mutation]++
+	if improved {
+		stats.Improvements[mutation]++
+	}
+}
+
+// String returns the acceptance rate of each kind of mutation
+func (stats *MutationStats) String() string {
+	mutations := make([]string, 0, len(stats.Attempts))
+	for mutation := range stats.Attempts {
+		mutations = append(mutations, mutation)
+	}
+	sort.Strings(mutations)
+	lines := make([]string, 0, len(mutations))
+	for _, mutation := range mutations {
+		attempts, improvements := stats.Attempts[mutation], stats.Improvements[mutation]
+		lines = append(lines, fmt.Sprintf("%v: %v/%v accepted (%.3f%%)",
+			mutation, improvements, attempts, float64(improvements)/float64(attempts)*100))
+	}
+	return strings.Join(lines, ", ")
+}
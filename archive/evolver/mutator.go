@@ -2,36 +2,73 @@ package main
 
 import (
 	"image"
+	"image/color"
 	"log"
 	"math/rand"
+	"sort"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+const (
+	// MutationImportanceFocus places mutations by the focus map
+	MutationImportanceFocus = "focus"
+	// MutationImportanceError places mutations by the per-pixel error of the
+	// top organism
+	MutationImportanceError = "error"
+	// MutationImportanceUniform places mutations anywhere
+	MutationImportanceUniform = "uniform"
+)
+
+const (
+	// PlacementImportance places new instructions by MutationImportance
+	PlacementImportance = "importance"
+	// PlacementError centers new instructions on pixels with a high error in
+	// the top organism
+	PlacementError = "error"
 )
 
+// minInstructionImportance is the importance that every instruction has
+// (relative to the average pixel), so that none are left out completely
+const minInstructionImportance = 0.01
+
 // A Mutator provides a way to alter organisms in an attempt to improve them.
 type Mutator struct {
+	config                *Config
 	instructionMutatorMap map[string]InstructionMutator
 	instructionMutators   []InstructionMutator
-	focusMap              image.Image
-	maxFocusValue         int
+	// importanceMap samples positions for mutations. If nil, positions are
+	// uniformly random.
+	importanceMap *ImportanceMap
+	// instructionWeights are the cumulative importance of the instructions of
+	// the prepared organism
+	instructionWeights []float64
+	// errorMap samples positions by the per-pixel error of the prepared
+	// organism, for instruction types with error placement
+	errorMap *ImportanceMap
+	// targetColors colors new instructions if TargetColorRate is set
+	targetColors *TargetColors
+	// colorSolver recalculates the colors of new and moved instructions if
+	// OptimalColors is set
+	colorSolver  *ColorSolver
+	preparedHash string
+	preparedDiff float32
 }
 
 // NewMutator returns a new Mutator
 // focusMap is an optional arg, if provided the mutator will apply focus
 // to certain areas with higher value.
-func NewMutator(instructionMutators []InstructionMutator, focusMap image.Image) *Mutator {
+func NewMutator(config *Config, instructionMutators []InstructionMutator, target image.Image, focusMap image.Image) *Mutator {
 	mut := new(Mutator)
-	mut.focusMap = focusMap
-	if focusMap != nil {
-		// scan for the largest value in the map
-		for x := 0; x < focusMap.Bounds().Size().X; x++ {
-			for y := 0; y < focusMap.Bounds().Size().Y; y++ {
-				pixel := focusMap.At(x, y)
-				r, _, _, _ := pixel.RGBA()
-				value := int(r)
-				if value > mut.maxFocusValue {
-					mut.maxFocusValue = value
-				}
-			}
-		}
+	mut.config = config
+	if config.TargetColorRate > 0 {
+		mut.targetColors = NewTargetColors(target)
+	}
+	if config.OptimalColors {
+		mut.colorSolver = NewColorSolver(target, config.OptimalColorStep)
+	}
+	if focusMap != nil && config.MutationImportance == MutationImportanceFocus {
+		mut.importanceMap = NewFocusImportanceMap(focusMap)
 	}
 	mut.instructionMutatorMap = map[string]InstructionMutator{}
 	for _, instructionMut := range instructionMutators {
@@ -62,6 +99,39 @@ func (mut *Mutator) Optimize(organism *Organism) <-chan PatchOperation {
 	return stream
 }
 
+// Prepare updates the importance of positions and instructions for the
+// mutations of an organism, normally the top organism. Mutated organisms must
+// be clones of the prepared organism. Nothing is done if the organism hasn't
+// changed since the last call.
+func (mut *Mutator) Prepare(organism *Organism) {
+	hash := organism.Hash()
+	if hash == mut.preparedHash && organism.Diff == mut.preparedDiff {
+		return
+	}
+	mut.preparedHash = hash
+	mut.preparedDiff = organism.Diff
+	if mut.colorSolver != nil {
+		mut.colorSolver.Prepare(organism)
+	}
+	if organism.diffMap != nil && mut.usesErrorMap() {
+		mut.errorMap = NewDiffImportanceMap(organism.diffMap)
+		if mut.config.MutationImportance == MutationImportanceError {
+			mut.importanceMap = mut.errorMap
+		}
+	}
+	mut.instructionWeights = mut.instructionWeights[:0]
+	if mut.importanceMap == nil {
+		return
+	}
+	minWeight := mut.importanceMap.MeanWeight() * minInstructionImportance
+	var total float64
+	for _, instruction := range organism.Instructions {
+		x, y := instruction.Bounds().Center()
+		total += mut.importanceMap.Weight(x, y) + minWeight
+		mut.instructionWeights = append(mut.instructionWeights, total)
+	}
+}
+
 // Mutate is the primary function of the mutator
 func (mut *Mutator) Mutate(organism *Organism) PatchOperation {
 	// TODO: use configurable weights to skew randomness towards different actions
@@ -71,90 +141,123 @@ func (mut *Mutator) Mutate(organism *Organism) PatchOperation {
 	// 2 - delete random item
 	// 3 - mutate random item
 	// 4 - swap random items
+	// 5 - mutate background color
 	var operation PatchOperation
-	accepted := false
-	var focusThreshold int
-	if mut.focusMap != nil {
-		focusThreshold = rand.Intn(mut.maxFocusValue)
-	}
-
-	for !accepted {
-		organism.AffectedAreas = organism.AffectedAreas[:0]
-		switch rand.Int31n(5) {
-		case 0:
-			item := mut.RandomInstruction()
-			organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
-			operation = PatchOperation{
-				OperationType:   PatchOperationAppend,
-				InstructionData: item.Save(),
-				InstructionType: item.Type(),
-			}
-			objectPool.ReturnInstruction(item)
-		case 1:
-			item := mut.selectRandomInstruction(organism.Instructions)
-			item = item.Clone()
-			instructionMut := mut.instructionMutatorMap[item.Type()]
-			instructionMut.MutateInstruction(item)
-			organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
-			operation = PatchOperation{
-				OperationType:   PatchOperationAppend,
-				InstructionData: item.Save(),
-				InstructionType: item.Type(),
-			}
-		case 2:
-			item := mut.selectRandomInstruction(organism.Instructions)
-			organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
-			operation = PatchOperation{
-				OperationType:    PatchOperationDelete,
-				InstructionHash1: item.Hash(),
-			}
-		case 3:
-			item := mut.selectRandomInstruction(organism.Instructions)
-			hash := item.Hash()
-			item = item.Clone()
-			organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
-			instructionMut := mut.instructionMutatorMap[item.Type()]
-			instructionMut.MutateInstruction(item)
-			organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
-			operation = PatchOperation{
-				OperationType:    PatchOperationReplace,
-				InstructionHash1: hash,
-				InstructionData:  item.Save(),
-				InstructionType:  item.Type(),
-			}
-		case 4:
-			i := rand.Int31n(int32(len(organism.Instructions)))
-			j := rand.Int31n(int32(len(organism.Instructions)))
-			item1 := organism.Instructions[i]
-			item2 := organism.Instructions[j]
-			organism.AffectedAreas = append(organism.AffectedAreas, item1.Bounds())
-			organism.AffectedAreas = append(organism.AffectedAreas, item2.Bounds())
-			operation = PatchOperation{
-				OperationType:    PatchOperationSwap,
-				InstructionHash1: item1.Hash(),
-				InstructionHash2: item2.Hash(),
-			}
+	organism.AffectedAreas = organism.AffectedAreas[:0]
+	switch rand.Int31n(6) {
+	case 0:
+		item := mut.randomPlacedInstruction()
+		organism.mutation = mut.colorInstruction(item)
+		if mut.solveColor(item) {
+			organism.mutation = MutationInsertOptimalColor
 		}
-		if mut.focusMap == nil {
-			accepted = true
-		} else {
-			// Verify that at least one affected area is centered in a pixel
-			// that is at or above the focus threshold
-			for _, area := range organism.AffectedAreas {
-				x, y := area.Center()
-				pixel := mut.focusMap.At(int(x), int(y))
-				r, _, _, _ := pixel.RGBA()
-				if int(r) >= focusThreshold {
-					accepted = true
-					break
-				}
-			}
+		organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
+		operation = PatchOperation{
+			OperationType:   PatchOperationAppend,
+			InstructionData: item.Save(),
+			InstructionType: item.Type(),
+		}
+		objectPool.ReturnInstruction(item)
+	case 1:
+		item := organism.Instructions[mut.selectInstruction(organism.Instructions)]
+		item = item.Clone()
+		bounds := item.Bounds()
+		instructionMut := mut.instructionMutatorMap[item.Type()]
+		instructionMut.MutateInstruction(item)
+		if item.Bounds() != bounds {
+			mut.solveColor(item)
+		}
+		organism.mutation = MutationDuplicate
+		organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
+		operation = PatchOperation{
+			OperationType:   PatchOperationAppend,
+			InstructionData: item.Save(),
+			InstructionType: item.Type(),
+		}
+	case 2:
+		item := organism.Instructions[mut.selectInstruction(organism.Instructions)]
+		organism.mutation = MutationDelete
+		organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
+		operation = PatchOperation{
+			OperationType:    PatchOperationDelete,
+			InstructionHash1: item.Hash(),
+		}
+	case 3:
+		index := mut.selectInstruction(organism.Instructions)
+		item := organism.Instructions[index]
+		hash := item.Hash()
+		item = item.Clone()
+		bounds := item.Bounds()
+		organism.AffectedAreas = append(organism.AffectedAreas, bounds)
+		instructionMut := mut.instructionMutatorMap[item.Type()]
+		instructionMut.MutateInstruction(item)
+		if item.Bounds() != bounds {
+			mut.solveColorOver(item, organism.Background, organism.Instructions[:index])
+		}
+		organism.mutation = MutationMutate
+		organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
+		operation = PatchOperation{
+			OperationType:    PatchOperationReplace,
+			InstructionHash1: hash,
+			InstructionData:  item.Save(),
+			InstructionType:  item.Type(),
+		}
+	case 4:
+		i := mut.selectInstruction(organism.Instructions)
+		j := rand.Int31n(int32(len(organism.Instructions)))
+		item1 := organism.Instructions[i]
+		item2 := organism.Instructions[j]
+		organism.mutation = MutationSwap
+		organism.AffectedAreas = append(organism.AffectedAreas, item1.Bounds())
+		organism.AffectedAreas = append(organism.AffectedAreas, item2.Bounds())
+		operation = PatchOperation{
+			OperationType:    PatchOperationSwap,
+			InstructionHash1: item1.Hash(),
+			InstructionHash2: item2.Hash(),
+		}
+	case 5:
+		organism.mutation = MutationBackground
+		organism.AffectedAreas = append(organism.AffectedAreas, organism.CanvasBounds())
+		operation = PatchOperation{
+			OperationType: PatchOperationBackground,
+			Background:    SaveColorHex(mut.mutateBackground(organism.Background)),
 		}
 	}
 	operation.Apply(organism)
 	return operation
 }
 
+// mutateBackground returns a copy of the background color with a random
+// change in hue, saturation or lightness.
+func (mut *Mutator) mutateBackground(background *color.NRGBA) *color.NRGBA {
+	if background == nil {
+		background = &color.NRGBA{A: 255}
+	}
+	hue, sat, lightness := MakeColor(background).Hsl()
+	switch rand.Int31n(3) {
+	case 0:
+		hue = float64(mut.mutateValue(0, 360, mut.config.MinHueMutation, mut.config.MaxHueMutation, float32(hue)))
+	case 1:
+		sat = float64(mut.mutateValue(0, 1, mut.config.MinSaturationMutation, mut.config.MaxSaturationMutation, float32(sat)))
+	default:
+		lightness = float64(mut.mutateValue(0, 1, mut.config.MinValueMutation, mut.config.MaxValueMutation, float32(lightness)))
+	}
+	return WithAlpha(colorful.Hsl(hue, sat, lightness), 255)
+}
+
+func (mut *Mutator) mutateValue(min float32, max float32, minDelta float32, maxDelta float32, value float32) float32 {
+	amt := rand.Float32()*(maxDelta-minDelta) + minDelta
+	value = value + amt
+	// Make the new value wrap around at the inclusive boundaries
+	for value < min {
+		value = value + (max - min)
+	}
+	for value > max {
+		value = value - (max - min)
+	}
+	return value
+}
+
 // RandomInstruction returns a new random Instruction
 func (mut *Mutator) RandomInstruction() Instruction {
 	i := int(rand.Intn(len(mut.instructionMutators)))
@@ -163,7 +266,89 @@ func (mut *Mutator) RandomInstruction() Instruction {
 	return line
 }
 
-func (mut *Mutator) selectRandomInstruction(instructions []Instruction) Instruction {
-	i := rand.Int31n(int32(len(instructions)))
-	return instructions[i]
+// randomPlacedInstruction returns a new random Instruction at a position
+// sampled by the placement of its instruction type
+func (mut *Mutator) randomPlacedInstruction() Instruction {
+	i := int(rand.Intn(len(mut.instructionMutators)))
+	instructionMut := mut.instructionMutators[i]
+	importanceMap := mut.importanceMap
+	if mut.config.InstructionPlacement[instructionMut.InstructionType()] == PlacementError && mut.errorMap != nil {
+		importanceMap = mut.errorMap
+	}
+	if importanceMap == nil {
+		return instructionMut.RandomInstruction()
+	}
+	x, y := importanceMap.Sample()
+	return instructionMut.RandomInstructionAt(x, y)
+}
+
+// colorInstruction replaces the random color of a new instruction with a
+// color of the target, at the rate set by TargetColorRate. The kind of the
+// mutation is returned.
+func (mut *Mutator) colorInstruction(item Instruction) string {
+	coloredItem, ok := item.(ColoredInstruction)
+	if !ok || mut.targetColors == nil || rand.Float32() >= mut.config.TargetColorRate {
+		return MutationInsertRandomColor
+	}
+	var clr *color.NRGBA
+	if mut.config.TargetColorSample == TargetColorCenter {
+		clr = mut.targetColors.At(item.Bounds().Center())
+	} else {
+		clr = mut.targetColors.Mean(item.Bounds())
+	}
+	clr = Jitter(clr, mut.config.TargetColorJitter)
+	// Keep the random opacity
+	clr.A = coloredItem.GetColor().A
+	coloredItem.SetColor(clr)
+	return MutationInsertTargetColor
+}
+
+// solveColor replaces the color of an appended instruction with the color
+// that best matches the target, if OptimalColors is set
+func (mut *Mutator) solveColor(item Instruction) bool {
+	coloredItem, ok := item.(ColoredInstruction)
+	if !ok || mut.colorSolver == nil {
+		return false
+	}
+	return mut.colorSolver.Solve(coloredItem)
+}
+
+// solveColorOver replaces the color of a moved instruction with the color
+// that best matches the target over the instructions below it, if
+// OptimalColors is set
+func (mut *Mutator) solveColorOver(item Instruction, background *color.NRGBA, below []Instruction) bool {
+	coloredItem, ok := item.(ColoredInstruction)
+	if !ok || mut.colorSolver == nil {
+		return false
+	}
+	return mut.colorSolver.SolveOver(coloredItem, background, below)
+}
+
+// usesErrorMap determines if any positions are sampled by error
+func (mut *Mutator) usesErrorMap() bool {
+	if mut.config.MutationImportance == MutationImportanceError {
+		return true
+	}
+	for _, placement := range mut.config.InstructionPlacement {
+		if placement == PlacementError {
+			return true
+		}
+	}
+	return false
+}
+
+// selectInstruction returns the index of a random instruction. Instructions
+// are selected in proportion to their importance if the organism was prepared.
+func (mut *Mutator) selectInstruction(instructions []Instruction) int {
+	if len(mut.instructionWeights) != len(instructions) {
+		return int(rand.Int31n(int32(len(instructions))))
+	}
+	value := rand.Float64() * mut.instructionWeights[len(mut.instructionWeights)-1]
+	i := sort.Search(len(mut.instructionWeights), func(i int) bool {
+		return mut.instructionWeights[i] > value
+	})
+	if i >= len(instructions) {
+		i = len(instructions) - 1
+	}
+	return i
 }
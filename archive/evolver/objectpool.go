@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"log"
 	"runtime/debug"
 
@@ -19,6 +20,8 @@ type ObjectPool struct {
 	rendererPool     *pool.ObjectPool
 	diffmapPool      *pool.ObjectPool
 	byteBufferPool   *pool.ObjectPool
+	canvasWidth      int
+	canvasHeight     int
 }
 
 // NewObjectPool returns a new ObjectPool
@@ -47,6 +50,8 @@ func NewObjectPool() *ObjectPool {
 // SetRendererBounds prepares the object pool to provide Renderers
 func (p *ObjectPool) SetRendererBounds(imageWidth int, imageHeight int) {
 	ctx := context.Background()
+	p.canvasWidth = imageWidth
+	p.canvasHeight = imageHeight
 	rendererFactory := NewRendererFactory(imageWidth, imageHeight)
 	p.rendererPool = pool.NewObjectPoolWithDefaultConfig(ctx, rendererFactory)
 	p.rendererPool.Config.MaxIdle = -1
@@ -57,6 +62,12 @@ func (p *ObjectPool) SetRendererBounds(imageWidth int, imageHeight int) {
 	p.diffmapPool.Config.MaxTotal = -1
 }
 
+// CanvasSize returns the size of the canvas that Renderers and DiffMaps are
+// provided for
+func (p *ObjectPool) CanvasSize() (int, int) {
+	return p.canvasWidth, p.canvasHeight
+}
+
 // AddInstructionFactory registers a PooledObjectFactory for a type of Instruction
 func (p *ObjectPool) AddInstructionFactory(instructionType string, factory pool.PooledObjectFactory) {
 	ctx := context.Background()
@@ -148,7 +159,11 @@ func (p *ObjectPool) ReturnOrganism(organism *Organism) {
 // BorrowInstruction checks out an Instruction from the pool
 func (p *ObjectPool) BorrowInstruction(instructionType string) Instruction {
 	ctx := context.Background()
-	obj, err := p.instructionPools[instructionType].BorrowObject(ctx)
+	instructionPool, has := p.instructionPools[instructionType]
+	if !has {
+		panic(fmt.Sprintf("No instruction factory registered for type '%v'", instructionType))
+	}
+	obj, err := instructionPool.BorrowObject(ctx)
 	if err != nil {
 		log.Printf("BorrowInstruction Error: %v", err.Error())
 	}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/fogleman/gg"
+)
+
+// A ColorSolver calculates the color of an instruction that best matches the
+// target over the pixels it covers. The instruction is assumed to be drawn
+// over the prepared canvas with its current opacity.
+type ColorSolver struct {
+	step   int
+	target *image.RGBA
+	// canvas is the rendered organism that new instructions are drawn over
+	canvas *Renderer
+	// below is a scratch canvas for the instructions below a moved instruction
+	below *Renderer
+	// coverage is a scratch canvas that instructions are drawn on in opaque
+	// white, to find out how much of each pixel they cover
+	coverage *gg.Context
+}
+
+// NewColorSolver returns a ColorSolver for a target image. Colors are
+// quantized to multiples of step.
+func NewColorSolver(target image.Image, step int) *ColorSolver {
+	size := target.Bounds().Size()
+	rgba := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	draw.Draw(rgba, rgba.Bounds(), target, target.Bounds().Min, draw.Src)
+	return &ColorSolver{
+		step:     step,
+		target:   rgba,
+		canvas:   NewRenderer(size.X, size.Y),
+		below:    NewRenderer(size.X, size.Y),
+		coverage: gg.NewContext(size.X, size.Y),
+	}
+}
+
+// Prepare renders the organism that instructions will be drawn over
+func (solver *ColorSolver) Prepare(organism *Organism) {
+	solver.canvas.Render(organism.Background, organism.Instructions)
+}
+
+// Solve replaces the color of an instruction that is appended to the
+// prepared organism with the color that best matches the target. Returns
+// false if the instruction doesn't cover any pixels.
+func (solver *ColorSolver) Solve(item ColoredInstruction) bool {
+	return solver.solve(item, solver.canvas.GetImage().(*image.RGBA))
+}
+
+// SolveOver replaces the color of an instruction with the color that best
+// matches the target when it is drawn over a background and instructions,
+// such as the instructions below an instruction that was moved. Instructions
+// above it are ignored.
+func (solver *ColorSolver) SolveOver(item ColoredInstruction, background *color.NRGBA, instructions []Instruction) bool {
+	solver.below.RenderBounds(background, instructions, []Rect{item.Bounds()})
+	return solver.solve(item, solver.below.GetImage().(*image.RGBA))
+}
+
+// solve replaces the color of an instruction with the color that minimizes
+// the squared error against the target. A pixel covered with alpha a becomes
+// a*c + (1-a)*canvas, so the best c for each channel is
+// sum(a*(target-(1-a)*canvas)) / sum(a*a). The opacity isn't changed.
+func (solver *ColorSolver) solve(item ColoredInstruction, canvas *image.RGBA) bool {
+	size := solver.target.Bounds().Size()
+	left, top, right, bottom := item.Bounds().PixelArea(0)
+	area := pixelArea{left, top, right, bottom}.clamp(size.X, size.Y)
+	if area.empty() {
+		return false
+	}
+	original := item.GetColor()
+	if original.A == 0 {
+		return false
+	}
+	coverage := solver.coverage.Image().(*image.RGBA)
+	for y := area.top; y < area.bottom; y++ {
+		row := coverage.Pix[coverage.PixOffset(area.left, y):coverage.PixOffset(area.right, y)]
+		for i := range row {
+			row[i] = 0
+		}
+	}
+	item.SetColor(&color.NRGBA{255, 255, 255, 255})
+	item.Execute(solver.coverage)
+	item.SetColor(original)
+
+	opacity := float64(original.A) / 255
+	var sums [3]float64
+	var weight float64
+	for y := area.top; y < area.bottom; y++ {
+		for x := area.left; x < area.right; x++ {
+			a := opacity * float64(coverage.Pix[coverage.PixOffset(x, y)+3]) / 255
+			if a == 0 {
+				continue
+			}
+			ti, ci := solver.target.PixOffset(x, y), canvas.PixOffset(x, y)
+			for channel := range sums {
+				sums[channel] += a * (float64(solver.target.Pix[ti+channel]) - (1-a)*float64(canvas.Pix[ci+channel]))
+			}
+			weight += a * a
+		}
+	}
+	if weight == 0 {
+		return false
+	}
+	var channels [3]uint8
+	for channel, sum := range sums {
+		channels[channel] = solver.quantize(sum / weight)
+	}
+	item.SetColor(&color.NRGBA{channels[0], channels[1], channels[2], original.A})
+	return true
+}
+
+// quantize rounds a channel to the nearest multiple of the step, so that
+// colors solved for nearly identical instructions have the same hash
+func (solver *ColorSolver) quantize(value float64) uint8 {
+	step := float64(solver.step)
+	value = float64(int(value/step+0.5)) * step
+	if value < 0 {
+		return 0
+	}
+	if value > 255 {
+		return 255
+	}
+	return uint8(value)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func uniformTarget(clr color.NRGBA) image.Image {
+	target := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(target, target.Bounds(), image.NewUniform(clr), image.Point{}, draw.Src)
+	return target
+}
+
+func expectColor(t *testing.T, actual *color.NRGBA, expected color.NRGBA, tolerance int) {
+	t.Helper()
+	near := func(a uint8, b uint8) bool {
+		d := int(a) - int(b)
+		return d <= tolerance && d >= -tolerance
+	}
+	if !near(actual.R, expected.R) || !near(actual.G, expected.G) || !near(actual.B, expected.B) || actual.A != expected.A {
+		t.Errorf("Expected %v (+-%v), got %v", expected, tolerance, *actual)
+	}
+}
+
+func TestSolveOpaqueInstruction(t *testing.T) {
+	solver := NewColorSolver(uniformTarget(color.NRGBA{100, 150, 30, 255}), 1)
+	solver.Prepare(&Organism{Background: &color.NRGBA{0, 0, 0, 255}})
+	circle := &Circle{X: 50, Y: 50, Radius: 20, Color: &color.NRGBA{1, 2, 3, 255}}
+
+	if !solver.Solve(circle) {
+		t.Fatal("Expected the circle to be solved")
+	}
+	// Antialiased edge pixels pull the color up slightly
+	expectColor(t, circle.Color, color.NRGBA{100, 150, 30, 255}, 3)
+}
+
+func TestSolveMovedInstructionIgnoresItsOldPosition(t *testing.T) {
+	solver := NewColorSolver(uniformTarget(color.NRGBA{100, 100, 30, 255}), 1)
+	background := &color.NRGBA{0, 0, 0, 255}
+	old := &Circle{X: 50, Y: 50, Radius: 20, Color: &color.NRGBA{0, 255, 0, 128}}
+	solver.Prepare(&Organism{Background: background, Instructions: []Instruction{old}})
+	moved := &Circle{X: 55, Y: 50, Radius: 20, Color: &color.NRGBA{1, 2, 3, 128}}
+
+	if !solver.SolveOver(moved, background, []Instruction{}) {
+		t.Fatal("Expected the circle to be solved")
+	}
+	// Half opaque over black, so the color must be about twice the target
+	expectColor(t, moved.Color, color.NRGBA{200, 200, 60, 128}, 6)
+}
+
+func TestSolveQuantizesColors(t *testing.T) {
+	solver := NewColorSolver(uniformTarget(color.NRGBA{101, 150, 30, 255}), 8)
+	solver.Prepare(&Organism{})
+	circle := &Circle{X: 50, Y: 50, Radius: 20, Color: &color.NRGBA{1, 2, 3, 255}}
+
+	solver.Solve(circle)
+	for _, channel := range []uint8{circle.Color.R, circle.Color.G, circle.Color.B} {
+		if channel%8 != 0 && channel != 255 {
+			t.Errorf("Expected channels to be multiples of 8, got %v", *circle.Color)
+		}
+	}
+}
+
+func TestSolveInstructionOutsideOfCanvas(t *testing.T) {
+	solver := NewColorSolver(uniformTarget(color.NRGBA{100, 150, 30, 255}), 1)
+	solver.Prepare(&Organism{})
+	circle := &Circle{X: -50, Y: -50, Radius: 10, Color: &color.NRGBA{1, 2, 3, 255}}
+
+	if solver.Solve(circle) {
+		t.Error("Expected a circle outside of the canvas not to be solved")
+	}
+	expectColor(t, circle.Color, color.NRGBA{1, 2, 3, 255}, 0)
+}
@@ -3,25 +3,36 @@ package main
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
+	"image/color"
 )
 
+// backgroundType is used in place of an instruction type to save
+// the background color of an organism
+const backgroundType = "background"
+
 // An Organism is an attempt at matching an image with
 // a set of painting instructions
 type Organism struct {
 	Instructions  []Instruction
+	Background    *color.NRGBA // If nil, the background is black
 	Diff          float32
 	hash          string
 	diffMap       *DiffMap
 	Parent        *Organism
 	AffectedAreas []Rect
 	Patch         *Patch
+	mutation      string // The kind of mutation that created the organism, for MutationStats
 }
 
 // Hash returns a (probably) unique hash that represents this organism
 func (organism *Organism) Hash() string {
 	if organism.hash == "" {
 		hasher := md5.New()
+		if organism.Background != nil {
+			hasher.Write([]byte(SaveColorHex(organism.Background)))
+		}
 		for _, instruction := range organism.Instructions {
 			hasher.Write([]byte(instruction.Hash()))
 		}
@@ -32,6 +43,14 @@ func (organism *Organism) Hash() string {
 
 func (organism *Organism) Save() []byte {
 	buf := &bytes.Buffer{}
+	if organism.Background != nil {
+		buf.Write([]byte(backgroundType))
+		buf.Write([]byte("|"))
+		buf.Write([]byte(SaveColorHex(organism.Background)))
+		if len(organism.Instructions) > 0 {
+			buf.Write([]byte("\t"))
+		}
+	}
 	for i, instruction := range organism.Instructions {
 		if i > 0 {
 			buf.Write([]byte("\t"))
@@ -47,6 +66,14 @@ func (organism *Organism) Save() []byte {
 // SaveV2 uses a newline delimiter between instructions
 func (organism *Organism) SaveV2() []byte {
 	buf := &bytes.Buffer{}
+	if organism.Background != nil {
+		buf.Write([]byte(backgroundType))
+		buf.Write([]byte("|"))
+		buf.Write([]byte(SaveColorHex(organism.Background)))
+		if len(organism.Instructions) > 0 {
+			buf.Write([]byte("\n"))
+		}
+	}
 	for i, instruction := range organism.Instructions {
 		if i > 0 {
 			buf.Write([]byte("\n"))
@@ -59,14 +86,42 @@ func (organism *Organism) SaveV2() []byte {
 	return buf.Bytes()
 }
 
-func (organism *Organism) Load(data []byte) {
+// SaveEncoded saves the organism with the specified encoding
+// (EncodingText or EncodingBinary)
+func (organism *Organism) SaveEncoded(encoding string) []byte {
+	if encoding == EncodingBinary {
+		return organism.SaveBinary()
+	}
+	return organism.Save()
+}
+
+// Load loads instructions that were saved with Save or SaveBinary
+func (organism *Organism) Load(data []byte) error {
+	if IsBinaryOrganism(data) {
+		return organism.LoadBinary(data)
+	}
 	instructionData := bytes.Split(data, []byte("\t"))
 	for _, instructionDataItem := range instructionData {
-		parts := bytes.Split(instructionDataItem, []byte("|"))
+		parts := bytes.SplitN(instructionDataItem, []byte("|"), 2)
+		if len(parts) < 2 {
+			return fmt.Errorf("Invalid instruction data '%v'", string(instructionDataItem))
+		}
 		instructionType := string(parts[0])
-		instruction := LoadInstruction(instructionType, parts[1])
+		if instructionType == backgroundType {
+			organism.Background = LoadColorHex(string(parts[1]))
+			continue
+		}
+		// Truncated files would otherwise load partial instructions
+		if !json.Valid(parts[1]) {
+			return fmt.Errorf("Invalid %v instruction data '%v'", instructionType, string(parts[1]))
+		}
+		instruction, err := LoadInstruction(instructionType, parts[1])
+		if err != nil {
+			return err
+		}
 		organism.Instructions = append(organism.Instructions, instruction)
 	}
+	return nil
 }
 
 func (organism *Organism) Clone() *Organism {
@@ -75,6 +130,10 @@ func (organism *Organism) Clone() *Organism {
 	clone.AffectedAreas = append(clone.AffectedAreas, organism.AffectedAreas...)
 	clone.Diff = organism.Diff
 	clone.Parent = organism
+	if organism.Background != nil {
+		background := *organism.Background
+		clone.Background = &background
+	}
 	for _, instruction := range organism.Instructions {
 		clone.Instructions = append(clone.Instructions, instruction.Clone())
 	}
@@ -82,15 +141,25 @@ func (organism *Organism) Clone() *Organism {
 		clone.Patch = organism.Patch.Clone()
 	}
 	// copy over diffmap
-	for x := 0; x < len(organism.diffMap.Diffs); x++ {
-		for y := 0; y < len(organism.diffMap.Diffs[0]); y++ {
-			clone.diffMap.Diffs[x][y] = organism.diffMap.Diffs[x][y]
-		}
-	}
-	clone.diffMap.Total = organism.diffMap.Total
+	clone.diffMap.CopyFrom(organism.diffMap)
 	return clone
 }
 
+// UnweightedDiff returns the diff of the organism without focus weights
+func (organism *Organism) UnweightedDiff() float32 {
+	return organism.diffMap.GetUnweightedAverageDiff()
+}
+
+// CanvasBounds returns a Rect that covers the entire canvas. The canvas size
+// must be set.
+func (organism *Organism) CanvasBounds() Rect {
+	width, height := objectPool.CanvasSize()
+	return Rect{
+		Right:  float32(width),
+		Bottom: float32(height),
+	}
+}
+
 // CleanupInstructions removes any duplicate instructions. In the future
 // it might do more cleanup related stuff.
 func (organism *Organism) CleanupInstructions() {
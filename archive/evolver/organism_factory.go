@@ -53,10 +53,12 @@ func (f *OrganismFactory) PassivateObject(ctx context.Context, object *pool.Pool
 		organism.Instructions = organism.Instructions[:0]
 	}
 	organism.AffectedAreas = organism.AffectedAreas[:0]
+	organism.Background = nil
 	organism.Diff = -1
 	organism.hash = ""
 	organism.Parent = nil
 	organism.Patch = nil
 	organism.diffMap = nil
+	organism.mutation = ""
 	return nil
 }
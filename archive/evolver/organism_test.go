@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestBackgroundOperationOnUnpooledOrganism(t *testing.T) {
+	setCanvasSize(40, 30)
+	organism := &Organism{}
+	operation := PatchOperation{
+		OperationType: PatchOperationBackground,
+		Background:    "#102030",
+	}
+
+	affectedAreas := operation.Apply(organism)
+	expected := Rect{Right: 40, Bottom: 30}
+	if len(affectedAreas) != 1 || affectedAreas[0] != expected {
+		t.Errorf("Expected affected areas [%v], got %v", expected, affectedAreas)
+	}
+	if SaveColorHex(organism.Background) != "#102030" {
+		t.Errorf("Expected background #102030, got %v", SaveColorHex(organism.Background))
+	}
+}
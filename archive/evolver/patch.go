@@ -1,5 +1,7 @@
 package main
 
+import "log"
+
 // TODO: change this to V2
 
 const (
@@ -11,6 +13,8 @@ const (
 	PatchOperationReplace = "r"
 	// PatchOperationSwap - swap two items
 	PatchOperationSwap = "s"
+	// PatchOperationBackground - change the background color
+	PatchOperationBackground = "b"
 )
 
 // A PatchOperation represents a single element of a patch.
@@ -19,15 +23,47 @@ type PatchOperation struct {
 	InstructionHash2 string `json:"hash2,omitempty"`
 	InstructionData  []byte `json:"data,omitempty"`
 	InstructionType  string `json:"type,omitempty"`
+	Background       string `json:"background,omitempty"` // hex color
 	OperationType    string `json:"op"`
+	// Encoding of InstructionData. Empty means EncodingText.
+	Encoding string `json:"encoding,omitempty"`
 }
 
 // LoadInstruction will return an `Instruction` that is loaded from
 // the saved instruction data.
-func (operation PatchOperation) LoadInstruction() Instruction {
-	item := objectPool.BorrowInstruction(operation.InstructionType)
-	item.Load(operation.InstructionData)
-	return item
+func (operation PatchOperation) LoadInstruction() (Instruction, error) {
+	if operation.Encoding == EncodingBinary {
+		return LoadInstructionBinary(operation.InstructionType, operation.InstructionData)
+	}
+	return LoadInstruction(operation.InstructionType, operation.InstructionData)
+}
+
+// Encode returns a copy of the operation with the instruction data
+// converted to the specified encoding.
+func (operation PatchOperation) Encode(encoding string) (PatchOperation, error) {
+	if operation.InstructionData == nil || operation.encoding() == encoding {
+		return operation, nil
+	}
+	instruction, err := operation.LoadInstruction()
+	if err != nil {
+		return operation, err
+	}
+	if encoding == EncodingBinary {
+		operation.InstructionData = SaveInstructionBinary(instruction)
+		operation.Encoding = EncodingBinary
+	} else {
+		operation.InstructionData = instruction.Save()
+		operation.Encoding = ""
+	}
+	objectPool.ReturnInstruction(instruction)
+	return operation, nil
+}
+
+func (operation PatchOperation) encoding() string {
+	if operation.Encoding == "" {
+		return EncodingText
+	}
+	return operation.Encoding
 }
 
 // Apply applies the operation to the organism
@@ -35,7 +71,11 @@ func (operation PatchOperation) Apply(organism *Organism) []Rect {
 	affectedAreas := []Rect{}
 	switch operation.OperationType {
 	case PatchOperationAppend:
-		item := operation.LoadInstruction()
+		item, err := operation.LoadInstruction()
+		if err != nil {
+			log.Printf("Error applying patch operation: %v", err.Error())
+			break
+		}
 		affectedAreas = append(affectedAreas, item.Bounds())
 		organism.Instructions = append(organism.Instructions, item)
 	case PatchOperationDelete:
@@ -49,10 +89,14 @@ func (operation PatchOperation) Apply(organism *Organism) []Rect {
 	case PatchOperationReplace:
 		for idx, item := range organism.Instructions {
 			if item.Hash() == operation.InstructionHash1 {
+				newItem, err := operation.LoadInstruction()
+				if err != nil {
+					log.Printf("Error applying patch operation: %v", err.Error())
+					break
+				}
 				affectedAreas = append(affectedAreas, item.Bounds())
-				item := operation.LoadInstruction()
-				affectedAreas = append(affectedAreas, item.Bounds())
-				organism.Instructions[idx] = item
+				affectedAreas = append(affectedAreas, newItem.Bounds())
+				organism.Instructions[idx] = newItem
 				break
 			}
 		}
@@ -75,6 +119,9 @@ func (operation PatchOperation) Apply(organism *Organism) []Rect {
 			organism.Instructions[idx1], organism.Instructions[idx2] =
 				organism.Instructions[idx2], organism.Instructions[idx1]
 		}
+	case PatchOperationBackground:
+		organism.Background = LoadColorHex(operation.Background)
+		affectedAreas = append(affectedAreas, organism.CanvasBounds())
 	}
 	return affectedAreas
 }
@@ -99,6 +146,23 @@ func (patch *Patch) Clone() *Patch {
 	return clone
 }
 
+// Encode returns a copy of the patch with all instruction data
+// converted to the specified encoding.
+func (patch *Patch) Encode(encoding string) (*Patch, error) {
+	clone := objectPool.BorrowPatch()
+	clone.Baseline = patch.Baseline
+	clone.Target = patch.Target
+	for _, operation := range patch.Operations {
+		operation, err := operation.Encode(encoding)
+		if err != nil {
+			objectPool.ReturnPatch(clone)
+			return nil, err
+		}
+		clone.Operations = append(clone.Operations, operation)
+	}
+	return clone, nil
+}
+
 // A PatchProcessor can use Patches to update Organism instructions
 type PatchProcessor struct{}
 
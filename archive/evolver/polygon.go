@@ -8,11 +8,27 @@ import (
 	"math"
 
 	"github.com/fogleman/gg"
+	pool "github.com/jolestar/go-commons-pool"
 )
 
 // TypePolygon is the type name for polygons
 const TypePolygon = "polygon"
 
+func init() {
+	RegisterInstructionType(&InstructionType{
+		Name: TypePolygon,
+		NewFactory: func() pool.PooledObjectFactory {
+			return NewPolygonFactory()
+		},
+		NewMutator: func(config *Config, imageWidth float32, imageHeight float32) InstructionMutator {
+			return NewPolygonMutator(config, imageWidth, imageHeight)
+		},
+		Load: func(data []byte) Instruction {
+			return loadPooledInstruction(TypePolygon, data)
+		},
+	})
+}
+
 // A Polypoint represents one point of a polygon. It includes the distance
 // from the center, and the angle (in radians) around the center that the
 // point occurs.
@@ -55,9 +71,9 @@ type Polygon struct {
 	X          float32
 	Y          float32
 	Points     []Polypoint
-	Color      *color.RGBA `json:"-"`
-	SavedColor *SavedColor `json:",omitempty"`
-	HexColor   string      `json:",omitempty"`
+	Color      *color.NRGBA `json:"-"`
+	SavedColor *SavedColor  `json:",omitempty"`
+	HexColor   string       `json:",omitempty"`
 	hash       string
 	bounds     Rect // Cache bounds
 }
@@ -95,11 +111,39 @@ func (polygon *Polygon) Load(data []byte) {
 	json.Unmarshal(data, polygon)
 	if polygon.SavedColor != nil {
 		polygon.Color = LoadColor(polygon.SavedColor)
+		// The color will be saved as hex from now on
+		polygon.SavedColor = nil
 	} else {
 		polygon.Color = LoadColorHex(polygon.HexColor)
 	}
 }
 
+// SaveBinary saves the polygon in the compact binary encoding
+func (polygon *Polygon) SaveBinary(encoder *BinaryEncoder) {
+	encoder.WriteColor(polygon.Color)
+	encoder.WriteFixed(polygon.X)
+	encoder.WriteFixed(polygon.Y)
+	encoder.WriteUvarint(uint64(len(polygon.Points)))
+	for _, point := range polygon.Points {
+		encoder.WriteFixed(point.Distance)
+		encoder.WriteFixed(point.Angle)
+	}
+}
+
+// LoadBinary loads the polygon from the compact binary encoding
+func (polygon *Polygon) LoadBinary(decoder *BinaryDecoder) {
+	polygon.Color = decoder.ReadColor()
+	polygon.X = decoder.ReadFixed()
+	polygon.Y = decoder.ReadFixed()
+	count := decoder.ReadUvarint()
+	for i := uint64(0); i < count && decoder.Err() == nil; i++ {
+		point := Polypoint{}
+		point.Distance = decoder.ReadFixed()
+		point.Angle = decoder.ReadFixed()
+		polygon.Points = append(polygon.Points, point)
+	}
+}
+
 func (polygon *Polygon) Type() string {
 	return TypePolygon
 }
@@ -120,6 +164,23 @@ func (polygon *Polygon) Clone() Instruction {
 	return newPolygon
 }
 
+// SVG returns the polygon as an svg polygon element
+func (polygon *Polygon) SVG() string {
+	buf := objectPool.BorrowByteBuffer()
+	buf.WriteString(`<polygon points="`)
+	for i, point := range polygon.Points {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		x, y := point.CalculateCoordinates(polygon.X, polygon.Y)
+		buf.WriteString(svgNumber(x) + "," + svgNumber(y))
+	}
+	buf.WriteString(`" ` + svgPaint("fill", polygon.Color) + "/>")
+	element := buf.String()
+	objectPool.ReturnByteBuffer(buf)
+	return element
+}
+
 func (polygon *Polygon) Hash() string {
 	if polygon.hash == "" {
 		buf := objectPool.BorrowByteBuffer()
@@ -142,6 +203,17 @@ func (polygon *Polygon) RecalculateHash() {
 	polygon.Hash()
 }
 
+// GetColor returns the color of the polygon
+func (polygon *Polygon) GetColor() *color.NRGBA {
+	return polygon.Color
+}
+
+// SetColor replaces the color of the polygon
+func (polygon *Polygon) SetColor(clr *color.NRGBA) {
+	polygon.Color = clr
+	polygon.hash = ""
+}
+
 // Bounds returns the rectangular bounds of the polygon
 func (polygon *Polygon) Bounds() Rect {
 	if polygon.bounds != (Rect{}) {
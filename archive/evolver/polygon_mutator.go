@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"image/color"
 	"math"
 	"math/rand"
 	"sort"
@@ -53,32 +52,44 @@ func (mut *PolygonMutator) InstructionType() string {
 // Red, Green, Blue
 
 func (mut *PolygonMutator) mutateColor(polygon *Polygon) {
-	switch rand.Int31n(3) {
+	// Opacity is only mutated if a range of opacities is allowed
+	numColorMutations := int32(3)
+	if mut.config.MaxOpacity > mut.config.MinOpacity {
+		numColorMutations = 4
+	}
+	switch rand.Int31n(numColorMutations) {
 	case 0:
 		mut.mutateHue(polygon)
 	case 1:
 		mut.mutateSaturation(polygon)
-	default:
+	case 2:
 		mut.mutateLightness(polygon)
+	default:
+		mut.mutateOpacity(polygon)
 	}
 }
 
 func (mut *PolygonMutator) mutateHue(polygon *Polygon) {
 	hue, sat, lightness := MakeColor(polygon.Color).Hsl()
 	newHue := mut.mutateValue(0, 360, mut.config.MinHueMutation, mut.config.MaxHueMutation, float32(hue))
-	polygon.Color = LoadColorHex(SaveColorHex(colorful.Hsl(float64(newHue), sat, lightness)))
+	polygon.Color = WithAlpha(colorful.Hsl(float64(newHue), sat, lightness), polygon.Color.A)
 }
 
 func (mut *PolygonMutator) mutateSaturation(polygon *Polygon) {
 	hue, sat, lightness := MakeColor(polygon.Color).Hsl()
 	newSat := mut.mutateValue(0, 1, mut.config.MinSaturationMutation, mut.config.MaxSaturationMutation, float32(sat))
-	polygon.Color = LoadColorHex(SaveColorHex(colorful.Hsl(hue, float64(newSat), lightness)))
+	polygon.Color = WithAlpha(colorful.Hsl(hue, float64(newSat), lightness), polygon.Color.A)
+}
+
+func (mut *PolygonMutator) mutateOpacity(polygon *Polygon) {
+	opacity := mut.mutateValue(mut.config.MinOpacity, mut.config.MaxOpacity, mut.config.MinOpacityMutation, mut.config.MaxOpacityMutation, float32(polygon.Color.A)/255.0)
+	polygon.Color.A = uint8(opacity * 255)
 }
 
 func (mut *PolygonMutator) mutateLightness(polygon *Polygon) {
 	hue, sat, lightness := MakeColor(polygon.Color).Hsl()
 	newLightness := mut.mutateValue(0, 1, mut.config.MinValueMutation, mut.config.MaxValueMutation, float32(lightness))
-	polygon.Color = LoadColorHex(SaveColorHex(colorful.Hsl(hue, sat, float64(newLightness))))
+	polygon.Color = WithAlpha(colorful.Hsl(hue, sat, float64(newLightness)), polygon.Color.A)
 }
 
 // Mutate Brush Size
@@ -148,16 +159,15 @@ func (mut *PolygonMutator) mutateCoordinates(polygon *Polygon) {
 // Remove Instruction
 // Swap Instructions
 func (mut *PolygonMutator) RandomInstruction() Instruction {
+	return mut.RandomInstructionAt(rand.Float32()*mut.imageWidth, rand.Float32()*mut.imageHeight)
+}
+
+func (mut *PolygonMutator) RandomInstructionAt(x float32, y float32) Instruction {
 	numPoints := rand.Intn(mut.config.MaxPolygonPoints-mut.config.MinPolygonPoints) + mut.config.MinPolygonPoints
 	polygon := objectPool.BorrowInstruction(TypePolygon).(*Polygon)
-	polygon.X = mut.trunc(rand.Float32() * mut.imageWidth)
-	polygon.Y = mut.trunc(rand.Float32() * mut.imageHeight)
-	polygon.Color = &color.RGBA{
-		A: 255,
-		G: uint8(rand.Int31n(255)),
-		B: uint8(rand.Int31n(255)),
-		R: uint8(rand.Int31n(255)),
-	}
+	polygon.X = mut.trunc(x)
+	polygon.Y = mut.trunc(y)
+	polygon.Color = RandomColor(mut.config.MinOpacity, mut.config.MaxOpacity)
 	for i := 0; i < numPoints; i++ {
 		polygon.Points = append(polygon.Points, mut.randomPoint())
 	}
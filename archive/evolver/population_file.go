@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PopulationFormatVersion is the current version of the population file format.
+// Since version 3, the first line of a population file is a json header.
+// Older files only have the iteration on the first line.
+const PopulationFormatVersion = 3
+
+// legacyPopulationFormatVersion is reported for files without a json header
+const legacyPopulationFormatVersion = 2
+
+// A PopulationHeader describes the contents of a population file
+type PopulationHeader struct {
+	Version   int
+	Iteration int
+	// Width and Height are the dimensions of the canvas. They are zero
+	// if the dimensions are unknown.
+	Width  int `json:",omitempty"`
+	Height int `json:",omitempty"`
+	// TargetHash identifies the target image that the population was evolved against
+	TargetHash       string  `json:",omitempty"`
+	Diff             float32 `json:",omitempty"`
+	InstructionCount int
+	Config           *Config `json:",omitempty"`
+	Created          time.Time
+	// Encoding is the encoding of the organism that follows the header.
+	// Files without an encoding are text encoded.
+	Encoding string `json:",omitempty"`
+}
+
+// NewPopulationHeader creates a header for the current population file format version
+func NewPopulationHeader(iteration int, width int, height int) *PopulationHeader {
+	return &PopulationHeader{
+		Version:   PopulationFormatVersion,
+		Iteration: iteration,
+		Width:     width,
+		Height:    height,
+		Created:   time.Now(),
+	}
+}
+
+// HasDimensions returns true if the canvas dimensions are known
+func (header *PopulationHeader) HasDimensions() bool {
+	return header.Width > 0 && header.Height > 0
+}
+
+// ParsePopulationHeader parses the first line of a population file
+func ParsePopulationHeader(line []byte) (*PopulationHeader, error) {
+	line = bytes.TrimSpace(line)
+	if bytes.HasPrefix(line, []byte("{")) {
+		header := &PopulationHeader{}
+		err := json.Unmarshal(line, header)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid population file header: %v", err.Error())
+		}
+		if header.Version > PopulationFormatVersion {
+			return nil, fmt.Errorf("Population file format version %v is not supported, the latest supported version is %v", header.Version, PopulationFormatVersion)
+		}
+		return header, nil
+	}
+	iteration, err := strconv.ParseInt(string(line), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid population file header '%v'", string(line))
+	}
+	return &PopulationHeader{
+		Version:   legacyPopulationFormatVersion,
+		Iteration: int(iteration),
+	}, nil
+}
+
+// ReadPopulationFile reads the header and the saved top organism from a population file
+func ReadPopulationFile(filename string) (*PopulationHeader, []byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	headerLine := data
+	rest := []byte{}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		headerLine, rest = data[:i], data[i+1:]
+	}
+	if len(bytes.TrimSpace(headerLine)) == 0 {
+		return nil, nil, fmt.Errorf("Population file '%v' is empty", filename)
+	}
+	header, err := ParsePopulationHeader(headerLine)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Binary organisms can contain newlines, so they are returned as is
+	if IsBinaryOrganism(rest) {
+		return header, rest, nil
+	}
+	for _, line := range bytes.Split(rest, []byte("\n")) {
+		if line = bytes.TrimRight(line, "\r"); len(line) > 0 {
+			return header, line, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("No organisms found in population file '%v'", filename)
+}
+
+// LoadPopulationFile loads the header and the top organism from a population file
+func LoadPopulationFile(filename string) (*PopulationHeader, *Organism, error) {
+	header, data, err := ReadPopulationFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	organism := &Organism{}
+	err = organism.Load(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, organism, nil
+}
+
+// SavePopulationFile saves a header and a saved organism (see Organism.SaveEncoded) as a population file
+func SavePopulationFile(filename string, header *PopulationHeader, organismData []byte) error {
+	if IsBinaryOrganism(organismData) {
+		header.Encoding = EncodingBinary
+	}
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	buf.Write(headerData)
+	buf.WriteString("\n")
+	buf.Write(organismData)
+	return writeFileAtomic(filename, buf.Bytes())
+}
+
+// writeFileAtomic writes a file so that it is either completely written or
+// not changed at all. The data is written to a temporary file that replaces
+// the original file once it is safely on disk.
+func writeFileAtomic(filename string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// CheckpointFilename returns the name of a checkpoint of a population file
+func CheckpointFilename(populationFile string, iteration int) string {
+	return fmt.Sprintf("%v.checkpoint-%07d", populationFile, iteration)
+}
+
+// ListCheckpoints returns the checkpoints of a population file, newest first
+func ListCheckpoints(populationFile string) ([]string, error) {
+	checkpoints, err := filepath.Glob(populationFile + ".checkpoint-*")
+	if err != nil {
+		return nil, err
+	}
+	// Sort by iteration, which can outgrow the zero padding. Files that
+	// don't end in an iteration aren't checkpoints.
+	iterations := map[string]int{}
+	valid := checkpoints[:0]
+	for _, checkpoint := range checkpoints {
+		iteration, err := strconv.Atoi(strings.TrimPrefix(checkpoint, populationFile+".checkpoint-"))
+		if err != nil {
+			continue
+		}
+		iterations[checkpoint] = iteration
+		valid = append(valid, checkpoint)
+	}
+	sort.Slice(valid, func(i int, j int) bool {
+		return iterations[valid[i]] > iterations[valid[j]]
+	})
+	return valid, nil
+}
+
+// RotateCheckpoints deletes the oldest checkpoints of a population file,
+// keeping the specified number of checkpoints.
+func RotateCheckpoints(populationFile string, keep int) error {
+	checkpoints, err := ListCheckpoints(populationFile)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(checkpoints); i++ {
+		err = os.Remove(checkpoints[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImageHash returns a hash of the pixels of an image, so that the same image
+// has the same hash no matter which format it was loaded from.
+func ImageHash(img image.Image) string {
+	hasher := md5.New()
+	bounds := img.Bounds()
+	fmt.Fprintf(hasher, "%vx%v|", bounds.Dx(), bounds.Dy())
+	pixel := make([]byte, 4)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			clr := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			pixel[0], pixel[1], pixel[2], pixel[3] = clr.R, clr.G, clr.B, clr.A
+			hasher.Write(pixel)
+		}
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPopulationFileRoundTrip(t *testing.T) {
+	for _, encoding := range []string{EncodingText, EncodingBinary} {
+		filename := filepath.Join(t.TempDir(), "target.png.population.txt")
+		organism := testOrganism()
+		header := NewPopulationHeader(1234, 40, 30)
+		header.TargetHash = "0123456789abcdef"
+		header.Diff = 0.125
+		header.InstructionCount = len(organism.Instructions)
+		header.Config = DefaultConfig()
+		header.Config.FitnessMetric = "ssim"
+
+		err := SavePopulationFile(filename, header, organism.SaveEncoded(encoding))
+		if err != nil {
+			t.Fatal(err)
+		}
+		loadedHeader, loaded, err := LoadPopulationFile(filename)
+		if err != nil {
+			t.Fatalf("%v: %v", encoding, err.Error())
+		}
+		if loaded.Hash() != organism.Hash() {
+			t.Errorf("%v: Expected hash %v, got %v", encoding, organism.Hash(), loaded.Hash())
+		}
+		if loadedHeader.Version != PopulationFormatVersion || loadedHeader.Iteration != 1234 ||
+			loadedHeader.Width != 40 || loadedHeader.Height != 30 || loadedHeader.TargetHash != header.TargetHash ||
+			loadedHeader.Diff != 0.125 || loadedHeader.InstructionCount != len(organism.Instructions) {
+			t.Errorf("%v: Expected header %+v, got %+v", encoding, *header, *loadedHeader)
+		}
+		if loadedHeader.Config == nil || loadedHeader.Config.FitnessMetric != "ssim" {
+			t.Errorf("%v: Expected the config to be saved, got %+v", encoding, loadedHeader.Config)
+		}
+		if (loadedHeader.Encoding == EncodingBinary) != (encoding == EncodingBinary) {
+			t.Errorf("%v: Unexpected header encoding '%v'", encoding, loadedHeader.Encoding)
+		}
+	}
+}
+
+func TestLoadLegacyPopulationFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "target.png.population.txt")
+	organism := testOrganism()
+	err := ioutil.WriteFile(filename, append([]byte("1234\n"), organism.Save()...), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, loaded, err := LoadPopulationFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != legacyPopulationFormatVersion || header.Iteration != 1234 || header.HasDimensions() {
+		t.Errorf("Expected a legacy header for iteration 1234, got %+v", *header)
+	}
+	if loaded.Hash() != organism.Hash() {
+		t.Errorf("Expected hash %v, got %v", organism.Hash(), loaded.Hash())
+	}
+}
+
+func TestParseNewerPopulationHeader(t *testing.T) {
+	_, err := ParsePopulationHeader([]byte(`{"Version": 99, "Iteration": 1}`))
+	if err == nil {
+		t.Error("Expected an error parsing a header of a newer version")
+	}
+}
+
+func TestListCheckpointsSortsByIteration(t *testing.T) {
+	populationFile := filepath.Join(t.TempDir(), "target.png.population.txt")
+	for _, iteration := range []int{999, 9999999, 10000000, 123456789} {
+		err := ioutil.WriteFile(CheckpointFilename(populationFile, iteration), []byte{}, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not a checkpoint
+	err := ioutil.WriteFile(populationFile+".checkpoint-tmp", []byte{}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoints, err := ListCheckpoints(populationFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		CheckpointFilename(populationFile, 123456789),
+		CheckpointFilename(populationFile, 10000000),
+		CheckpointFilename(populationFile, 9999999),
+		CheckpointFilename(populationFile, 999),
+	}
+	if !reflect.DeepEqual(checkpoints, expected) {
+		t.Errorf("Expected %v, got %v", expected, checkpoints)
+	}
+}
+
+func TestRotateCheckpointsKeepsNewest(t *testing.T) {
+	populationFile := filepath.Join(t.TempDir(), "target.png.population.txt")
+	for _, iteration := range []int{5, 9999999, 10000001, 10000002} {
+		err := ioutil.WriteFile(CheckpointFilename(populationFile, iteration), []byte{}, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := RotateCheckpoints(populationFile, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints, err := ListCheckpoints(populationFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		CheckpointFilename(populationFile, 10000002),
+		CheckpointFilename(populationFile, 10000001),
+	}
+	if !reflect.DeepEqual(checkpoints, expected) {
+		t.Errorf("Expected %v, got %v", expected, checkpoints)
+	}
+}
@@ -3,6 +3,16 @@ package main
 import "fmt"
 
 // FormatProgress formats an average pixel diff as a progress complete percentage.
-func FormatProgress(diff float32) string {
-	return fmt.Sprintf("%.15f%%", 100.0-((diff/maxImageDiff)*100))
+// If the diff is weighted by a focus map, the similarity without weights is
+// reported separately. Diffs without weights are passed twice.
+func FormatProgress(diff float32, unweightedDiff float32) string {
+	if diff == unweightedDiff {
+		return fmt.Sprintf("%.15f%%", Similarity(diff))
+	}
+	return fmt.Sprintf("%.15f%% weighted, %.15f%% unweighted", Similarity(diff), Similarity(unweightedDiff))
+}
+
+// Similarity converts an average pixel diff to a similarity percentage
+func Similarity(diff float32) float32 {
+	return 100.0 - ((diff / maxImageDiff) * 100)
 }
@@ -0,0 +1,275 @@
+package main
+
+import "image"
+
+// pyramidKernel is a binomial approximation of a Gaussian, which blurs each
+// level of the pyramid before it is downsampled to the next one
+var pyramidKernel = [...]float32{1.0 / 16, 4.0 / 16, 6.0 / 16, 4.0 / 16, 1.0 / 16}
+
+// pyramidRadius is the radius of pyramidKernel
+const pyramidRadius = 2
+
+// pixelArea is a range of pixels (or cells of a pyramid level). right and
+// bottom are exclusive.
+type pixelArea struct {
+	left   int
+	top    int
+	right  int
+	bottom int
+}
+
+func (area pixelArea) size() int {
+	return (area.right - area.left) * (area.bottom - area.top)
+}
+
+// index returns the index of a pixel in a slice that holds the area row by row
+func (area pixelArea) index(x int, y int) int {
+	return (y-area.top)*(area.right-area.left) + x - area.left
+}
+
+func (area pixelArea) empty() bool {
+	return area.left >= area.right || area.top >= area.bottom
+}
+
+func (area pixelArea) clamp(width int, height int) pixelArea {
+	return pixelArea{maxInt(area.left, 0), maxInt(area.top, 0), minInt(area.right, width), minInt(area.bottom, height)}
+}
+
+func (area pixelArea) union(other pixelArea) pixelArea {
+	if area.empty() {
+		return other
+	}
+	if other.empty() {
+		return area
+	}
+	return pixelArea{
+		minInt(area.left, other.left), minInt(area.top, other.top),
+		maxInt(area.right, other.right), maxInt(area.bottom, other.bottom),
+	}
+}
+
+// scale converts cells of a pyramid level to the pixels (or cells) they
+// cover, levels below.
+func (area pixelArea) scale(levels uint) pixelArea {
+	return pixelArea{area.left << levels, area.top << levels, area.right << levels, area.bottom << levels}
+}
+
+// downscale returns the cells of a pyramid level, levels above, that contain
+// the pixels of an area
+func (area pixelArea) downscale(levels uint) pixelArea {
+	size := 1 << levels
+	return pixelArea{area.left >> levels, area.top >> levels, (area.right + size - 1) >> levels, (area.bottom + size - 1) >> levels}
+}
+
+// parents returns the cells of the next level whose kernel reaches the area
+func (area pixelArea) parents() pixelArea {
+	return pixelArea{
+		floorDiv(area.left-pyramidRadius+1, 2), floorDiv(area.top-pyramidRadius+1, 2),
+		floorDiv(area.right+pyramidRadius-1, 2) + 1, floorDiv(area.bottom+pyramidRadius-1, 2) + 1,
+	}
+}
+
+// footprint returns the cells of the previous level that the kernels of the
+// area reach
+func (area pixelArea) footprint() pixelArea {
+	return pixelArea{
+		area.left*2 - pyramidRadius, area.top*2 - pyramidRadius,
+		area.right*2 + pyramidRadius - 1, area.bottom*2 + pyramidRadius - 1,
+	}
+}
+
+func floorDiv(a int, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+// labGrid holds the Lab channels of an area of a pyramid level
+type labGrid struct {
+	area pixelArea
+	l    []float32
+	a    []float32
+	b    []float32
+}
+
+func newLabGrid(area pixelArea) *labGrid {
+	return &labGrid{
+		area: area,
+		l:    make([]float32, area.size()),
+		a:    make([]float32, area.size()),
+		b:    make([]float32, area.size()),
+	}
+}
+
+// downsample blurs the grid and calculates the cells of the next level in an
+// area. Coordinates outside of the level (width x height) are clamped to its
+// border. The grid must contain the footprint of the area.
+func (grid *labGrid) downsample(area pixelArea, width int, height int) *labGrid {
+	next := newLabGrid(area)
+	for y := area.top; y < area.bottom; y++ {
+		for x := area.left; x < area.right; x++ {
+			var l, a, b float32
+			for j, wy := range pyramidKernel {
+				sy := minInt(maxInt(y*2+j-pyramidRadius, 0), height-1)
+				for i, wx := range pyramidKernel {
+					sx := minInt(maxInt(x*2+i-pyramidRadius, 0), width-1)
+					si := grid.area.index(sx, sy)
+					w := wx * wy
+					l += w * grid.l[si]
+					a += w * grid.a[si]
+					b += w * grid.b[si]
+				}
+			}
+			ni := next.area.index(x, y)
+			next.l[ni], next.a[ni], next.b[ni] = l, a, b
+		}
+	}
+	return next
+}
+
+// pyramidLevel holds a level of the target pyramid
+type pyramidLevel struct {
+	width  int
+	height int
+	labs   []*Lab
+}
+
+// newTargetPyramid builds a Gaussian pyramid from the precalculated target.
+// Each level has half the resolution of the previous one.
+func newTargetPyramid(labs [][]*Lab, levels int) []*pyramidLevel {
+	width, height := len(labs), len(labs[0])
+	grid := newLabGrid(pixelArea{0, 0, width, height})
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			i := grid.area.index(x, y)
+			grid.l[i], grid.a[i], grid.b[i] = labs[x][y].l, labs[x][y].a, labs[x][y].b
+		}
+	}
+	pyramid := []*pyramidLevel{}
+	for level := 0; level < levels; level++ {
+		if level > 0 {
+			nextWidth, nextHeight := (width+1)/2, (height+1)/2
+			grid = grid.downsample(pixelArea{0, 0, nextWidth, nextHeight}, width, height)
+			width, height = nextWidth, nextHeight
+		}
+		pyramidLevel := &pyramidLevel{width: width, height: height, labs: make([]*Lab, width*height)}
+		for i := range pyramidLevel.labs {
+			pyramidLevel.labs[i] = NewLab(grid.l[i], grid.a[i], grid.b[i])
+		}
+		pyramid = append(pyramid, pyramidLevel)
+	}
+	return pyramid
+}
+
+// pyramidAreas calculates which cells of each level need to be calculated to
+// score changes to an area of pixels. The pixels whose diffs change are
+// returned, followed by the cells of each level that are needed.
+func (ranker *Ranker) pyramidAreas(changed pixelArea) (pixelArea, []pixelArea) {
+	pyramid := ranker.targetPyramid
+	changed = changed.clamp(pyramid[0].width, pyramid[0].height)
+	if changed.empty() {
+		return changed, nil
+	}
+	// Pixels are scored by the cells that contain them on each level, so the
+	// diff of a pixel changes if any of its cells change
+	written := changed
+	affected := changed
+	for level := 1; level < len(pyramid); level++ {
+		affected = affected.parents().clamp(pyramid[level].width, pyramid[level].height)
+		written = written.union(affected.scale(uint(level)))
+	}
+	written = written.clamp(pyramid[0].width, pyramid[0].height)
+	needed := make([]pixelArea, len(pyramid))
+	for level := len(pyramid) - 1; level >= 0; level-- {
+		needed[level] = written.downscale(uint(level))
+		if level < len(pyramid)-1 {
+			needed[level] = needed[level].union(needed[level+1].footprint())
+		}
+		needed[level] = needed[level].clamp(pyramid[level].width, pyramid[level].height)
+	}
+	return written, needed
+}
+
+// pyramidDistanceBounds updates the diff map for changes in boundAreas. The
+// diff of each pixel is the weighted average of the color distances of the
+// cells that contain it on each level, so the average diff of the map is the
+// weighted average of the levels.
+func (ranker *Ranker) pyramidDistanceBounds(img image.Image, boundAreas []Rect, diffMap *DiffMap) {
+	pyramid := ranker.targetPyramid
+	var totalWeight float32
+	for _, weight := range ranker.pyramidWeights {
+		totalWeight += weight
+	}
+	// Keep a cache of color mappings for this image
+	cache := map[uint32]*Lab{}
+	for _, bounds := range boundAreas {
+		left, top, right, bottom := bounds.PixelArea(0)
+		written, needed := ranker.pyramidAreas(pixelArea{left, top, right, bottom})
+		if written.empty() {
+			continue
+		}
+		grid := newLabGrid(needed[0])
+		for y := needed[0].top; y < needed[0].bottom; y++ {
+			for x := needed[0].left; x < needed[0].right; x++ {
+				clr := img.At(x, y)
+				key := ColorKey(clr)
+				lab, has := cache[key]
+				if !has {
+					lab = ranker.getLab(clr)
+					cache[key] = lab
+				}
+				i := grid.area.index(x, y)
+				grid.l[i], grid.a[i], grid.b[i] = lab.l, lab.a, lab.b
+			}
+		}
+		// Weighted distances of the cells of each level that contain written pixels
+		cells := make([]pixelArea, len(pyramid))
+		distances := make([][]float32, len(pyramid))
+		for level := range pyramid {
+			if level > 0 {
+				grid = grid.downsample(needed[level], pyramid[level-1].width, pyramid[level-1].height)
+			}
+			cells[level] = written.downscale(uint(level))
+			distances[level] = make([]float32, cells[level].size())
+			weight := ranker.pyramidWeights[level] / totalWeight
+			for y := cells[level].top; y < cells[level].bottom; y++ {
+				for x := cells[level].left; x < cells[level].right; x++ {
+					gi := grid.area.index(x, y)
+					lab := NewLab(grid.l[gi], grid.a[gi], grid.b[gi])
+					target := pyramid[level].labs[y*pyramid[level].width+x]
+					distances[level][cells[level].index(x, y)] = weight * ranker.colorDistance(target, lab)
+				}
+			}
+		}
+		for y := written.top; y < written.bottom; y++ {
+			for x := written.left; x < written.right; x++ {
+				var diff float32
+				for level, distance := range distances {
+					diff += distance[cells[level].index(x>>uint(level), y>>uint(level))]
+				}
+				diffMap.SetDiff(x, y, diff)
+			}
+		}
+	}
+}
+
+// pyramidRenderAreas returns the areas that must be rendered to score changes
+// in boundAreas with the pyramid metric
+func (ranker *Ranker) pyramidRenderAreas(boundAreas []Rect) []Rect {
+	areas := make([]Rect, 0, len(boundAreas))
+	for _, bounds := range boundAreas {
+		left, top, right, bottom := bounds.PixelArea(0)
+		written, needed := ranker.pyramidAreas(pixelArea{left, top, right, bottom})
+		if written.empty() {
+			continue
+		}
+		areas = append(areas, Rect{
+			Left:   float32(needed[0].left),
+			Top:    float32(needed[0].top),
+			Right:  float32(needed[0].right),
+			Bottom: float32(needed[0].bottom),
+		})
+	}
+	return areas
+}
@@ -13,14 +13,23 @@ type Lab struct {
 	l float32
 	a float32
 	b float32
+	// Terms of the CIE94 and CIEDE2000 distances that only depend on
+	// this color. For target pixels they are precalculated once.
+	c  float32 // chroma
+	sc float32 // CIE94 chroma weight
+	sh float32 // CIE94 hue weight
 }
 
 // NewLab returns a new `Lab` from its components
 func NewLab(l float32, a float32, b float32) *Lab {
+	c := float32(math.Hypot(float64(a), float64(b)))
 	return &Lab{
-		l: l,
-		a: a,
-		b: b,
+		l:  l,
+		a:  a,
+		b:  b,
+		c:  c,
+		sc: 1 + cie94K1*c,
+		sh: 1 + cie94K2*c,
 	}
 }
 
@@ -29,19 +38,111 @@ type LabCacheRequest struct {
 	Color    color.Color
 }
 
+const (
+	// FitnessMetricLab compares the colors of each pixel in the Lab color space
+	FitnessMetricLab = "lab"
+	// FitnessMetricSSIM compares the structure around each pixel, using the
+	// structural similarity index (SSIM) of each color channel
+	FitnessMetricSSIM = "ssim"
+	// FitnessMetricPyramid compares the colors of a Gaussian pyramid of the
+	// images, so that large color masses count as well as details
+	FitnessMetricPyramid = "pyramid"
+)
+
 // A Ranker calculates the difference between two images
 // by comparing their pixel colors in the Lab color space
+// or their structural similarity
 type Ranker struct {
 	precalculatedImage [][]*Lab
+	metric             string
+	colorDistanceMode  string
+	edgeWeight         float32
+	pyramidWeights     []float32
+	focusMap           image.Image
+	focusWeight        float32
+	diffWeights        *DiffWeights
+	ssimTarget         *ssimTarget
+	targetGradients    *gradients
+	targetPyramid      []*pyramidLevel
 }
 
 func NewRanker() *Ranker {
 	ranker := new(Ranker)
+	ranker.metric = FitnessMetricLab
+	ranker.colorDistanceMode = ColorDistanceCIE76
+	ranker.pyramidWeights = []float32{1, 1, 1}
 	return ranker
 }
 
+// SetMetric selects the fitness metric. It must be set before the Lab colors
+// are precalculated.
+func (ranker *Ranker) SetMetric(metric string) error {
+	switch metric {
+	case FitnessMetricLab, FitnessMetricSSIM, FitnessMetricPyramid:
+		ranker.metric = metric
+		return nil
+	}
+	return fmt.Errorf("Unknown fitness metric '%v'", metric)
+}
+
+// SetColorDistance selects how the colors of pixels are compared by the lab
+// and pyramid fitness metrics
+func (ranker *Ranker) SetColorDistance(mode string) error {
+	switch mode {
+	case ColorDistanceCIE76, ColorDistanceCIE94, ColorDistanceCIEDE2000:
+		ranker.colorDistanceMode = mode
+		return nil
+	}
+	return fmt.Errorf("Unknown color distance '%v'", mode)
+}
+
+// SetEdgeWeight sets the weight of the edge term against the color term of
+// the lab fitness metric. Edges aren't compared if the weight is zero. It
+// must be set before the Lab colors are precalculated.
+func (ranker *Ranker) SetEdgeWeight(weight float32) error {
+	if weight < 0 {
+		return fmt.Errorf("Edge weight must not be negative")
+	}
+	ranker.edgeWeight = weight
+	return nil
+}
+
+// SetFocusMap makes the diffs of pixels count more where the focus map is
+// bright. Pixels at full focus count 1+weight times as much as pixels without
+// focus. It must be set before the Lab colors are precalculated.
+func (ranker *Ranker) SetFocusMap(focusMap image.Image, weight float32) error {
+	if weight < 0 {
+		return fmt.Errorf("Focus weight must not be negative")
+	}
+	ranker.focusMap = focusMap
+	ranker.focusWeight = weight
+	return nil
+}
+
+// SetPyramidWeights sets the weight of each level of the pyramid fitness
+// metric, starting at full resolution. It must be set before the Lab colors
+// are precalculated.
+func (ranker *Ranker) SetPyramidWeights(weights []float32) error {
+	if len(weights) == 0 {
+		return fmt.Errorf("At least one pyramid weight is required")
+	}
+	var total float32
+	for _, weight := range weights {
+		if weight < 0 {
+			return fmt.Errorf("Pyramid weights must not be negative")
+		}
+		total += weight
+	}
+	if total == 0 {
+		return fmt.Errorf("At least one pyramid weight must be positive")
+	}
+	ranker.pyramidWeights = weights
+	return nil
+}
+
 // PrecalculateLabs pre-calculates Lab colors for an image to avoid
-// recomputing them on each comparison
+// recomputing them on each comparison. This includes the terms of the
+// color distances that only depend on the target.
 func (ranker *Ranker) PrecalculateLabs(image image.Image) {
 	size := image.Bounds().Size()
 	ranker.precalculatedImage = make([][]*Lab, size.X)
@@ -53,6 +154,43 @@ func (ranker *Ranker) PrecalculateLabs(image image.Image) {
 		}
 		ranker.precalculatedImage[x] = column
 	}
+	ranker.diffWeights = nil
+	if ranker.focusMap != nil && ranker.focusWeight > 0 {
+		ranker.diffWeights = newFocusWeights(ranker.focusMap, ranker.focusWeight, size.X, size.Y)
+	}
+	if ranker.metric == FitnessMetricSSIM {
+		ranker.ssimTarget = newSSIMTarget(image)
+	} else if ranker.metric == FitnessMetricPyramid {
+		ranker.targetPyramid = newTargetPyramid(ranker.precalculatedImage, len(ranker.pyramidWeights))
+	} else if ranker.edgeWeight > 0 {
+		ranker.targetGradients = newTargetGradients(ranker.precalculatedImage)
+	}
+}
+
+// newFocusWeights weights the pixels of an image by the brightness (red
+// channel) of a focus map, relative to its brightest pixel
+func newFocusWeights(focusMap image.Image, weight float32, width int, height int) *DiffWeights {
+	var maxFocusValue uint32
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			r, _, _, _ := focusMap.At(x, y).RGBA()
+			if r > maxFocusValue {
+				maxFocusValue = r
+			}
+		}
+	}
+	weights := make([][]float32, width)
+	for x := 0; x < width; x++ {
+		weights[x] = make([]float32, height)
+		for y := 0; y < height; y++ {
+			weights[x][y] = 1
+			if maxFocusValue > 0 {
+				r, _, _, _ := focusMap.At(x, y).RGBA()
+				weights[x][y] += weight * float32(r) / float32(maxFocusValue)
+			}
+		}
+	}
+	return NewDiffWeights(weights)
 }
 
 func (ranker *Ranker) getLab(clr color.Color) *Lab {
@@ -62,6 +200,23 @@ func (ranker *Ranker) getLab(clr color.Color) *Lab {
 }
 
 func (ranker *Ranker) DistanceFromPrecalculatedBounds(image image.Image, boundAreas []Rect, diffMap *DiffMap) (float32, error) {
+	diffMap.SetWeights(ranker.diffWeights)
+	if ranker.metric == FitnessMetricSSIM {
+		// Changed pixels affect the SSIM of every window they are in
+		for _, bounds := range boundAreas {
+			left, top, right, bottom := bounds.PixelArea(ssimRadius)
+			ranker.ssimTarget.score(image, left, top, right, bottom, diffMap)
+		}
+		return diffMap.GetAverageDiff(), nil
+	}
+	if ranker.metric == FitnessMetricPyramid {
+		ranker.pyramidDistanceBounds(image, boundAreas, diffMap)
+		return diffMap.GetAverageDiff(), nil
+	}
+	if ranker.edgeWeight > 0 {
+		ranker.edgeDistanceBounds(image, boundAreas, diffMap)
+		return diffMap.GetAverageDiff(), nil
+	}
 	// Keep a cache of color mappings for these images
 	cache := map[uint32]*Lab{}
 	for _, bounds := range boundAreas {
@@ -98,6 +253,32 @@ func (ranker *Ranker) DistanceFromPrecalculatedBounds(image image.Image, boundAr
 	return diffMap.GetAverageDiff(), nil
 }
 
+// RenderAreas returns the areas that must be rendered to calculate the
+// distance of changes in boundAreas. SSIM windows, pyramid cells and Sobel
+// kernels around the changed pixels reach further than the changes themselves.
+func (ranker *Ranker) RenderAreas(boundAreas []Rect) []Rect {
+	var margin float32
+	if ranker.metric == FitnessMetricPyramid {
+		return ranker.pyramidRenderAreas(boundAreas)
+	} else if ranker.metric == FitnessMetricSSIM {
+		margin = ssimRadius*2 + 1
+	} else if ranker.edgeWeight > 0 {
+		margin = edgeRadius*2 + 1
+	} else {
+		return boundAreas
+	}
+	areas := make([]Rect, len(boundAreas))
+	for i, bounds := range boundAreas {
+		areas[i] = Rect{
+			Left:   bounds.Left - margin,
+			Top:    bounds.Top - margin,
+			Right:  bounds.Right + margin,
+			Bottom: bounds.Bottom + margin,
+		}
+	}
+	return areas
+}
+
 func (ranker *Ranker) DistanceFromPrecalculated(image image.Image, diffMap *DiffMap) (float32, error) {
 	bounds := []Rect{
 		Rect{
@@ -110,11 +291,21 @@ func (ranker *Ranker) DistanceFromPrecalculated(image image.Image, diffMap *Diff
 }
 
 // Distance calculates the distance between two images by comparing each pixel
-// between the images in the Lab color space.
+// between the images in the Lab color space, by their structural similarity
+// or by their pyramids. Edges are compared too if they have a weight, and
+// pixels are weighted by the focus map.
 func (ranker *Ranker) Distance(image1 image.Image, image2 image.Image) (float32, error) {
 	if image1.Bounds().Size().X != image2.Bounds().Size().X || image1.Bounds().Size().Y != image2.Bounds().Size().Y {
 		return 0, fmt.Errorf("Images are not the same size")
 	}
+	if ranker.metric != FitnessMetricLab || ranker.edgeWeight > 0 || ranker.focusMap != nil {
+		// These compare neighbouring pixels or weight them, so image1 is used
+		// as a target
+		target := *ranker
+		target.PrecalculateLabs(image1)
+		size := image1.Bounds().Size()
+		return target.DistanceFromPrecalculated(image2, NewDiffMap(size.X, size.Y))
+	}
 	// Keep a cache of color mappings for these images
 	cache := map[uint32]*Lab{}
 	var diff float32
@@ -142,8 +333,15 @@ func (ranker *Ranker) Distance(image1 image.Image, image2 image.Image) (float32,
 	return diff / count, nil
 }
 
-// Calculates the distance between two colors using the Lab color space
+// Calculates the distance between two colors using the Lab color space.
+// lab1 is the target color.
 func (ranker *Ranker) colorDistance(lab1 *Lab, lab2 *Lab) float32 {
+	switch ranker.colorDistanceMode {
+	case ColorDistanceCIE94:
+		return cie94Distance(lab1, lab2)
+	case ColorDistanceCIEDE2000:
+		return ciede2000Distance(lab1, lab2)
+	}
 	lDiff := lab2.l - lab1.l
 	lDiff = lDiff * lDiff
 	aDiff := lab2.a - lab1.a
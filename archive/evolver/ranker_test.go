@@ -0,0 +1,170 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+const (
+	rankerTestWidth  = 48
+	rankerTestHeight = 40
+)
+
+// rankerTestTarget returns a target with gradients and hard edges, so that
+// every metric has something to compare
+func rankerTestTarget() image.Image {
+	target := image.NewNRGBA(image.Rect(0, 0, rankerTestWidth, rankerTestHeight))
+	for y := 0; y < rankerTestHeight; y++ {
+		for x := 0; x < rankerTestWidth; x++ {
+			clr := color.NRGBA{uint8(x * 5), uint8(y * 6), 128, 255}
+			if x > 20 && x < 35 && y > 10 && y < 25 {
+				clr = color.NRGBA{240, 30, 20, 255}
+			}
+			target.SetNRGBA(x, y, clr)
+		}
+	}
+	return target
+}
+
+// rankerTestFocusMap is brightest in the top left corner
+func rankerTestFocusMap() image.Image {
+	focusMap := image.NewGray(image.Rect(0, 0, rankerTestWidth, rankerTestHeight))
+	for y := 0; y < rankerTestHeight; y++ {
+		for x := 0; x < rankerTestWidth; x++ {
+			focusMap.SetGray(x, y, color.Gray{uint8(255 - x*2 - y*2)})
+		}
+	}
+	return focusMap
+}
+
+func newTestRanker(t *testing.T, metric string, colorDistance string, edgeWeight float32, focusWeight float32) *Ranker {
+	t.Helper()
+	ranker := NewRanker()
+	if err := ranker.SetMetric(metric); err != nil {
+		t.Fatal(err)
+	}
+	if err := ranker.SetColorDistance(colorDistance); err != nil {
+		t.Fatal(err)
+	}
+	if err := ranker.SetEdgeWeight(edgeWeight); err != nil {
+		t.Fatal(err)
+	}
+	if err := ranker.SetPyramidWeights([]float32{1, 0.5, 2, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if focusWeight > 0 {
+		if err := ranker.SetFocusMap(rankerTestFocusMap(), focusWeight); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ranker.PrecalculateLabs(rankerTestTarget())
+	return ranker
+}
+
+// TestIncrementalDistanceEqualsFullDistance scores a series of mutations
+// only over the areas they changed, the way workers do, and checks that the
+// diff map matches a diff map of the whole image after each mutation
+func TestIncrementalDistanceEqualsFullDistance(t *testing.T) {
+	cases := []struct {
+		name          string
+		metric        string
+		colorDistance string
+		edgeWeight    float32
+		focusWeight   float32
+	}{
+		{"lab", FitnessMetricLab, ColorDistanceCIE76, 0, 0},
+		{"lab cie94", FitnessMetricLab, ColorDistanceCIE94, 0, 0},
+		{"lab ciede2000", FitnessMetricLab, ColorDistanceCIEDE2000, 0, 0},
+		{"lab edges", FitnessMetricLab, ColorDistanceCIE76, 1.5, 0},
+		{"lab focus", FitnessMetricLab, ColorDistanceCIE76, 0, 3},
+		{"ssim", FitnessMetricSSIM, ColorDistanceCIE76, 0, 0},
+		{"ssim focus", FitnessMetricSSIM, ColorDistanceCIE76, 0, 3},
+		{"pyramid", FitnessMetricPyramid, ColorDistanceCIE76, 0, 0},
+		{"pyramid ciede2000", FitnessMetricPyramid, ColorDistanceCIEDE2000, 0, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ranker := newTestRanker(t, c.metric, c.colorDistance, c.edgeWeight, c.focusWeight)
+			background := &color.NRGBA{60, 60, 60, 255}
+			instructions := []Instruction{
+				&Circle{X: 10, Y: 10, Radius: 6, Color: &color.NRGBA{200, 50, 50, 200}},
+				&Circle{X: 30, Y: 20, Radius: 8, Color: &color.NRGBA{50, 200, 50, 128}},
+				&Line{StartX: 2, StartY: 35, EndX: 45, EndY: 30, Width: 2, Color: &color.NRGBA{0, 0, 255, 255}},
+			}
+			renderer := NewRenderer(rankerTestWidth, rankerTestHeight)
+			renderer.Render(background, instructions)
+			diffMap := NewDiffMap(rankerTestWidth, rankerTestHeight)
+			ranker.DistanceFromPrecalculated(renderer.GetImage(), diffMap)
+
+			mutations := []struct {
+				name   string
+				mutate func() []Rect
+			}{
+				{"move", func() []Rect {
+					old := instructions[1].Bounds()
+					instructions[1] = &Circle{X: 26, Y: 23, Radius: 8, Color: &color.NRGBA{50, 200, 50, 128}}
+					return []Rect{old, instructions[1].Bounds()}
+				}},
+				{"append", func() []Rect {
+					instructions = append(instructions, &Circle{X: 40, Y: 8, Radius: 5, Color: &color.NRGBA{255, 255, 0, 100}})
+					return []Rect{instructions[3].Bounds()}
+				}},
+				{"append at the border", func() []Rect {
+					instructions = append(instructions, &Circle{X: 46, Y: 39, Radius: 4, Color: &color.NRGBA{255, 0, 255, 255}})
+					return []Rect{instructions[4].Bounds()}
+				}},
+				{"delete", func() []Rect {
+					old := instructions[0].Bounds()
+					instructions = instructions[1:]
+					return []Rect{old}
+				}},
+			}
+			for _, mutation := range mutations {
+				areas := mutation.mutate()
+				renderer.RenderBounds(background, instructions, ranker.RenderAreas(areas))
+				incremental, _ := ranker.DistanceFromPrecalculatedBounds(renderer.GetImage(), areas, diffMap)
+
+				full := NewRenderer(rankerTestWidth, rankerTestHeight)
+				full.Render(background, instructions)
+				fullDiffMap := NewDiffMap(rankerTestWidth, rankerTestHeight)
+				expected, _ := ranker.DistanceFromPrecalculated(full.GetImage(), fullDiffMap)
+
+				if incremental != expected {
+					t.Errorf("%v: Expected diff %v, got %v", mutation.name, expected, incremental)
+				}
+				mismatches := 0
+				for x := range fullDiffMap.Diffs {
+					for y := range fullDiffMap.Diffs[x] {
+						if diffMap.Diffs[x][y] != fullDiffMap.Diffs[x][y] {
+							mismatches++
+						}
+					}
+				}
+				if mismatches > 0 {
+					t.Errorf("%v: %v pixels differ from the full diff map", mutation.name, mismatches)
+				}
+			}
+		})
+	}
+}
+
+// TestFocusWeightsKeepAverageDiff checks that a uniform error has the same
+// weighted and unweighted diff
+func TestFocusWeightsKeepAverageDiff(t *testing.T) {
+	ranker := newTestRanker(t, FitnessMetricLab, ColorDistanceCIE76, 0, 3)
+	target := rankerTestTarget()
+	diffMap := NewDiffMap(rankerTestWidth, rankerTestHeight)
+	diffMap.SetWeights(ranker.diffWeights)
+	for x := 0; x < rankerTestWidth; x++ {
+		for y := 0; y < rankerTestHeight; y++ {
+			diffMap.SetDiff(x, y, 0.25)
+		}
+	}
+	if weighted, unweighted := diffMap.GetAverageDiff(), diffMap.GetUnweightedAverageDiff(); weighted != unweighted {
+		t.Errorf("Expected weighted diff %v to equal unweighted diff %v", weighted, unweighted)
+	}
+	if diff, _ := ranker.Distance(target, target); diff != 0 {
+		t.Errorf("Expected the target to have no diff, got %v", diff)
+	}
+}
@@ -26,6 +26,15 @@ func (rect Rect) Area(roundCoordinates bool) float32 {
 	return (right - left) * (bottom - top)
 }
 
+// PixelArea returns the left, top, right and bottom of the pixels that the
+// Rect covers, extended by a margin on each side
+func (rect Rect) PixelArea(margin int) (int, int, int, int) {
+	return int(math.Floor(float64(rect.Left))) - margin,
+		int(math.Floor(float64(rect.Top))) - margin,
+		int(math.Ceil(float64(rect.Right))) + margin + 1,
+		int(math.Ceil(float64(rect.Bottom))) + margin + 1
+}
+
 // Center returns the center of the Rect
 func (rect Rect) Center() (float32, float32) {
 	return (rect.Left + rect.Right) / 2.0, (rect.Top + rect.Bottom) / 2.0
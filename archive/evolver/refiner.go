@@ -0,0 +1,60 @@
+package main
+
+// refine hill-climbs the parameters of an instruction that was appended by
+// the last mutation of an organism, in the spirit of fogleman/primitive. Each
+// round mutates the instruction, and the change is kept if it improves the
+// diff over the bounds of the instruction. The diff map of the organism must
+// be up to date for its parent, and is kept up to date for the organism.
+// The patch and hash of the organism are updated with the refined
+// instruction.
+func (worker *Worker) refine(organism *Organism) {
+	if organism.Patch == nil || len(organism.Patch.Operations) != 1 || len(organism.Instructions) == 0 {
+		return
+	}
+	operation := &organism.Patch.Operations[0]
+	if operation.OperationType != PatchOperationAppend {
+		return
+	}
+	last := len(organism.Instructions) - 1
+	best := organism.Instructions[last]
+	instructionMut, has := worker.mutator.instructionMutatorMap[best.Type()]
+	if !has {
+		return
+	}
+	renderer := objectPool.BorrowRenderer()
+	defer objectPool.ReturnRenderer(renderer)
+	bestDiff := worker.scoreAreas(renderer, organism, organism.AffectedAreas)
+	improved := false
+	for round := 0; round < config.RefineRounds; round++ {
+		candidate := best.Clone()
+		instructionMut.MutateInstruction(candidate)
+		areas := []Rect{best.Bounds(), candidate.Bounds()}
+		organism.Instructions[last] = candidate
+		diff := worker.scoreAreas(renderer, organism, areas)
+		if diff < bestDiff {
+			objectPool.ReturnInstruction(best)
+			best, bestDiff = candidate, diff
+			improved = true
+		} else {
+			// Restore the diff map
+			organism.Instructions[last] = best
+			worker.scoreAreas(renderer, organism, areas)
+			objectPool.ReturnInstruction(candidate)
+		}
+	}
+	if !improved {
+		return
+	}
+	operation.InstructionData = best.Save()
+	organism.AffectedAreas = append(organism.AffectedAreas[:0], best.Bounds())
+	organism.hash = ""
+	organism.Patch.Target = organism.Hash()
+}
+
+// scoreAreas renders the areas of an organism that changed, and updates its
+// diff map
+func (worker *Worker) scoreAreas(renderer *Renderer, organism *Organism, areas []Rect) float32 {
+	renderer.RenderBounds(organism.Background, organism.Instructions, worker.ranker.RenderAreas(areas))
+	diff, _ := worker.ranker.DistanceFromPrecalculatedBounds(renderer.GetImage(), areas, organism.diffMap)
+	return diff
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestRefineKeepsDiffMapAndPatchConsistent(t *testing.T) {
+	setCanvasSize(rankerTestWidth, rankerTestHeight)
+	rand.Seed(1)
+	previousRounds := config.RefineRounds
+	config.RefineRounds = 50
+	defer func() { config.RefineRounds = previousRounds }()
+
+	ranker := newTestRanker(t, FitnessMetricLab, ColorDistanceCIE76, 0, 0)
+	circleType, _ := GetInstructionType(TypeCircle)
+	instructionMut := circleType.NewMutator(config, rankerTestWidth, rankerTestHeight)
+	worker := &Worker{
+		ranker:  ranker,
+		mutator: NewMutator(config, []InstructionMutator{instructionMut}, rankerTestTarget(), nil),
+	}
+
+	parent := objectPool.BorrowOrganism()
+	parent.Background = &color.NRGBA{60, 60, 60, 255}
+	parent.Instructions = append(parent.Instructions,
+		&Circle{X: 10, Y: 10, Radius: 6, Color: &color.NRGBA{200, 50, 50, 200}},
+		&Line{StartX: 2, StartY: 35, EndX: 45, EndY: 30, Width: 2, Color: &color.NRGBA{0, 0, 255, 255}},
+	)
+	renderer := NewRenderer(rankerTestWidth, rankerTestHeight)
+	renderer.Render(parent.Background, parent.Instructions)
+	parent.Diff, _ = ranker.DistanceFromPrecalculated(renderer.GetImage(), parent.diffMap)
+
+	// The appended circle is far from the red square of the target, so
+	// refining it should find something better
+	organism := parent.Clone()
+	circle := &Circle{X: 8, Y: 30, Radius: 5, Color: &color.NRGBA{240, 30, 20, 255}}
+	organism.Instructions = append(organism.Instructions, circle)
+	organism.AffectedAreas = append(organism.AffectedAreas[:0], circle.Bounds())
+	operation := PatchOperation{OperationType: PatchOperationAppend, InstructionType: TypeCircle, InstructionData: circle.Save()}
+	organism.Patch = &Patch{Baseline: parent.Hash(), Target: organism.Hash(), Operations: []PatchOperation{operation}}
+
+	worker.refine(organism)
+	if bytes.Equal(organism.Patch.Operations[0].InstructionData, operation.InstructionData) {
+		t.Fatal("Expected the appended circle to be refined")
+	}
+
+	// The diff map that was updated one area at a time matches a full score
+	renderer.Render(organism.Background, organism.Instructions)
+	diffMap := NewDiffMap(rankerTestWidth, rankerTestHeight)
+	fullDiff, _ := ranker.DistanceFromPrecalculated(renderer.GetImage(), diffMap)
+	if refinedDiff := organism.diffMap.GetAverageDiff(); math.Abs(float64(refinedDiff-fullDiff)) > 1e-4 {
+		t.Errorf("Expected the refined diff %v to equal the full diff %v", refinedDiff, fullDiff)
+	}
+	for y := 0; y < rankerTestHeight; y++ {
+		for x := 0; x < rankerTestWidth; x++ {
+			if d := organism.diffMap.GetDiff(x, y) - diffMap.GetDiff(x, y); d > 1e-3 || d < -1e-3 {
+				t.Fatalf("Expected the diff map at (%v, %v) to be %v, got %v", x, y, diffMap.GetDiff(x, y), organism.diffMap.GetDiff(x, y))
+			}
+		}
+	}
+	if fullDiff >= parent.Diff {
+		t.Errorf("Expected the refined organism to improve on the parent diff %v, got %v", parent.Diff, fullDiff)
+	}
+
+	// The rewritten patch turns the parent into the refined organism
+	patched := parent.Clone()
+	for _, operation := range organism.Patch.Operations {
+		operation.Apply(patched)
+	}
+	patched.hash = ""
+	if organism.Patch.Baseline != parent.Hash() || patched.Hash() != organism.Patch.Target || patched.Hash() != organism.Hash() {
+		t.Errorf("Expected the patch to recreate hash %v, got %v (target %v)", organism.Hash(), patched.Hash(), organism.Patch.Target)
+	}
+}
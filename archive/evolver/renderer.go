@@ -10,43 +10,78 @@ import (
 // Renderer contains the logic to render images from instructions
 type Renderer struct {
 	ctx *gg.Context
+	// pixels is the canvas of ctx, for instructions that paint directly
+	pixels *image.RGBA
+}
+
+// A rasterInstruction paints pixels onto the canvas itself, instead of
+// drawing paths with the gg context (brush strokes are sprites, for example)
+type rasterInstruction interface {
+	draw(dst *image.RGBA)
 }
 
 // NewRenderer returns a new instance of Renderer
 func NewRenderer(width int, height int) *Renderer {
 	renderer := new(Renderer)
-	renderer.ctx = gg.NewContext(width, height)
+	renderer.pixels = image.NewRGBA(image.Rect(0, 0, width, height))
+	renderer.ctx = gg.NewContextForRGBA(renderer.pixels)
 	return renderer
 }
 
-// Render will apply a set of instructions to render an image
-func (renderer *Renderer) Render(instructions []Instruction) {
-	// TODO: organism picks the background color?
-	renderer.ctx.SetColor(color.Black)
-	renderer.ctx.DrawRectangle(0, 0, float64(renderer.ctx.Width()), float64(renderer.ctx.Height()))
-	renderer.ctx.Fill()
-	for _, instruction := range instructions {
+// execute paints an instruction, using the raster path if it has one
+func (renderer *Renderer) execute(instruction Instruction) {
+	if raster, ok := instruction.(rasterInstruction); ok {
+		raster.draw(renderer.pixels)
+	} else {
 		instruction.Execute(renderer.ctx)
 	}
 }
 
+// Render will apply a set of instructions to render an image. Translucent
+// instructions are composited over the canvas using premultiplied alpha.
+// If background is nil, a black background is used.
+func (renderer *Renderer) Render(background *color.NRGBA, instructions []Instruction) {
+	renderer.fillBackground(background)
+	for _, instruction := range instructions {
+		renderer.execute(instruction)
+	}
+}
+
+// Paint draws more instructions over the current image, without filling the
+// background first. It allows an image to be rendered progressively.
+func (renderer *Renderer) Paint(instructions []Instruction) {
+	for _, instruction := range instructions {
+		renderer.execute(instruction)
+	}
+}
+
 // RenderBounds will apply a set of bounds-filtered instructions to render an image. Any instructions
 // that intersect the bounds will be rendered, all other instructions are ignored.
-func (renderer *Renderer) RenderBounds(instructions []Instruction, bounds []Rect) {
-	// TODO: organism picks the background color?
-	renderer.ctx.SetColor(color.Black)
-	renderer.ctx.DrawRectangle(0, 0, float64(renderer.ctx.Width()), float64(renderer.ctx.Height()))
-	renderer.ctx.Fill()
+// Each instruction is rendered at most once, even if it intersects multiple bounds,
+// so that translucent instructions aren't composited more than once.
+func (renderer *Renderer) RenderBounds(background *color.NRGBA, instructions []Instruction, bounds []Rect) {
+	renderer.fillBackground(background)
 	for _, instruction := range instructions {
 		for i := range bounds {
 			if instruction.Bounds().Intersects(&bounds[i]) {
-				instruction.Execute(renderer.ctx)
+				renderer.execute(instruction)
+				break
 			}
 		}
 
 	}
 }
 
+func (renderer *Renderer) fillBackground(background *color.NRGBA) {
+	if background == nil {
+		renderer.ctx.SetColor(color.Black)
+	} else {
+		renderer.ctx.SetColor(background)
+	}
+	renderer.ctx.DrawRectangle(0, 0, float64(renderer.ctx.Width()), float64(renderer.ctx.Height()))
+	renderer.ctx.Fill()
+}
+
 // GetImage returns the currently rendered image
 func (renderer *Renderer) GetImage() image.Image {
 	return renderer.ctx.Image()
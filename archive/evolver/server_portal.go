@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"image"
 	"image/png"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/gzip"
@@ -16,12 +18,22 @@ import (
 // apply incoming patch to current top organism
 // send out top organism as patch, using history as reference (along with expected hash)
 
+// serverShutdownTimeout is how long the server waits for requests in
+// progress when shutting down.
+const serverShutdownTimeout = time.Second * 10
+
+// serverDrainTime is how long the server keeps accepting patches after a
+// shutdown is requested, so that workers that are stopped at the same time
+// can submit their last patches.
+const serverDrainTime = time.Second * 3
+
 // ServerPortal provides http handlers (designed for the gin framework) to
 // check out work items and submit results
 type ServerPortal struct {
 	incubator      *Incubator
 	organismCache  *PatchCache
 	patchProcessor *PatchProcessor
+	server         *http.Server
 
 	// communication channels
 	patchRequestChan chan *GetPatchRequest
@@ -80,24 +92,39 @@ func (handler *ServerPortal) startBackgroundRoutine() {
 
 func (handler *ServerPortal) startRequestHandler() {
 	// Http handler
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.BestCompression))
+	r.GET("/", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/plain", []byte("Service is up!"))
+	})
+	// r.GET("/work-item", handler.GetWorkItem)
+	// r.POST("/result", handler.SubmitResult)
+	r.GET("/organism/delta", handler.GetTopOrganismDelta)
+	r.GET("/organism", handler.GetTopOrganism)
+	r.GET("/organism.svg", handler.GetTopOrganismSVG)
+	r.POST("/organism", handler.SubmitOrganism)
+	r.GET("/target", handler.GetTargetImageData)
+	r.GET("/focus", handler.GetFocusImageData)
+	handler.server = &http.Server{Addr: "0.0.0.0:8000", Handler: r}
 	go func() {
-		r := gin.New()
-		r.Use(gzip.Gzip(gzip.BestCompression))
-		r.GET("/", func(ctx *gin.Context) {
-			ctx.Data(http.StatusOK, "text/plain", []byte("Service is up!"))
-		})
-		// r.GET("/work-item", handler.GetWorkItem)
-		// r.POST("/result", handler.SubmitResult)
-		r.GET("/organism/delta", handler.GetTopOrganismDelta)
-		r.GET("/organism", handler.GetTopOrganism)
-		r.POST("/organism", handler.SubmitOrganism)
-		r.GET("/target", handler.GetTargetImageData)
-		r.GET("/focus", handler.GetFocusImageData)
-		http.ListenAndServe("0.0.0.0:8000", r)
+		err := handler.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("Error running http server: %v", err.Error())
+		}
 	}()
 	time.Sleep(time.Millisecond * 100)
 }
 
+// Stop stops accepting requests, and waits for requests in progress to finish
+func (handler *ServerPortal) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	err := handler.server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Error stopping http server: %v", err.Error())
+	}
+}
+
 // Update makes sure that the current top organism is cached.
 func (handler *ServerPortal) Update() {
 	callback := make(chan bool)
@@ -125,6 +152,9 @@ func (handler *ServerPortal) GetTopOrganism(ctx *gin.Context) {
 	topOrganism := handler.incubator.GetTopOrganism()
 	if hashOnly {
 		ctx.Data(http.StatusOK, "text/plain", []byte(topOrganism.Hash()))
+	} else if acceptsBinary(ctx) {
+		ctx.Data(http.StatusOK, BinaryContentType, topOrganism.SaveBinary())
+		log.Printf("GetTopOrganism: exported top organism '%v'", topOrganism.Hash())
 	} else {
 		// TODO: change to SaveV2 at some point
 		ctx.Data(http.StatusOK, "application/binary", topOrganism.Save())
@@ -133,6 +163,18 @@ func (handler *ServerPortal) GetTopOrganism(ctx *gin.Context) {
 	objectPool.ReturnOrganism(topOrganism)
 }
 
+// GetTopOrganismSVG renders the current top organism as an svg image
+func (handler *ServerPortal) GetTopOrganismSVG(ctx *gin.Context) {
+	topOrganism := handler.incubator.GetTopOrganism()
+	bounds := topOrganism.CanvasBounds()
+	data, skipped := RenderSVG(int(bounds.Right), int(bounds.Bottom), topOrganism.Background, topOrganism.Instructions)
+	if skipped > 0 {
+		log.Printf("GetTopOrganismSVG: left out %v of %v instructions that have no svg form", skipped, len(topOrganism.Instructions))
+	}
+	objectPool.ReturnOrganism(topOrganism)
+	ctx.Data(http.StatusOK, "image/svg+xml", data)
+}
+
 func (handler *ServerPortal) GetTopOrganismDelta(ctx *gin.Context) {
 
 	previous := ctx.Query("previous")
@@ -180,8 +222,21 @@ func (handler *ServerPortal) GetTopOrganismDelta(ctx *gin.Context) {
 		return
 	}
 
+	// Cached patches can hold operations in either encoding, since workers
+	// submit patches in the encoding they prefer
+	encoding := EncodingText
+	if acceptsBinary(ctx) {
+		encoding = EncodingBinary
+	}
+	encoded, err := patch.Encode(encoding)
+	if err != nil {
+		log.Printf("GetTopOrganismDelta: error encoding patch: %v", err.Error())
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer objectPool.ReturnPatch(encoded)
 	log.Printf("GetTopOrganismDelta: Sending %v -> %v, %v operations", previous, topOrganism.Hash(), len(patch.Operations))
-	ctx.JSON(http.StatusOK, patch)
+	ctx.JSON(http.StatusOK, encoded)
 }
 
 func (handler *ServerPortal) SubmitOrganism(ctx *gin.Context) {
@@ -197,6 +252,12 @@ func (handler *ServerPortal) SubmitOrganism(ctx *gin.Context) {
 	handler.incubator.SubmitPatch(patch)
 }
 
+// acceptsBinary checks if the client can receive binary encoded organisms.
+// Older workers don't send the header, so they keep getting the text encoding.
+func acceptsBinary(ctx *gin.Context) bool {
+	return strings.Contains(ctx.GetHeader("Accept"), BinaryContentType)
+}
+
 // GetPatchRequest is a request to get a combined Patch that will transform
 // the baseline organism into the target organism
 type GetPatchRequest struct {
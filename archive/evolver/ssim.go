@@ -0,0 +1,195 @@
+package main
+
+import "image"
+
+// ssimRadius is the radius of the SSIM window, which is 7x7 pixels
+const ssimRadius = 3
+
+// Stabilizing constants from the SSIM paper, for 8 bit channels
+const (
+	ssimC1 = (0.01 * 255) * (0.01 * 255)
+	ssimC2 = (0.03 * 255) * (0.03 * 255)
+)
+
+// ssimTarget holds the target image and its window statistics, which never
+// change while an image is evolved.
+type ssimTarget struct {
+	width  int
+	height int
+	// pixels, mean and variance hold three channels (r, g, b) per pixel,
+	// indexed by (y*width+x)*3+channel
+	pixels   []float64
+	mean     []float64
+	variance []float64
+}
+
+// newSSIMTarget precalculates the window statistics of a target image
+func newSSIMTarget(img image.Image) *ssimTarget {
+	size := img.Bounds().Size()
+	target := &ssimTarget{
+		width:    size.X,
+		height:   size.Y,
+		pixels:   make([]float64, size.X*size.Y*3),
+		mean:     make([]float64, size.X*size.Y*3),
+		variance: make([]float64, size.X*size.Y*3),
+	}
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			r, g, b := pixelRGB(img, x, y)
+			i := (y*size.X + x) * 3
+			target.pixels[i], target.pixels[i+1], target.pixels[i+2] = r, g, b
+		}
+	}
+	sums := newSSIMSums(size.X, size.Y)
+	for channel := 0; channel < 3; channel++ {
+		sums.accumulate(0, 0, func(x int, y int) (float64, float64) {
+			value := target.pixels[(y*size.X+x)*3+channel]
+			return value, value * value
+		})
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				left, top, right, bottom := target.window(x, y)
+				n := float64((right - left) * (bottom - top))
+				sum, sumSquares := sums.window(left, top, right, bottom)
+				mean := sum / n
+				i := (y*size.X+x)*3 + channel
+				target.mean[i] = mean
+				target.variance[i] = sumSquares/n - mean*mean
+			}
+		}
+	}
+	return target
+}
+
+// window returns the window around a pixel, clipped to the image
+func (target *ssimTarget) window(x int, y int) (int, int, int, int) {
+	return maxInt(x-ssimRadius, 0), maxInt(y-ssimRadius, 0),
+		minInt(x+ssimRadius+1, target.width), minInt(y+ssimRadius+1, target.height)
+}
+
+// score updates the diff map with the dissimilarity of each pixel in an area
+// of the image. The SSIM of a pixel depends on all pixels in its window, so
+// callers must add a margin of ssimRadius around changed pixels.
+func (target *ssimTarget) score(img image.Image, left int, top int, right int, bottom int, diffMap *DiffMap) {
+	left, top = maxInt(left, 0), maxInt(top, 0)
+	right, bottom = minInt(right, target.width), minInt(bottom, target.height)
+	if left >= right || top >= bottom {
+		return
+	}
+	// Pixels of the image that the windows in the area cover
+	windowLeft, windowTop := maxInt(left-ssimRadius, 0), maxInt(top-ssimRadius, 0)
+	windowRight, windowBottom := minInt(right+ssimRadius, target.width), minInt(bottom+ssimRadius, target.height)
+	width, height := windowRight-windowLeft, windowBottom-windowTop
+	pixels := make([]float64, width*height*3)
+	for y := windowTop; y < windowBottom; y++ {
+		for x := windowLeft; x < windowRight; x++ {
+			r, g, b := pixelRGB(img, x, y)
+			i := ((y-windowTop)*width + (x - windowLeft)) * 3
+			pixels[i], pixels[i+1], pixels[i+2] = r, g, b
+		}
+	}
+	similarity := make([]float64, (right-left)*(bottom-top))
+	sums := newSSIMSums(width, height)
+	crossSums := newSSIMSums(width, height)
+	for channel := 0; channel < 3; channel++ {
+		sums.accumulate(windowLeft, windowTop, func(x int, y int) (float64, float64) {
+			value := pixels[((y-windowTop)*width+(x-windowLeft))*3+channel]
+			return value, value * value
+		})
+		crossSums.accumulate(windowLeft, windowTop, func(x int, y int) (float64, float64) {
+			value := pixels[((y-windowTop)*width+(x-windowLeft))*3+channel]
+			return value * target.pixels[(y*target.width+x)*3+channel], 0
+		})
+		for y := top; y < bottom; y++ {
+			for x := left; x < right; x++ {
+				wl, wt, wr, wb := target.window(x, y)
+				n := float64((wr - wl) * (wb - wt))
+				sum, sumSquares := sums.window(wl-windowLeft, wt-windowTop, wr-windowLeft, wb-windowTop)
+				crossSum, _ := crossSums.window(wl-windowLeft, wt-windowTop, wr-windowLeft, wb-windowTop)
+				i := (y*target.width+x)*3 + channel
+				targetMean, targetVariance := target.mean[i], target.variance[i]
+				mean := sum / n
+				variance := sumSquares/n - mean*mean
+				covariance := crossSum/n - mean*targetMean
+				similarity[(y-top)*(right-left)+(x-left)] +=
+					((2*mean*targetMean + ssimC1) * (2*covariance + ssimC2)) /
+						((mean*mean + targetMean*targetMean + ssimC1) * (variance + targetVariance + ssimC2))
+			}
+		}
+	}
+	for y := top; y < bottom; y++ {
+		for x := left; x < right; x++ {
+			// Scaled so that the similarity percentage matches the SSIM
+			ssim := similarity[(y-top)*(right-left)+(x-left)] / 3
+			diffMap.SetDiff(x, y, float32((1-ssim)*maxImageDiff))
+		}
+	}
+}
+
+// ssimSums are summed-area tables of values and squared values, so that
+// sums over any window can be looked up in constant time.
+type ssimSums struct {
+	width   int
+	sums    []float64
+	squares []float64
+}
+
+func newSSIMSums(width int, height int) *ssimSums {
+	return &ssimSums{
+		width:   width,
+		sums:    make([]float64, (width+1)*(height+1)),
+		squares: make([]float64, (width+1)*(height+1)),
+	}
+}
+
+// accumulate fills the tables with the values returned by value, for pixels
+// starting at offsetX and offsetY.
+func (s *ssimSums) accumulate(offsetX int, offsetY int, value func(x int, y int) (float64, float64)) {
+	stride := s.width + 1
+	height := len(s.sums)/stride - 1
+	for y := 0; y < height; y++ {
+		var rowSum, rowSquares float64
+		for x := 0; x < s.width; x++ {
+			v, square := value(x+offsetX, y+offsetY)
+			rowSum += v
+			rowSquares += square
+			i := (y+1)*stride + x + 1
+			s.sums[i] = s.sums[i-stride] + rowSum
+			s.squares[i] = s.squares[i-stride] + rowSquares
+		}
+	}
+}
+
+// window returns the sums of a window in table coordinates
+func (s *ssimSums) window(left int, top int, right int, bottom int) (float64, float64) {
+	stride := s.width + 1
+	a, b, c, d := top*stride+left, top*stride+right, bottom*stride+left, bottom*stride+right
+	return s.sums[d] - s.sums[b] - s.sums[c] + s.sums[a],
+		s.squares[d] - s.squares[b] - s.squares[c] + s.squares[a]
+}
+
+// pixelRGB returns the 8 bit color channels of a pixel. Rendered images are
+// read directly, which is a lot faster than image.At.
+func pixelRGB(img image.Image, x int, y int) (float64, float64, float64) {
+	if rgba, ok := img.(*image.RGBA); ok {
+		i := rgba.PixOffset(x+rgba.Rect.Min.X, y+rgba.Rect.Min.Y)
+		return float64(rgba.Pix[i]), float64(rgba.Pix[i+1]), float64(rgba.Pix[i+2])
+	}
+	bounds := img.Bounds()
+	r, g, b, _ := img.At(x+bounds.Min.X, y+bounds.Min.Y).RGBA()
+	return float64(r >> 8), float64(g >> 8), float64(b >> 8)
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
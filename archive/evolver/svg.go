@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"strconv"
+)
+
+// RenderSVG creates an svg document from a set of instructions. The document
+// draws the same image as Renderer.Render, so it can be used on the web or in
+// vector tools. Instructions that have no vector form (such as brush strokes)
+// are left out, and the number of them is returned so that callers can warn.
+func RenderSVG(width int, height int, background *color.NRGBA, instructions []Instruction) ([]byte, int) {
+	skipped := 0
+	buf := &bytes.Buffer{}
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	buf.WriteString(fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" viewBox="0 0 %v %v">`+"\n",
+		width, height, width, height,
+	))
+	if background == nil {
+		background = &color.NRGBA{A: 255}
+	}
+	buf.WriteString(fmt.Sprintf(`<rect width="%v" height="%v" %v/>`+"\n", width, height, svgPaint("fill", background)))
+	for _, instruction := range instructions {
+		element := instruction.SVG()
+		if element == "" {
+			skipped++
+			continue
+		}
+		buf.WriteString(element)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes(), skipped
+}
+
+// svgPaint returns the svg attributes for painting with a color. Opacity is
+// written as a separate attribute, since not all vector tools understand
+// #rrggbbaa colors.
+func svgPaint(attribute string, clr *color.NRGBA) string {
+	paint := fmt.Sprintf(`%v="#%02x%02x%02x"`, attribute, clr.R, clr.G, clr.B)
+	if clr.A != 255 {
+		paint += fmt.Sprintf(` %v-opacity="%v"`, attribute, svgNumber(float32(clr.A)/255.0))
+	}
+	return paint
+}
+
+// svgNumber formats a number as compactly as possible for svg output
+func svgNumber(value float32) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
@@ -0,0 +1,1088 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxCurveSegments limits how many straight segments a single curve or arc is
+// flattened into. Polygons with a handful of points evolve much better than
+// polygons with hundreds of points, so curves are flattened coarsely.
+const maxCurveSegments = 16
+
+// curveSegmentLength is the approximate length (in pixels) of each segment
+// when a curve is flattened.
+const curveSegmentLength = 8.0
+
+// svgContainers are elements that are never rendered directly. Shapes
+// inside of them are ignored.
+var svgContainers = map[string]bool{
+	"defs":     true,
+	"clipPath": true,
+	"mask":     true,
+	"marker":   true,
+	"pattern":  true,
+	"symbol":   true,
+}
+
+// ImportSVG converts the shapes in an svg document into instructions. The
+// shapes are rescaled from the svg viewport to the specified dimensions.
+// Filled shapes become polygons and circles, and stroked outlines become
+// lines. If the document starts with an opaque rectangle that covers the
+// whole canvas, it becomes the background of the organism.
+func ImportSVG(data []byte, width int, height int) (*Organism, error) {
+	importer := &svgImporter{
+		width:             float64(width),
+		height:            float64(height),
+		unsupportedPaints: map[string]bool{},
+	}
+	err := importer.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if importer.splitShapes > 0 {
+		log.Printf("Split %v shapes into convex polygons, since they aren't star-shaped or have more than %v points", importer.splitShapes, config.MaxPolygonPoints)
+	}
+	organism := &Organism{
+		Instructions: importer.instructions,
+		Background:   importer.background,
+	}
+	return organism, nil
+}
+
+type svgImporter struct {
+	width        float64
+	height       float64
+	instructions []Instruction
+	background   *color.NRGBA
+	// unsupportedPaints keeps track of paints that were warned about
+	unsupportedPaints map[string]bool
+	// splitShapes counts the shapes that were split into several polygons
+	splitShapes int
+}
+
+// svgMatrix is an affine transform in the svg order (a b c d e f)
+type svgMatrix [6]float64
+
+var svgIdentity = svgMatrix{1, 0, 0, 1, 0, 0}
+
+// Multiply returns a transform that applies other first, then m
+func (m svgMatrix) Multiply(other svgMatrix) svgMatrix {
+	return svgMatrix{
+		m[0]*other[0] + m[2]*other[1],
+		m[1]*other[0] + m[3]*other[1],
+		m[0]*other[2] + m[2]*other[3],
+		m[1]*other[2] + m[3]*other[3],
+		m[0]*other[4] + m[2]*other[5] + m[4],
+		m[1]*other[4] + m[3]*other[5] + m[5],
+	}
+}
+
+// Apply transforms a point
+func (m svgMatrix) Apply(x float64, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+// Scale returns the average amount that lengths are scaled by the transform
+func (m svgMatrix) Scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+// svgStyle is the inherited state of an svg element
+type svgStyle struct {
+	transform     svgMatrix
+	fill          string
+	stroke        string
+	strokeWidth   float64
+	fillOpacity   float64
+	strokeOpacity float64
+	opacity       float64
+	hidden        bool
+}
+
+func (importer *svgImporter) parse(data []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	styles := []svgStyle{{
+		transform:     svgIdentity,
+		fill:          "black",
+		stroke:        "none",
+		strokeWidth:   1,
+		fillOpacity:   1,
+		strokeOpacity: 1,
+		opacity:       1,
+	}}
+	foundRoot := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			attrs := svgAttributes(element)
+			style := styles[len(styles)-1]
+			if element.Name.Local == "svg" && !foundRoot {
+				foundRoot = true
+				style.transform = importer.viewportTransform(attrs)
+			}
+			style = style.inherit(attrs)
+			if svgContainers[element.Name.Local] {
+				style.hidden = true
+			}
+			styles = append(styles, style)
+			if !style.hidden {
+				err = importer.importElement(element.Name.Local, attrs, &style)
+				if err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			if len(styles) > 1 {
+				styles = styles[:len(styles)-1]
+			}
+		}
+	}
+	if !foundRoot {
+		return fmt.Errorf("No svg element found in document")
+	}
+	return nil
+}
+
+// viewportTransform maps the coordinate system of the svg document onto the target dimensions
+func (importer *svgImporter) viewportTransform(attrs map[string]string) svgMatrix {
+	minX, minY := 0.0, 0.0
+	width, height := 0.0, 0.0
+	// percentages are relative to the page, which doesn't help here
+	if !strings.HasSuffix(attrs["width"], "%") && !strings.HasSuffix(attrs["height"], "%") {
+		width, height = parseSVGLength(attrs["width"]), parseSVGLength(attrs["height"])
+	}
+	if viewBox := parseSVGNumbers(attrs["viewBox"]); len(viewBox) == 4 {
+		minX, minY, width, height = viewBox[0], viewBox[1], viewBox[2], viewBox[3]
+	}
+	if width <= 0 || height <= 0 {
+		// No size available, assume the svg is the same size as the target
+		width, height = importer.width, importer.height
+	}
+	return svgMatrix{importer.width / width, 0, 0, importer.height / height, 0, 0}.
+		Multiply(svgMatrix{1, 0, 0, 1, -minX, -minY})
+}
+
+// svgAttributes collects the attributes of an element. Properties in
+// the style attribute take precedence over presentation attributes.
+func svgAttributes(element xml.StartElement) map[string]string {
+	attrs := map[string]string{}
+	for _, attr := range element.Attr {
+		attrs[attr.Name.Local] = strings.TrimSpace(attr.Value)
+	}
+	for _, property := range strings.Split(attrs["style"], ";") {
+		parts := strings.SplitN(property, ":", 2)
+		if len(parts) == 2 {
+			attrs[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return attrs
+}
+
+func (style svgStyle) inherit(attrs map[string]string) svgStyle {
+	if transform, has := attrs["transform"]; has {
+		style.transform = style.transform.Multiply(parseSVGTransform(transform))
+	}
+	if fill, has := attrs["fill"]; has {
+		style.fill = fill
+	}
+	if stroke, has := attrs["stroke"]; has {
+		style.stroke = stroke
+	}
+	if strokeWidth, has := attrs["stroke-width"]; has {
+		style.strokeWidth = parseSVGLength(strokeWidth)
+	}
+	if fillOpacity, has := attrs["fill-opacity"]; has {
+		style.fillOpacity = parseSVGLength(fillOpacity)
+	}
+	if strokeOpacity, has := attrs["stroke-opacity"]; has {
+		style.strokeOpacity = parseSVGLength(strokeOpacity)
+	}
+	// Group opacity is approximated by applying it to each shape
+	if opacity, has := attrs["opacity"]; has {
+		style.opacity *= parseSVGLength(opacity)
+	}
+	if attrs["display"] == "none" || attrs["visibility"] == "hidden" {
+		style.hidden = true
+	}
+	return style
+}
+
+// fillColor returns the color to fill shapes with, or nil if shapes aren't filled
+func (importer *svgImporter) fillColor(style *svgStyle) *color.NRGBA {
+	return importer.paint(style.fill, style.fillOpacity*style.opacity)
+}
+
+// strokeColor returns the color to stroke outlines with, or nil if outlines aren't stroked
+func (importer *svgImporter) strokeColor(style *svgStyle) *color.NRGBA {
+	return importer.paint(style.stroke, style.strokeOpacity*style.opacity)
+}
+
+// paint parses an svg paint. Unsupported paints (such as gradients) are
+// skipped with a warning, the same as "none".
+func (importer *svgImporter) paint(value string, opacity float64) *color.NRGBA {
+	clr, err := parseSVGPaint(value, opacity)
+	if err != nil {
+		if !importer.unsupportedPaints[value] {
+			log.Printf("Warning: %v, shapes with this paint will be skipped", err.Error())
+			importer.unsupportedPaints[value] = true
+		}
+		return nil
+	}
+	return clr
+}
+
+func (importer *svgImporter) importElement(name string, attrs map[string]string, style *svgStyle) error {
+	var subpaths []svgSubpath
+	switch name {
+	case "circle":
+		return importer.importCircle(attrs, style)
+	case "line":
+		return importer.importLine(attrs, style)
+	case "rect":
+		return importer.importRect(attrs, style)
+	case "ellipse":
+		cx, cy := parseSVGLength(attrs["cx"]), parseSVGLength(attrs["cy"])
+		rx, ry := parseSVGLength(attrs["rx"]), parseSVGLength(attrs["ry"])
+		subpaths = []svgSubpath{makeEllipse(cx, cy, rx, ry, style.transform.Scale())}
+	case "polygon", "polyline":
+		numbers := parseSVGNumbers(attrs["points"])
+		subpath := svgSubpath{closed: name == "polygon"}
+		for i := 0; i+1 < len(numbers); i += 2 {
+			subpath.points = append(subpath.points, svgPoint{numbers[i], numbers[i+1]})
+		}
+		subpaths = []svgSubpath{subpath}
+	case "path":
+		var err error
+		subpaths, err = parseSVGPath(attrs["d"], style.transform.Scale())
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	importer.addSubpaths(subpaths, style)
+	return nil
+}
+
+func (importer *svgImporter) importCircle(attrs map[string]string, style *svgStyle) error {
+	cx, cy, r := parseSVGLength(attrs["cx"]), parseSVGLength(attrs["cy"]), parseSVGLength(attrs["r"])
+	if r <= 0 {
+		return nil
+	}
+	if fill := importer.fillColor(style); fill != nil {
+		x, y := style.transform.Apply(cx, cy)
+		circle := objectPool.BorrowInstruction(TypeCircle).(*Circle)
+		circle.X = float32(x)
+		circle.Y = float32(y)
+		circle.Radius = float32(r * style.transform.Scale())
+		circle.Color = fill
+		importer.addInstruction(circle)
+	}
+	// Only the outline needs to be added, the circle has already been filled
+	outline := makeEllipse(cx, cy, r, r, style.transform.Scale())
+	importer.addOutlines([]svgSubpath{outline}, style)
+	return nil
+}
+
+func (importer *svgImporter) importLine(attrs map[string]string, style *svgStyle) error {
+	subpath := svgSubpath{points: []svgPoint{
+		{parseSVGLength(attrs["x1"]), parseSVGLength(attrs["y1"])},
+		{parseSVGLength(attrs["x2"]), parseSVGLength(attrs["y2"])},
+	}}
+	importer.addOutlines([]svgSubpath{subpath}, style)
+	return nil
+}
+
+func (importer *svgImporter) importRect(attrs map[string]string, style *svgStyle) error {
+	x, y := parseSVGLength(attrs["x"]), parseSVGLength(attrs["y"])
+	width, height := parseSVGLength(attrs["width"]), parseSVGLength(attrs["height"])
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+	// Rounded corners are ignored
+	subpath := svgSubpath{
+		points: []svgPoint{{x, y}, {x + width, y}, {x + width, y + height}, {x, y + height}},
+		closed: true,
+	}
+	if importer.isBackground(subpath, style) {
+		importer.background = importer.fillColor(style)
+		importer.addOutlines([]svgSubpath{subpath}, style)
+	} else {
+		importer.addSubpaths([]svgSubpath{subpath}, style)
+	}
+	return nil
+}
+
+// isBackground checks if a rectangle is an opaque fill of the entire canvas
+// that occurs before any other shapes.
+func (importer *svgImporter) isBackground(rect svgSubpath, style *svgStyle) bool {
+	if len(importer.instructions) > 0 || importer.background != nil {
+		return false
+	}
+	fill := importer.fillColor(style)
+	if fill == nil || fill.A != 255 {
+		return false
+	}
+	// only axis-aligned rectangles can be tested this easily
+	if style.transform[1] != 0 || style.transform[2] != 0 {
+		return false
+	}
+	left, top := style.transform.Apply(rect.points[0].X, rect.points[0].Y)
+	right, bottom := style.transform.Apply(rect.points[2].X, rect.points[2].Y)
+	left, right = math.Min(left, right), math.Max(left, right)
+	top, bottom = math.Min(top, bottom), math.Max(top, bottom)
+	// allow for rounding errors
+	return left <= 0.5 && top <= 0.5 && right >= importer.width-0.5 && bottom >= importer.height-0.5
+}
+
+// addSubpaths fills and strokes a list of subpaths
+func (importer *svgImporter) addSubpaths(subpaths []svgSubpath, style *svgStyle) {
+	if fill := importer.fillColor(style); fill != nil {
+		for _, subpath := range subpaths {
+			importer.addPolygon(subpath, style.transform, fill)
+		}
+	}
+	importer.addOutlines(subpaths, style)
+}
+
+// addOutlines strokes a list of subpaths with lines
+func (importer *svgImporter) addOutlines(subpaths []svgSubpath, style *svgStyle) {
+	stroke := importer.strokeColor(style)
+	width := style.strokeWidth * style.transform.Scale()
+	if stroke == nil || width <= 0 {
+		return
+	}
+	for _, subpath := range subpaths {
+		points := subpath.points
+		if subpath.closed && len(points) > 2 {
+			points = append(points[:len(points):len(points)], points[0])
+		}
+		for i := 1; i < len(points); i++ {
+			startX, startY := style.transform.Apply(points[i-1].X, points[i-1].Y)
+			endX, endY := style.transform.Apply(points[i].X, points[i].Y)
+			line := objectPool.BorrowInstruction(TypeLine).(*Line)
+			line.StartX = float32(startX)
+			line.StartY = float32(startY)
+			line.EndX = float32(endX)
+			line.EndY = float32(endY)
+			line.Width = float32(width)
+			// each line needs its own copy of the color
+			lineColor := *stroke
+			line.Color = &lineColor
+			importer.addInstruction(line)
+		}
+	}
+}
+
+// addPolygon converts a list of points into polygons. Mutations keep the
+// points of polygons sorted by their angle around the center, so shapes that
+// aren't star-shaped around their center, or that have more points than
+// config.MaxPolygonPoints, are split into convex pieces.
+func (importer *svgImporter) addPolygon(subpath svgSubpath, transform svgMatrix, fill *color.NRGBA) {
+	points := []svgPoint{}
+	for _, point := range subpath.points {
+		x, y := transform.Apply(point.X, point.Y)
+		if len(points) == 0 || points[len(points)-1] != (svgPoint{x, y}) {
+			points = append(points, svgPoint{x, y})
+		}
+	}
+	// The polygon is always closed, a repeated starting point isn't needed
+	if len(points) > 1 && points[0] == points[len(points)-1] {
+		points = points[:len(points)-1]
+	}
+	if len(points) < 3 {
+		return
+	}
+	maxPoints := maxInt(config.MaxPolygonPoints, 3)
+	pieces := [][]svgPoint{points}
+	if len(points) > maxPoints || !isStarShaped(points) {
+		pieces = splitConvex(points, maxPoints)
+		importer.splitShapes++
+	}
+	for _, piece := range pieces {
+		centerX, centerY := svgCenter(piece)
+		polygon := objectPool.BorrowInstruction(TypePolygon).(*Polygon)
+		polygon.X = float32(centerX)
+		polygon.Y = float32(centerY)
+		for _, point := range piece {
+			angle := math.Atan2(point.Y-centerY, point.X-centerX)
+			if angle < 0 {
+				angle += math.Pi * 2
+			}
+			polygon.Points = append(polygon.Points, Polypoint{
+				Distance: float32(math.Hypot(point.X-centerX, point.Y-centerY)),
+				Angle:    float32(angle),
+			})
+		}
+		sort.Sort(PolypointList(polygon.Points))
+		fillColor := *fill
+		polygon.Color = &fillColor
+		importer.addInstruction(polygon)
+	}
+}
+
+// svgCenter returns the mean of a list of points
+func svgCenter(points []svgPoint) (float64, float64) {
+	centerX, centerY := 0.0, 0.0
+	for _, point := range points {
+		centerX += point.X
+		centerY += point.Y
+	}
+	return centerX / float64(len(points)), centerY / float64(len(points))
+}
+
+// isStarShaped checks if a polygon goes around its center exactly once, with
+// the angles of its points always increasing (or always decreasing). Sorting
+// the points of such a polygon by their angle doesn't change its shape.
+func isStarShaped(points []svgPoint) bool {
+	centerX, centerY := svgCenter(points)
+	var total float64
+	sign := 0.0
+	for i, point := range points {
+		next := points[(i+1)%len(points)]
+		delta := math.Atan2(next.Y-centerY, next.X-centerX) - math.Atan2(point.Y-centerY, point.X-centerX)
+		if delta > math.Pi {
+			delta -= math.Pi * 2
+		} else if delta <= -math.Pi {
+			delta += math.Pi * 2
+		}
+		if delta == 0 || delta*sign < 0 {
+			return false
+		}
+		sign = delta
+		total += delta
+	}
+	return math.Abs(math.Abs(total)-math.Pi*2) < 1e-6
+}
+
+// svgCross returns the cross product of the edges a-b and b-c. Its sign
+// tells if the corner at b turns left or right.
+func svgCross(a svgPoint, b svgPoint, c svgPoint) float64 {
+	return (b.X-a.X)*(c.Y-b.Y) - (b.Y-a.Y)*(c.X-b.X)
+}
+
+// splitConvex splits a polygon into convex pieces of at most maxPoints
+// points. The polygon is triangulated by ear clipping, and neighbouring
+// pieces are merged as long as they stay convex (Hertel-Mehlhorn).
+// Self-intersecting polygons are split into approximate pieces.
+func splitConvex(points []svgPoint, maxPoints int) [][]svgPoint {
+	var area float64
+	for i, point := range points {
+		next := points[(i+1)%len(points)]
+		area += point.X*next.Y - next.X*point.Y
+	}
+	if area == 0 {
+		return nil
+	}
+	// orientation is positive if the corners of a convex polygon have a
+	// positive cross product
+	orientation := math.Copysign(1, area)
+	convex := func(piece []int, i int) bool {
+		a, b, c := piece[(i+len(piece)-1)%len(piece)], piece[i], piece[(i+1)%len(piece)]
+		return svgCross(points[a], points[b], points[c])*orientation > 0
+	}
+
+	// Ear clipping
+	pieces := [][]int{}
+	remaining := make([]int, len(points))
+	for i := range remaining {
+		remaining[i] = i
+	}
+	for len(remaining) > 3 {
+		ear := -1
+		for i := range remaining {
+			if convex(remaining, i) && !containsPoint(points, remaining, i) {
+				ear = i
+				break
+			}
+		}
+		if ear < 0 {
+			// Self-intersecting polygons can run out of ears
+			ear = 0
+		}
+		if convex(remaining, ear) {
+			pieces = append(pieces, []int{
+				remaining[(ear+len(remaining)-1)%len(remaining)],
+				remaining[ear],
+				remaining[(ear+1)%len(remaining)],
+			})
+		}
+		remaining = append(remaining[:ear:ear], remaining[ear+1:]...)
+	}
+	if convex(remaining, 1) {
+		pieces = append(pieces, remaining)
+	}
+
+	// Merge pieces that share an edge
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(pieces) && !merged; i++ {
+			for j := i + 1; j < len(pieces) && !merged; j++ {
+				piece := mergePieces(pieces[i], pieces[j])
+				if piece == nil || len(piece) > maxPoints {
+					continue
+				}
+				isConvex := true
+				for k := range piece {
+					isConvex = isConvex && convex(piece, k)
+				}
+				if isConvex {
+					pieces[i] = piece
+					pieces = append(pieces[:j], pieces[j+1:]...)
+					merged = true
+				}
+			}
+		}
+	}
+
+	result := make([][]svgPoint, len(pieces))
+	for i, piece := range pieces {
+		for _, index := range piece {
+			result[i] = append(result[i], points[index])
+		}
+	}
+	return result
+}
+
+// containsPoint checks if any other corner of a polygon is inside of the
+// triangle formed by a corner and its neighbours
+func containsPoint(points []svgPoint, polygon []int, corner int) bool {
+	a := points[polygon[(corner+len(polygon)-1)%len(polygon)]]
+	b := points[polygon[corner]]
+	c := points[polygon[(corner+1)%len(polygon)]]
+	for _, index := range polygon {
+		p := points[index]
+		if p == a || p == b || p == c {
+			continue
+		}
+		d1, d2, d3 := svgCross(a, b, p), svgCross(b, c, p), svgCross(c, a, p)
+		hasNegative := d1 < 0 || d2 < 0 || d3 < 0
+		hasPositive := d1 > 0 || d2 > 0 || d3 > 0
+		if !(hasNegative && hasPositive) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePieces joins two pieces with the same orientation along an edge they
+// share. nil is returned if they don't share an edge.
+func mergePieces(p []int, q []int) []int {
+	for i := range p {
+		a, b := p[i], p[(i+1)%len(p)]
+		for j := range q {
+			if q[j] != b || q[(j+1)%len(q)] != a {
+				continue
+			}
+			// p from b around to a, then q from after a to before b
+			merged := make([]int, 0, len(p)+len(q)-2)
+			for k := 1; k <= len(p); k++ {
+				merged = append(merged, p[(i+k)%len(p)])
+			}
+			for k := 2; k < len(q); k++ {
+				merged = append(merged, q[(j+k)%len(q)])
+			}
+			return merged
+		}
+	}
+	return nil
+}
+
+func (importer *svgImporter) addInstruction(instruction Instruction) {
+	importer.instructions = append(importer.instructions, instruction)
+}
+
+// parseSVGLength parses a length or number, ignoring any units
+func parseSVGLength(value string) float64 {
+	value = strings.TrimSpace(value)
+	end := 0
+	for end < len(value) && strings.IndexByte("0123456789.-+eE", value[end]) >= 0 {
+		end++
+	}
+	number, err := strconv.ParseFloat(value[:end], 64)
+	if err != nil {
+		return 0
+	}
+	if strings.HasSuffix(value, "%") {
+		number /= 100
+	}
+	return number
+}
+
+// parseSVGNumbers parses a list of numbers separated by whitespace and/or commas
+func parseSVGNumbers(value string) []float64 {
+	scanner := &svgPathScanner{data: value}
+	numbers := []float64{}
+	for {
+		number, ok := scanner.Number()
+		if !ok {
+			return numbers
+		}
+		numbers = append(numbers, number)
+	}
+}
+
+// parseSVGTransform parses a list of transform functions
+func parseSVGTransform(value string) svgMatrix {
+	result := svgIdentity
+	for _, function := range strings.Split(value, ")") {
+		parts := strings.SplitN(function, "(", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.Trim(strings.TrimSpace(parts[0]), ",")
+		args := parseSVGNumbers(parts[1])
+		count := len(args)
+		for len(args) < 6 {
+			args = append(args, 0)
+		}
+		var transform svgMatrix
+		switch name {
+		case "matrix":
+			copy(transform[:], args[:6])
+		case "translate":
+			transform = svgMatrix{1, 0, 0, 1, args[0], args[1]}
+		case "scale":
+			sx, sy := args[0], args[1]
+			if count == 1 {
+				sy = sx
+			}
+			transform = svgMatrix{sx, 0, 0, sy, 0, 0}
+		case "rotate":
+			sin, cos := math.Sincos(args[0] * math.Pi / 180)
+			cx, cy := args[1], args[2]
+			transform = svgMatrix{1, 0, 0, 1, cx, cy}.
+				Multiply(svgMatrix{cos, sin, -sin, cos, 0, 0}).
+				Multiply(svgMatrix{1, 0, 0, 1, -cx, -cy})
+		case "skewX":
+			transform = svgMatrix{1, 0, math.Tan(args[0] * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			transform = svgMatrix{1, math.Tan(args[0] * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			continue
+		}
+		result = result.Multiply(transform)
+	}
+	return result
+}
+
+// svgNamedColors contains the basic css color keywords
+var svgNamedColors = map[string]string{
+	"black":   "#000000",
+	"silver":  "#c0c0c0",
+	"gray":    "#808080",
+	"grey":    "#808080",
+	"white":   "#ffffff",
+	"maroon":  "#800000",
+	"red":     "#ff0000",
+	"purple":  "#800080",
+	"fuchsia": "#ff00ff",
+	"magenta": "#ff00ff",
+	"green":   "#008000",
+	"lime":    "#00ff00",
+	"olive":   "#808000",
+	"yellow":  "#ffff00",
+	"navy":    "#000080",
+	"blue":    "#0000ff",
+	"teal":    "#008080",
+	"aqua":    "#00ffff",
+	"cyan":    "#00ffff",
+	"orange":  "#ffa500",
+}
+
+// parseSVGPaint parses an svg color with the specified opacity. If the
+// paint is "none", nil is returned.
+func parseSVGPaint(value string, opacity float64) (*color.NRGBA, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "none" || value == "transparent" || opacity <= 0 {
+		return nil, nil
+	}
+	if named, has := svgNamedColors[value]; has {
+		value = named
+	}
+	var clr *color.NRGBA
+	var err error
+	switch {
+	case strings.HasPrefix(value, "#") && len(value) == 4:
+		// #rgb is short for #rrggbb
+		clr, err = ParseColorHex(string([]byte{'#', value[1], value[1], value[2], value[2], value[3], value[3]}))
+	case strings.HasPrefix(value, "#") && len(value) == 7:
+		clr, err = ParseColorHex(value)
+	case strings.HasPrefix(value, "rgb(") && strings.HasSuffix(value, ")"):
+		parts := strings.Split(value[4:len(value)-1], ",")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("Unsupported svg color '%v'", value)
+		}
+		components := make([]uint8, 3)
+		for i, part := range parts {
+			component := parseSVGLength(part)
+			if strings.HasSuffix(strings.TrimSpace(part), "%") {
+				component *= 255
+			}
+			components[i] = uint8(math.Max(0, math.Min(255, math.Round(component))))
+		}
+		clr = &color.NRGBA{R: components[0], G: components[1], B: components[2], A: 255}
+	default:
+		return nil, fmt.Errorf("Unsupported svg color '%v'", value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	clr.A = uint8(math.Round(math.Min(opacity, 1) * 255))
+	if clr.A == 0 {
+		return nil, nil
+	}
+	return clr, nil
+}
+
+type svgPoint struct {
+	X float64
+	Y float64
+}
+
+// A svgSubpath is a flattened list of points in the coordinate system of the element
+type svgSubpath struct {
+	points []svgPoint
+	closed bool
+}
+
+// makeEllipse flattens an ellipse into a closed subpath
+func makeEllipse(cx float64, cy float64, rx float64, ry float64, scale float64) svgSubpath {
+	subpath := svgSubpath{closed: true}
+	if rx <= 0 || ry <= 0 {
+		return subpath
+	}
+	segments := curveSegments(math.Pi * (rx + ry) * scale)
+	for i := 0; i < segments; i++ {
+		sin, cos := math.Sincos(float64(i) * math.Pi * 2 / float64(segments))
+		subpath.points = append(subpath.points, svgPoint{cx + cos*rx, cy + sin*ry})
+	}
+	return subpath
+}
+
+// curveSegments returns the number of segments to flatten a curve of the specified length (in pixels) into
+func curveSegments(length float64) int {
+	segments := int(math.Ceil(length / curveSegmentLength))
+	if segments < 4 {
+		return 4
+	}
+	if segments > maxCurveSegments {
+		return maxCurveSegments
+	}
+	return segments
+}
+
+// svgPathScanner reads numbers and commands from path data
+type svgPathScanner struct {
+	data string
+	pos  int
+}
+
+func (scanner *svgPathScanner) skipSeparators() {
+	for scanner.pos < len(scanner.data) && strings.IndexByte(" \t\r\n,", scanner.data[scanner.pos]) >= 0 {
+		scanner.pos++
+	}
+}
+
+// Command reads a path command letter, if there is one
+func (scanner *svgPathScanner) Command() (byte, bool) {
+	scanner.skipSeparators()
+	if scanner.pos >= len(scanner.data) {
+		return 0, false
+	}
+	c := scanner.data[scanner.pos]
+	if strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) < 0 {
+		return 0, false
+	}
+	scanner.pos++
+	return c, true
+}
+
+// Number reads a number. Numbers may run together, such as "1.5.5" or "1-2".
+func (scanner *svgPathScanner) Number() (float64, bool) {
+	scanner.skipSeparators()
+	start := scanner.pos
+	pos := start
+	if pos < len(scanner.data) && (scanner.data[pos] == '-' || scanner.data[pos] == '+') {
+		pos++
+	}
+	seenDot, seenDigit := false, false
+	for pos < len(scanner.data) {
+		c := scanner.data[pos]
+		if c >= '0' && c <= '9' {
+			seenDigit = true
+		} else if c == '.' && !seenDot {
+			seenDot = true
+		} else {
+			break
+		}
+		pos++
+	}
+	if !seenDigit {
+		return 0, false
+	}
+	// exponent
+	if pos < len(scanner.data) && (scanner.data[pos] == 'e' || scanner.data[pos] == 'E') {
+		exp := pos + 1
+		if exp < len(scanner.data) && (scanner.data[exp] == '-' || scanner.data[exp] == '+') {
+			exp++
+		}
+		if exp < len(scanner.data) && scanner.data[exp] >= '0' && scanner.data[exp] <= '9' {
+			pos = exp
+			for pos < len(scanner.data) && scanner.data[pos] >= '0' && scanner.data[pos] <= '9' {
+				pos++
+			}
+		}
+	}
+	value, err := strconv.ParseFloat(scanner.data[start:pos], 64)
+	if err != nil {
+		return 0, false
+	}
+	scanner.pos = pos
+	return value, true
+}
+
+// Flag reads an arc flag, which may not be separated from the next number
+func (scanner *svgPathScanner) Flag() (bool, bool) {
+	scanner.skipSeparators()
+	if scanner.pos >= len(scanner.data) {
+		return false, false
+	}
+	c := scanner.data[scanner.pos]
+	if c != '0' && c != '1' {
+		return false, false
+	}
+	scanner.pos++
+	return c == '1', true
+}
+
+// parseSVGPath flattens svg path data into subpaths. Scale is the size of a
+// unit of the path in pixels, which determines how finely curves are flattened.
+func parseSVGPath(data string, scale float64) ([]svgSubpath, error) {
+	scanner := &svgPathScanner{data: data}
+	subpaths := []svgSubpath{}
+	var current *svgSubpath
+	var x, y, startX, startY float64
+	// The last control point is used by the smooth curve commands
+	var controlX, controlY float64
+	var command, lastCommand byte
+
+	numbers := func(count int) ([]float64, error) {
+		values := make([]float64, count)
+		for i := range values {
+			value, ok := scanner.Number()
+			if !ok {
+				return nil, fmt.Errorf("Invalid svg path data at position %v for command '%c'", scanner.pos, command)
+			}
+			values[i] = value
+		}
+		return values, nil
+	}
+	lineTo := func(toX float64, toY float64) {
+		if current == nil {
+			subpaths = append(subpaths, svgSubpath{points: []svgPoint{{x, y}}})
+			current = &subpaths[len(subpaths)-1]
+		}
+		current.points = append(current.points, svgPoint{toX, toY})
+		x, y = toX, toY
+	}
+	cubicTo := func(x1, y1, x2, y2, toX, toY float64) {
+		length := math.Hypot(x1-x, y1-y) + math.Hypot(x2-x1, y2-y1) + math.Hypot(toX-x2, toY-y2)
+		segments := curveSegments(length * scale)
+		x0, y0 := x, y
+		for i := 1; i <= segments; i++ {
+			t := float64(i) / float64(segments)
+			mt := 1 - t
+			lineTo(
+				mt*mt*mt*x0+3*mt*mt*t*x1+3*mt*t*t*x2+t*t*t*toX,
+				mt*mt*mt*y0+3*mt*mt*t*y1+3*mt*t*t*y2+t*t*t*toY,
+			)
+		}
+		controlX, controlY = x2, y2
+	}
+	quadTo := func(x1, y1, toX, toY float64) {
+		length := math.Hypot(x1-x, y1-y) + math.Hypot(toX-x1, toY-y1)
+		segments := curveSegments(length * scale)
+		x0, y0 := x, y
+		for i := 1; i <= segments; i++ {
+			t := float64(i) / float64(segments)
+			mt := 1 - t
+			lineTo(mt*mt*x0+2*mt*t*x1+t*t*toX, mt*mt*y0+2*mt*t*y1+t*t*toY)
+		}
+		controlX, controlY = x1, y1
+	}
+
+	for {
+		if c, ok := scanner.Command(); ok {
+			command = c
+		} else if scanner.pos >= len(scanner.data) {
+			break
+		} else if command == 0 {
+			return nil, fmt.Errorf("Invalid svg path data, expected a command at position %v", scanner.pos)
+		} else if command == 'Z' || command == 'z' {
+			return nil, fmt.Errorf("Invalid svg path data, expected a command at position %v", scanner.pos)
+		} else if command == 'M' || command == 'm' {
+			// additional coordinates after a move are lines
+			command = command - 'M' + 'L'
+		}
+		relative := command >= 'a'
+		offsetX, offsetY := 0.0, 0.0
+		if relative {
+			offsetX, offsetY = x, y
+		}
+		switch command {
+		case 'M', 'm':
+			values, err := numbers(2)
+			if err != nil {
+				return nil, err
+			}
+			x, y = values[0]+offsetX, values[1]+offsetY
+			startX, startY = x, y
+			current = nil
+		case 'L', 'l':
+			values, err := numbers(2)
+			if err != nil {
+				return nil, err
+			}
+			lineTo(values[0]+offsetX, values[1]+offsetY)
+		case 'H', 'h':
+			values, err := numbers(1)
+			if err != nil {
+				return nil, err
+			}
+			lineTo(values[0]+offsetX, y)
+		case 'V', 'v':
+			values, err := numbers(1)
+			if err != nil {
+				return nil, err
+			}
+			lineTo(x, values[0]+offsetY)
+		case 'C', 'c':
+			values, err := numbers(6)
+			if err != nil {
+				return nil, err
+			}
+			cubicTo(
+				values[0]+offsetX, values[1]+offsetY,
+				values[2]+offsetX, values[3]+offsetY,
+				values[4]+offsetX, values[5]+offsetY,
+			)
+		case 'S', 's':
+			values, err := numbers(4)
+			if err != nil {
+				return nil, err
+			}
+			// reflect the previous control point
+			x1, y1 := x, y
+			if strings.IndexByte("CcSs", lastCommand) >= 0 {
+				x1, y1 = 2*x-controlX, 2*y-controlY
+			}
+			cubicTo(x1, y1, values[0]+offsetX, values[1]+offsetY, values[2]+offsetX, values[3]+offsetY)
+		case 'Q', 'q':
+			values, err := numbers(4)
+			if err != nil {
+				return nil, err
+			}
+			quadTo(values[0]+offsetX, values[1]+offsetY, values[2]+offsetX, values[3]+offsetY)
+		case 'T', 't':
+			values, err := numbers(2)
+			if err != nil {
+				return nil, err
+			}
+			x1, y1 := x, y
+			if strings.IndexByte("QqTt", lastCommand) >= 0 {
+				x1, y1 = 2*x-controlX, 2*y-controlY
+			}
+			quadTo(x1, y1, values[0]+offsetX, values[1]+offsetY)
+		case 'A', 'a':
+			values, err := numbers(3)
+			if err != nil {
+				return nil, err
+			}
+			largeArc, ok1 := scanner.Flag()
+			sweep, ok2 := scanner.Flag()
+			end, err := numbers(2)
+			if err != nil || !ok1 || !ok2 {
+				return nil, fmt.Errorf("Invalid svg arc at position %v", scanner.pos)
+			}
+			for _, point := range flattenArc(x, y, values[0], values[1], values[2], largeArc, sweep, end[0]+offsetX, end[1]+offsetY, scale) {
+				lineTo(point.X, point.Y)
+			}
+		case 'Z', 'z':
+			if current != nil {
+				current.closed = true
+			}
+			x, y = startX, startY
+			current = nil
+		}
+		lastCommand = command
+	}
+	return subpaths, nil
+}
+
+// flattenArc converts an svg elliptical arc into a list of points, following
+// the endpoint to center conversion in the svg specification.
+func flattenArc(x1, y1, rx, ry, rotation float64, largeArc bool, sweep bool, x2, y2 float64, scale float64) []svgPoint {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || (x1 == x2 && y1 == y2) {
+		return []svgPoint{{x2, y2}}
+	}
+	sinPhi, cosPhi := math.Sincos(rotation * math.Pi / 180)
+	dx, dy := (x1-x2)/2, (y1-y2)/2
+	x1p := cosPhi*dx + sinPhi*dy
+	y1p := -sinPhi*dx + cosPhi*dy
+	// scale up the radii if they are too small
+	if lambda := x1p*x1p/(rx*rx) + y1p*y1p/(ry*ry); lambda > 1 {
+		rx *= math.Sqrt(lambda)
+		ry *= math.Sqrt(lambda)
+	}
+	numerator := rx*rx*ry*ry - rx*rx*y1p*y1p - ry*ry*x1p*x1p
+	denominator := rx*rx*y1p*y1p + ry*ry*x1p*x1p
+	coef := math.Sqrt(math.Max(0, numerator/denominator))
+	if largeArc == sweep {
+		coef = -coef
+	}
+	cxp := coef * rx * y1p / ry
+	cyp := -coef * ry * x1p / rx
+	cx := cosPhi*cxp - sinPhi*cyp + (x1+x2)/2
+	cy := sinPhi*cxp + cosPhi*cyp + (y1+y2)/2
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	theta1 := angle(1, 0, (x1p-cxp)/rx, (y1p-cyp)/ry)
+	deltaTheta := angle((x1p-cxp)/rx, (y1p-cyp)/ry, (-x1p-cxp)/rx, (-y1p-cyp)/ry)
+	if !sweep && deltaTheta > 0 {
+		deltaTheta -= 2 * math.Pi
+	} else if sweep && deltaTheta < 0 {
+		deltaTheta += 2 * math.Pi
+	}
+	segments := curveSegments(math.Abs(deltaTheta) * (rx + ry) / 2 * scale)
+	points := make([]svgPoint, 0, segments)
+	for i := 1; i <= segments; i++ {
+		sin, cos := math.Sincos(theta1 + deltaTheta*float64(i)/float64(segments))
+		points = append(points, svgPoint{
+			cx + cosPhi*rx*cos - sinPhi*ry*sin,
+			cy + sinPhi*rx*cos + cosPhi*ry*sin,
+		})
+	}
+	// end exactly on the endpoint
+	points[len(points)-1] = svgPoint{x2, y2}
+	return points
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func expectPoints(t *testing.T, name string, subpaths []svgSubpath, expected [][]svgPoint) {
+	t.Helper()
+	if len(subpaths) != len(expected) {
+		t.Errorf("%v: Expected %v subpaths, got %v", name, len(expected), len(subpaths))
+		return
+	}
+	for i, subpath := range subpaths {
+		if len(subpath.points) != len(expected[i]) {
+			t.Errorf("%v: Expected subpath %v to have points %v, got %v", name, i, expected[i], subpath.points)
+			continue
+		}
+		for j, point := range subpath.points {
+			if math.Abs(point.X-expected[i][j].X) > 1e-6 || math.Abs(point.Y-expected[i][j].Y) > 1e-6 {
+				t.Errorf("%v: Expected subpath %v to have points %v, got %v", name, i, expected[i], subpath.points)
+				break
+			}
+		}
+	}
+}
+
+func TestParseSVGPath(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     string
+		expected [][]svgPoint
+		closed   []bool
+	}{
+		{"absolute", "M10 20 L30 20 L30 40 Z", [][]svgPoint{{{10, 20}, {30, 20}, {30, 40}}}, []bool{true}},
+		{"relative", "m10,20 l20,0 l0,20 z", [][]svgPoint{{{10, 20}, {30, 20}, {30, 40}}}, []bool{true}},
+		{"horizontal and vertical", "M10 20H30V40h-10v-5", [][]svgPoint{{{10, 20}, {30, 20}, {30, 40}, {20, 40}, {20, 35}}}, []bool{false}},
+		{"implicit lines after move", "M10 20 30 20 30 40", [][]svgPoint{{{10, 20}, {30, 20}, {30, 40}}}, []bool{false}},
+		{"implicit relative lines after move", "m10 20 20 0 0 20", [][]svgPoint{{{10, 20}, {30, 20}, {30, 40}}}, []bool{false}},
+		{"implicit repeated command", "M0 0 L10 0 10 10 0 10z", [][]svgPoint{{{0, 0}, {10, 0}, {10, 10}, {0, 10}}}, []bool{true}},
+		{"compact numbers", "M0-5L.5.5-1-1", [][]svgPoint{{{0, -5}, {0.5, 0.5}, {-1, -1}}}, []bool{false}},
+		{"relative after close", "M10 10 l10 0 l0 10 z m5 5 l1 0", [][]svgPoint{{{10, 10}, {20, 10}, {20, 20}}, {{15, 15}, {16, 15}}}, []bool{true, false}},
+	}
+	for _, c := range cases {
+		subpaths, err := parseSVGPath(c.data, 1)
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err.Error())
+			continue
+		}
+		expectPoints(t, c.name, subpaths, c.expected)
+		for i := range subpaths {
+			if i < len(c.closed) && subpaths[i].closed != c.closed[i] {
+				t.Errorf("%v: Expected subpath %v closed to be %v", c.name, i, c.closed[i])
+			}
+		}
+	}
+}
+
+func TestParseSVGPathCurves(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		end  svgPoint
+		// every point must be this far from the center, if radius > 0
+		center svgPoint
+		radius float64
+	}{
+		{"arc", "M0 10 A10 10 0 0 1 10 0", svgPoint{10, 0}, svgPoint{10, 10}, 10},
+		{"relative arc", "M0 10 a10 10 0 0 1 10 -10", svgPoint{10, 0}, svgPoint{10, 10}, 10},
+		{"arc with compact flags", "M0 10 a10 10 0 0110 -10", svgPoint{10, 0}, svgPoint{10, 10}, 10},
+		{"cubic", "M0 0 C10 0 20 10 20 20", svgPoint{20, 20}, svgPoint{}, 0},
+		{"smooth cubic", "M0 0 c10 0 20 10 20 20 s10 20 20 20", svgPoint{40, 40}, svgPoint{}, 0},
+		{"quadratic", "M0 0 Q10 0 10 10 T20 20", svgPoint{20, 20}, svgPoint{}, 0},
+	}
+	for _, c := range cases {
+		subpaths, err := parseSVGPath(c.data, 1)
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err.Error())
+			continue
+		}
+		if len(subpaths) != 1 || len(subpaths[0].points) < 3 {
+			t.Errorf("%v: Expected a flattened curve, got %v", c.name, subpaths)
+			continue
+		}
+		points := subpaths[0].points
+		if end := points[len(points)-1]; math.Abs(end.X-c.end.X) > 1e-6 || math.Abs(end.Y-c.end.Y) > 1e-6 {
+			t.Errorf("%v: Expected the curve to end at %v, got %v", c.name, c.end, end)
+		}
+		if c.radius > 0 {
+			for _, point := range points {
+				if distance := math.Hypot(point.X-c.center.X, point.Y-c.center.Y); math.Abs(distance-c.radius) > 1e-6 {
+					t.Errorf("%v: Expected %v to be %v from %v, got %v", c.name, point, c.radius, c.center, distance)
+				}
+			}
+		}
+	}
+}
+
+func TestParseInvalidSVGPath(t *testing.T) {
+	for _, data := range []string{"10 20", "M10", "M0 0 L10 0 Z 5 5", "M0 0 A10 10 0 2 1 10 0"} {
+		if _, err := parseSVGPath(data, 1); err == nil {
+			t.Errorf("Expected an error parsing '%v'", data)
+		}
+	}
+}
+
+func TestParseSVGTransform(t *testing.T) {
+	cases := []struct {
+		transform string
+		point     svgPoint
+		expected  svgPoint
+	}{
+		{"", svgPoint{3, 4}, svgPoint{3, 4}},
+		{"translate(10)", svgPoint{3, 4}, svgPoint{13, 4}},
+		{"translate(10, 20)", svgPoint{3, 4}, svgPoint{13, 24}},
+		{"scale(2)", svgPoint{3, 4}, svgPoint{6, 8}},
+		{"scale(2 3)", svgPoint{3, 4}, svgPoint{6, 12}},
+		{"rotate(90)", svgPoint{1, 0}, svgPoint{0, 1}},
+		{"rotate(180 10 10)", svgPoint{0, 0}, svgPoint{20, 20}},
+		{"matrix(1 2 3 4 5 6)", svgPoint{1, 1}, svgPoint{9, 12}},
+		{"skewX(45)", svgPoint{0, 10}, svgPoint{10, 10}},
+		// The rightmost transform is applied first
+		{"translate(10,0) scale(2)", svgPoint{1, 1}, svgPoint{12, 2}},
+		{"scale(2),translate(10,0)", svgPoint{1, 1}, svgPoint{22, 2}},
+		{"unknown(5) translate(1 1)", svgPoint{0, 0}, svgPoint{1, 1}},
+	}
+	for _, c := range cases {
+		x, y := parseSVGTransform(c.transform).Apply(c.point.X, c.point.Y)
+		if math.Abs(x-c.expected.X) > 1e-9 || math.Abs(y-c.expected.Y) > 1e-9 {
+			t.Errorf("%v: Expected %v to become %v, got (%v, %v)", c.transform, c.point, c.expected, x, y)
+		}
+	}
+}
+
+func TestParseSVGPaint(t *testing.T) {
+	cases := []struct {
+		value    string
+		opacity  float64
+		expected *color.NRGBA
+	}{
+		{"#ff8000", 1, &color.NRGBA{255, 128, 0, 255}},
+		{"#F80", 1, &color.NRGBA{255, 136, 0, 255}},
+		{"red", 1, &color.NRGBA{255, 0, 0, 255}},
+		{" rgb(10, 20, 30) ", 1, &color.NRGBA{10, 20, 30, 255}},
+		{"rgb(100%, 50%, 0%)", 1, &color.NRGBA{255, 128, 0, 255}},
+		{"#ff8000", 0.5, &color.NRGBA{255, 128, 0, 128}},
+		{"none", 1, nil},
+		{"transparent", 1, nil},
+		{"#ff8000", 0, nil},
+	}
+	for _, c := range cases {
+		clr, err := parseSVGPaint(c.value, c.opacity)
+		if err != nil {
+			t.Errorf("%v: %v", c.value, err.Error())
+			continue
+		}
+		if (clr == nil) != (c.expected == nil) || clr != nil && *clr != *c.expected {
+			t.Errorf("%v: Expected %v, got %v", c.value, c.expected, clr)
+		}
+	}
+	for _, value := range []string{"url(#gradient)", "currentColor", "rgb(1,2)", "#12345g", "#xyz"} {
+		if _, err := parseSVGPaint(value, 1); err == nil {
+			t.Errorf("Expected an error parsing '%v'", value)
+		}
+	}
+}
+
+func TestImportSVG(t *testing.T) {
+	document := `<?xml version="1.0"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="50" height="40" viewBox="0 0 100 80">
+	<rect width="100" height="80" fill="#102030"/>
+	<defs><circle cx="1" cy="1" r="50" fill="red"/></defs>
+	<g transform="translate(10 10)" fill="blue" fill-opacity="0.5">
+		<circle cx="20" cy="20" r="10"/>
+		<path d="M40 0 l20 0 l0 20 z" stroke="#00ff00" stroke-width="4"/>
+	</g>
+	<line x1="0" y1="70" x2="100" y2="70" stroke="white" stroke-width="2"/>
+</svg>`
+	organism, err := ImportSVG([]byte(document), 50, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if organism.Background == nil || SaveColorHex(organism.Background) != "#102030" {
+		t.Errorf("Expected background #102030, got %v", organism.Background)
+	}
+	types := []string{}
+	for _, instruction := range organism.Instructions {
+		types = append(types, instruction.Type())
+	}
+	// The circle in defs isn't rendered, the path is filled and stroked with
+	// 3 lines
+	expectedTypes := []string{TypeCircle, TypePolygon, TypeLine, TypeLine, TypeLine, TypeLine}
+	if len(types) != len(expectedTypes) {
+		t.Fatalf("Expected instructions %v, got %v", expectedTypes, types)
+	}
+	for i := range types {
+		if types[i] != expectedTypes[i] {
+			t.Fatalf("Expected instructions %v, got %v", expectedTypes, types)
+		}
+	}
+
+	// The viewBox is scaled by 0.5
+	circle := organism.Instructions[0].(*Circle)
+	if circle.X != 15 || circle.Y != 15 || circle.Radius != 5 || *circle.Color != (color.NRGBA{0, 0, 255, 128}) {
+		t.Errorf("Expected a translucent blue circle at (15, 15) with radius 5, got %+v", *circle)
+	}
+	polygon := organism.Instructions[1].(*Polygon)
+	bounds := polygon.Bounds()
+	if math.Abs(float64(bounds.Left-25)) > 0.01 || math.Abs(float64(bounds.Top-5)) > 0.01 ||
+		math.Abs(float64(bounds.Right-35)) > 0.01 || math.Abs(float64(bounds.Bottom-15)) > 0.01 {
+		t.Errorf("Expected the polygon to cover (25, 5)-(35, 15), got %v", bounds)
+	}
+	outline := organism.Instructions[2].(*Line)
+	if outline.Width != 2 || *outline.Color != (color.NRGBA{0, 255, 0, 255}) {
+		t.Errorf("Expected a green outline of width 2, got %+v", *outline)
+	}
+	line := organism.Instructions[5].(*Line)
+	if line.StartX != 0 || line.StartY != 35 || line.EndX != 50 || line.EndY != 35 || line.Width != 1 {
+		t.Errorf("Expected a line from (0, 35) to (50, 35) of width 1, got %+v", *line)
+	}
+}
+
+func TestImportSVGWithoutRoot(t *testing.T) {
+	if _, err := ImportSVG([]byte(`<html></html>`), 10, 10); err == nil {
+		t.Error("Expected an error importing a document without an svg element")
+	}
+}
+
+// coverage renders a shape in white on black, and returns the red channel
+func coverage(draw func(ctx *gg.Context)) []uint8 {
+	ctx := gg.NewContext(100, 100)
+	ctx.SetColor(color.Black)
+	ctx.Clear()
+	draw(ctx)
+	img := ctx.Image().(*image.RGBA)
+	values := make([]uint8, 0, 100*100)
+	for i := 0; i < len(img.Pix); i += 4 {
+		values = append(values, img.Pix[i])
+	}
+	return values
+}
+
+func TestImportedPolygonsKeepTheirShape(t *testing.T) {
+	star := ""
+	for i := 0; i < 10; i++ {
+		radius := 45.0
+		if i%2 == 1 {
+			radius = 18
+		}
+		sin, cos := math.Sincos(float64(i) * math.Pi / 5)
+		command := "L"
+		if i == 0 {
+			command = "M"
+		}
+		star += command + fmt.Sprintf("%v %v ", 50+cos*radius, 50+sin*radius)
+	}
+	cases := []struct {
+		name string
+		path string
+	}{
+		{"concave", "M10 10 L90 10 L90 90 L70 90 L70 30 L10 30 Z"},
+		{"u shape", "M10 10 L30 10 L30 70 L70 70 L70 10 L90 10 L90 90 L10 90 Z"},
+		{"star", star + "Z"},
+		{"circle with many points", "M50 5 A45 45 0 1 1 49.99 5 Z"},
+	}
+	for _, c := range cases {
+		subpaths, err := parseSVGPath(c.path, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := coverage(func(ctx *gg.Context) {
+			for _, point := range subpaths[0].points {
+				ctx.LineTo(point.X, point.Y)
+			}
+			ctx.SetColor(color.White)
+			ctx.Fill()
+		})
+
+		importer := &svgImporter{width: 100, height: 100}
+		importer.addPolygon(subpaths[0], svgIdentity, &color.NRGBA{255, 255, 255, 255})
+		actual := coverage(func(ctx *gg.Context) {
+			for _, instruction := range importer.instructions {
+				polygon := instruction.(*Polygon)
+				if len(polygon.Points) > config.MaxPolygonPoints {
+					t.Errorf("%v: Expected at most %v points, got %v", c.name, config.MaxPolygonPoints, len(polygon.Points))
+				}
+				polygon.Execute(ctx)
+			}
+		})
+		differences := 0
+		for i := range expected {
+			if d := int(expected[i]) - int(actual[i]); d > 128 || d < -128 {
+				differences++
+			}
+		}
+		// Seams between the pieces are antialiased
+		if differences > 50 {
+			t.Errorf("%v: %v pixels differ from the svg shape (split into %v polygons)", c.name, differences, len(importer.instructions))
+		}
+	}
+}
+
+func TestImportedStarShapedPolygonsAreNotSplit(t *testing.T) {
+	importer := &svgImporter{width: 100, height: 100}
+	// A dart is concave, but every point is visible from its center
+	dart := svgSubpath{points: []svgPoint{{10, 10}, {90, 50}, {10, 90}, {30, 50}}, closed: true}
+	importer.addPolygon(dart, svgIdentity, &color.NRGBA{255, 255, 255, 255})
+	if len(importer.instructions) != 1 || importer.splitShapes != 0 {
+		t.Errorf("Expected one polygon, got %v", len(importer.instructions))
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+// rasterizeTestSVG paints the elements that RenderSVG writes the way an svg
+// viewer would, so that exported documents can be compared with Renderer
+func rasterizeTestSVG(t *testing.T, data []byte, width int, height int) image.Image {
+	t.Helper()
+	ctx := gg.NewContext(width, height)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		attributes := map[string]string{}
+		for _, attribute := range element.Attr {
+			attributes[attribute.Name.Local] = attribute.Value
+		}
+		number := func(name string) float64 {
+			value, err := strconv.ParseFloat(attributes[name], 64)
+			if err != nil {
+				t.Fatalf("Invalid %v in <%v>: %v", name, element.Name.Local, err.Error())
+			}
+			return value
+		}
+		paint := func(attribute string) color.Color {
+			clr, err := parseSVGPaint(attributes[attribute], 1)
+			if err != nil || clr == nil {
+				t.Fatalf("Invalid %v in <%v>: '%v'", attribute, element.Name.Local, attributes[attribute])
+			}
+			if opacity, ok := attributes[attribute+"-opacity"]; ok {
+				value, _ := strconv.ParseFloat(opacity, 64)
+				clr.A = uint8(value*255 + 0.5)
+			}
+			return clr
+		}
+		switch element.Name.Local {
+		case "rect":
+			ctx.SetColor(paint("fill"))
+			ctx.DrawRectangle(0, 0, number("width"), number("height"))
+			ctx.Fill()
+		case "circle":
+			ctx.SetColor(paint("fill"))
+			ctx.DrawCircle(number("cx"), number("cy"), number("r"))
+			ctx.Fill()
+		case "line":
+			if attributes["stroke-linecap"] != "round" {
+				t.Errorf("Expected lines to have round caps like gg")
+			}
+			ctx.SetColor(paint("stroke"))
+			ctx.SetLineWidth(number("stroke-width"))
+			ctx.DrawLine(number("x1"), number("y1"), number("x2"), number("y2"))
+			ctx.Stroke()
+		case "polygon":
+			for _, point := range strings.Fields(attributes["points"]) {
+				coordinates := strings.Split(point, ",")
+				x, _ := strconv.ParseFloat(coordinates[0], 64)
+				y, _ := strconv.ParseFloat(coordinates[1], 64)
+				ctx.LineTo(x, y)
+			}
+			ctx.SetColor(paint("fill"))
+			ctx.Fill()
+		case "svg":
+		default:
+			t.Errorf("Unexpected element <%v>", element.Name.Local)
+		}
+	}
+	return ctx.Image()
+}
+
+func TestRenderSVGMatchesRenderer(t *testing.T) {
+	setCanvasSize(40, 30)
+	polygon := &Polygon{
+		X: 20, Y: 15, Color: &color.NRGBA{200, 100, 50, 180},
+		Points: []Polypoint{{Distance: 10, Angle: 0.3}, {Distance: 14, Angle: 2.5}, {Distance: 8, Angle: 4.5}},
+	}
+	cases := []struct {
+		name     string
+		organism *Organism
+	}{
+		{"polygon", &Organism{Background: &color.NRGBA{10, 20, 30, 255}, Instructions: []Instruction{polygon}}},
+		{"circle", &Organism{Background: &color.NRGBA{255, 255, 255, 255}, Instructions: []Instruction{
+			&Circle{X: 12.5, Y: 14, Radius: 6.25, Color: &color.NRGBA{0, 128, 255, 255}},
+			&Circle{X: 20, Y: 15, Radius: 9, Color: &color.NRGBA{255, 0, 0, 100}},
+		}}},
+		{"line", &Organism{Instructions: []Instruction{
+			&Line{StartX: 2, StartY: 3, EndX: 35.5, EndY: 27, Width: 3.5, Color: &color.NRGBA{255, 255, 0, 255}},
+			&Line{StartX: 30, StartY: 2, EndX: 5, EndY: 25, Width: 1, Color: &color.NRGBA{0, 255, 0, 128}},
+		}}},
+		{"translucent background", &Organism{Background: &color.NRGBA{100, 150, 200, 128}, Instructions: []Instruction{polygon}}},
+		{"test organism", testOrganism()},
+	}
+	for _, c := range cases {
+		data, skipped := RenderSVG(40, 30, c.organism.Background, c.organism.Instructions)
+		if skipped != 0 {
+			t.Errorf("%v: Expected no skipped instructions, got %v", c.name, skipped)
+		}
+		renderer := NewRenderer(40, 30)
+		renderer.Render(c.organism.Background, c.organism.Instructions)
+		expected := renderer.GetImage().(*image.RGBA)
+		actual := rasterizeTestSVG(t, data, 40, 30).(*image.RGBA)
+		differences := 0
+		for i := range expected.Pix {
+			if d := int(expected.Pix[i]) - int(actual.Pix[i]); d > 2 || d < -2 {
+				differences++
+			}
+		}
+		if differences > 0 {
+			t.Errorf("%v: %v channels of the svg differ from the rendered image", c.name, differences)
+		}
+	}
+}
+
+func TestRenderSVGCountsSkippedInstructions(t *testing.T) {
+	instructions := append(testOrganism().Instructions, &Brush{Color: &color.NRGBA{A: 255}}, &Brush{Color: &color.NRGBA{A: 255}})
+	data, skipped := RenderSVG(40, 30, nil, instructions)
+	if skipped != 2 {
+		t.Errorf("Expected the 2 brushes to be skipped, got %v", skipped)
+	}
+	if bytes.Contains(data, []byte("brush")) {
+		t.Error("Expected brushes to be left out of the svg")
+	}
+}
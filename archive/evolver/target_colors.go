@@ -0,0 +1,96 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+)
+
+const (
+	// TargetColorMean colors new instructions with the mean color of the
+	// target under their bounds
+	TargetColorMean = "mean"
+	// TargetColorCenter colors new instructions with the color of the target
+	// at their center
+	TargetColorCenter = "center"
+)
+
+// TargetColors samples colors of the target image for new instructions
+type TargetColors struct {
+	width  int
+	height int
+	// sums is a summed-area table of the r, g and b channels, indexed by
+	// (y*(width+1)+x)*3+channel, so that the mean color of any area can be
+	// looked up in constant time
+	sums []float64
+}
+
+// NewTargetColors precalculates the colors of a target image
+func NewTargetColors(target image.Image) *TargetColors {
+	size := target.Bounds().Size()
+	colors := &TargetColors{
+		width:  size.X,
+		height: size.Y,
+		sums:   make([]float64, (size.X+1)*(size.Y+1)*3),
+	}
+	stride := (size.X + 1) * 3
+	for y := 0; y < size.Y; y++ {
+		var rowSums [3]float64
+		for x := 0; x < size.X; x++ {
+			r, g, b := pixelRGB(target, x, y)
+			rowSums[0] += r
+			rowSums[1] += g
+			rowSums[2] += b
+			i := (y+1)*stride + (x+1)*3
+			for channel, rowSum := range rowSums {
+				colors.sums[i+channel] = colors.sums[i-stride+channel] + rowSum
+			}
+		}
+	}
+	return colors
+}
+
+// Mean returns the mean color of the target in an area, clipped to the
+// image. If the area is outside of the image, the color at its center is
+// returned.
+func (colors *TargetColors) Mean(bounds Rect) *color.NRGBA {
+	left, top, right, bottom := bounds.PixelArea(0)
+	area := pixelArea{left, top, right, bottom}.clamp(colors.width, colors.height)
+	if area.empty() {
+		return colors.At(bounds.Center())
+	}
+	stride := (colors.width + 1) * 3
+	a, b := area.top*stride+area.left*3, area.top*stride+area.right*3
+	c, d := area.bottom*stride+area.left*3, area.bottom*stride+area.right*3
+	n := float64(area.size())
+	var channels [3]uint8
+	for channel := range channels {
+		sum := colors.sums[d+channel] - colors.sums[b+channel] - colors.sums[c+channel] + colors.sums[a+channel]
+		channels[channel] = uint8(sum/n + 0.5)
+	}
+	return &color.NRGBA{channels[0], channels[1], channels[2], 255}
+}
+
+// At returns the color of the target at a position. Positions outside of the
+// image are clamped to its border.
+func (colors *TargetColors) At(x float32, y float32) *color.NRGBA {
+	px := minInt(maxInt(int(x), 0), colors.width-1)
+	py := minInt(maxInt(int(y), 0), colors.height-1)
+	return colors.Mean(Rect{Left: float32(px), Top: float32(py), Right: float32(px), Bottom: float32(py)})
+}
+
+// Jitter changes each channel of a color by a random amount of up to
+// amount (0-1) of its range
+func Jitter(clr *color.NRGBA, amount float32) *color.NRGBA {
+	jitter := func(value uint8) uint8 {
+		v := float32(value) + (rand.Float32()*2-1)*amount*255
+		if v < 0 {
+			return 0
+		}
+		if v > 255 {
+			return 255
+		}
+		return uint8(v + 0.5)
+	}
+	return &color.NRGBA{jitter(clr.R), jitter(clr.G), jitter(clr.B), clr.A}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"image"
+	"io"
+	"math"
+)
+
+// FrameIterations spreads a number of frames over a range of iterations.
+// With an easing of 1 the frames are evenly spaced. Larger values put more
+// frames at the start, where the painting changes the fastest.
+func FrameIterations(firstIteration int, lastIteration int, frames int, easing float64) []int {
+	iterations := make([]int, frames)
+	if frames == 1 {
+		iterations[0] = lastIteration
+		return iterations
+	}
+	for i := range iterations {
+		t := math.Pow(float64(i)/float64(frames-1), easing)
+		iterations[i] = firstIteration + int(math.Round(t*float64(lastIteration-firstIteration)))
+	}
+	return iterations
+}
+
+// RenderJournalFrames replays a journal file and renders the top organism as
+// it was at each of the specified iterations (in ascending order). The
+// journal is rendered at its own canvas size scaled to width. frame is called
+// with the index and image of each frame, the image is only valid during the
+// call.
+func RenderJournalFrames(filename string, iterations []int, width int, height int, frame func(index int, img image.Image) error) error {
+	journalReader, err := OpenJournalReader(filename)
+	if err != nil {
+		return err
+	}
+	defer journalReader.Close()
+	renderer := NewRenderer(width, height)
+	factor := float32(1)
+	next := 0
+	renderFrames := func(beforeIteration int) error {
+		organism := journalReader.Organism()
+		for next < len(iterations) && iterations[next] < beforeIteration && organism != nil {
+			instructions := make([]Instruction, len(organism.Instructions))
+			for i, instruction := range organism.Instructions {
+				instructions[i] = instruction.Scale(factor)
+			}
+			renderer.Render(organism.Background, instructions)
+			for _, instruction := range instructions {
+				objectPool.ReturnInstruction(instruction)
+			}
+			err := frame(next, renderer.GetImage())
+			if err != nil {
+				return err
+			}
+			next++
+		}
+		return nil
+	}
+	for next < len(iterations) {
+		entry, err := journalReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// Frames show the organism at the end of an iteration, so they are
+		// rendered once the journal moves past it.
+		err = renderFrames(entry.Iteration)
+		if err != nil {
+			return err
+		}
+		if entry.IsSnapshot() && journalReader.Organism() == nil {
+			// Organisms can't be borrowed until the canvas size is known
+			objectPool.SetRendererBounds(entry.Width, entry.Height)
+			factor = float32(width) / float32(entry.Width)
+		}
+		err = journalReader.Apply(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return renderFrames(math.MaxInt32)
+}
+
+// RenderPaintingFrames renders an organism one batch of instructions at a
+// time, so that the painting can be watched as it is painted. frame is called
+// with the index and image of each frame, the image is only valid during the
+// call.
+func RenderPaintingFrames(organism *Organism, perFrame int, width int, height int, frame func(index int, img image.Image) error) error {
+	renderer := NewRenderer(width, height)
+	renderer.Render(organism.Background, nil)
+	for i := 0; i*perFrame < len(organism.Instructions); i++ {
+		end := (i + 1) * perFrame
+		if end > len(organism.Instructions) {
+			end = len(organism.Instructions)
+		}
+		renderer.Paint(organism.Instructions[i*perFrame : end])
+		err := frame(i, renderer.GetImage())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -16,20 +16,23 @@ type WorkItemResult struct {
 
 // A Worker allows the evolver system to run logic on multiple CPU cores effectively.
 type Worker struct {
-	workerID        int
-	imageWidth      int
-	imageHeight     int
-	ranker          *Ranker
-	cloneChan       <-chan *Organism
-	cloneResultChan chan<- *Organism
-	hashChan        <-chan *Organism
-	hashResultChan  chan<- bool
-	rankChan        <-chan *Organism
-	rankResultChan  chan<- WorkItemResult
-	saveChan        <-chan *Organism
-	saveResultChan  chan<- []byte
-	loadChan        <-chan []byte
-	loadResultChan  chan<- *Organism
+	workerID         int
+	imageWidth       int
+	imageHeight      int
+	ranker           *Ranker
+	mutator          *Mutator
+	cloneChan        <-chan *Organism
+	cloneResultChan  chan<- *Organism
+	hashChan         <-chan *Organism
+	hashResultChan   chan<- bool
+	rankChan         <-chan *Organism
+	rankResultChan   chan<- WorkItemResult
+	saveChan         <-chan *Organism
+	saveResultChan   chan<- []byte
+	loadChan         <-chan []byte
+	loadResultChan   chan<- *Organism
+	refineChan       <-chan *Organism
+	refineResultChan chan<- *Organism
 }
 
 // NewWorker returns a new `Worker`
@@ -38,6 +41,7 @@ func NewWorker(
 	imageWidth int,
 	imageHeight int,
 	ranker *Ranker,
+	mutator *Mutator,
 	cloneChan <-chan *Organism,
 	cloneResultChan chan<- *Organism,
 	hashChan <-chan *Organism,
@@ -48,12 +52,15 @@ func NewWorker(
 	saveResultChan chan<- []byte,
 	loadChan <-chan []byte,
 	loadResultChan chan<- *Organism,
+	refineChan <-chan *Organism,
+	refineResultChan chan<- *Organism,
 ) *Worker {
 	worker := new(Worker)
 	worker.workerID = workerID
 	worker.imageWidth = imageWidth
 	worker.imageHeight = imageHeight
 	worker.ranker = ranker
+	worker.mutator = mutator
 	worker.cloneChan = cloneChan
 	worker.cloneResultChan = cloneResultChan
 	worker.hashChan = hashChan
@@ -64,6 +71,8 @@ func NewWorker(
 	worker.saveResultChan = saveResultChan
 	worker.loadChan = loadChan
 	worker.loadResultChan = loadResultChan
+	worker.refineChan = refineChan
+	worker.refineResultChan = refineResultChan
 	return worker
 }
 
@@ -82,9 +91,9 @@ func (worker *Worker) Start() {
 				// rendering and comparison if the organism has a parent.
 
 				if organism.Parent == nil || len(organism.AffectedAreas) == 0 {
-					renderer.Render(organism.Instructions)
+					renderer.Render(organism.Background, organism.Instructions)
 				} else {
-					renderer.RenderBounds(organism.Instructions, organism.AffectedAreas)
+					renderer.RenderBounds(organism.Background, organism.Instructions, worker.ranker.RenderAreas(organism.AffectedAreas))
 				}
 
 				renderedOrganism := renderer.GetImage()
@@ -121,7 +130,7 @@ func (worker *Worker) Start() {
 				}
 				worker.rankResultChan <- workItemResult
 			case organism := <-worker.saveChan:
-				saved := organism.Save()
+				saved := organism.SaveEncoded(config.PopulationFileEncoding)
 				worker.saveResultChan <- saved
 			case organism := <-worker.cloneChan:
 				cloned := organism.Clone()
@@ -131,12 +140,20 @@ func (worker *Worker) Start() {
 					worker.loadResultChan <- nil
 				} else {
 					organism := objectPool.BorrowOrganism()
-					organism.Load(saved)
+					err := organism.Load(saved)
+					if err != nil {
+						log.Printf("Error loading organism: %v", err.Error())
+						objectPool.ReturnOrganism(organism)
+						organism = nil
+					}
 					worker.loadResultChan <- organism
 				}
 			case organism := <-worker.hashChan:
 				organism.Hash()
 				worker.hashResultChan <- true
+			case organism := <-worker.refineChan:
+				worker.refine(organism)
+				worker.refineResultChan <- organism
 			}
 
 		}
@@ -145,20 +162,23 @@ func (worker *Worker) Start() {
 
 // A WorkerPool provides a multithreaded pool of workers
 type WorkerPool struct {
-	imageWidth      int
-	imageHeight     int
-	ranker          *Ranker
-	cloneChan       <-chan *Organism
-	cloneResultChan chan<- *Organism
-	hashChan        <-chan *Organism
-	hashResultChan  chan<- bool
-	rankChan        <-chan *Organism
-	rankResultChan  chan<- WorkItemResult
-	saveChan        <-chan *Organism
-	saveResultChan  chan<- []byte
-	loadChan        <-chan []byte
-	loadResultChan  chan<- *Organism
-	numWorkers      int
+	imageWidth       int
+	imageHeight      int
+	ranker           *Ranker
+	mutator          *Mutator
+	cloneChan        <-chan *Organism
+	cloneResultChan  chan<- *Organism
+	hashChan         <-chan *Organism
+	hashResultChan   chan<- bool
+	rankChan         <-chan *Organism
+	rankResultChan   chan<- WorkItemResult
+	saveChan         <-chan *Organism
+	saveResultChan   chan<- []byte
+	loadChan         <-chan []byte
+	loadResultChan   chan<- *Organism
+	refineChan       <-chan *Organism
+	refineResultChan chan<- *Organism
+	numWorkers       int
 }
 
 // NewWorkerPool returns a new WorkerPool
@@ -166,6 +186,7 @@ func NewWorkerPool(
 	imageWidth int,
 	imageHeight int,
 	ranker *Ranker,
+	mutator *Mutator,
 	cloneChan <-chan *Organism,
 	cloneResultChan chan<- *Organism,
 	hashChan <-chan *Organism,
@@ -176,12 +197,15 @@ func NewWorkerPool(
 	saveResultChan chan<- []byte,
 	loadChan <-chan []byte,
 	loadResultChan chan<- *Organism,
+	refineChan <-chan *Organism,
+	refineResultChan chan<- *Organism,
 	numWorkers int,
 ) *WorkerPool {
 	pool := new(WorkerPool)
 	pool.imageWidth = imageWidth
 	pool.imageHeight = imageHeight
 	pool.ranker = ranker
+	pool.mutator = mutator
 	pool.cloneChan = cloneChan
 	pool.cloneResultChan = cloneResultChan
 	pool.hashChan = hashChan
@@ -193,6 +217,8 @@ func NewWorkerPool(
 	pool.saveResultChan = saveResultChan
 	pool.loadChan = loadChan
 	pool.loadResultChan = loadResultChan
+	pool.refineChan = refineChan
+	pool.refineResultChan = refineResultChan
 	return pool
 }
 
@@ -208,6 +234,7 @@ func (pool *WorkerPool) Start() {
 			pool.imageWidth,
 			pool.imageHeight,
 			pool.ranker,
+			pool.mutator,
 			pool.cloneChan,
 			pool.cloneResultChan,
 			pool.hashChan,
@@ -218,6 +245,8 @@ func (pool *WorkerPool) Start() {
 			pool.saveResultChan,
 			pool.loadChan,
 			pool.loadResultChan,
+			pool.refineChan,
+			pool.refineResultChan,
 		).Start()
 	}
 }
@@ -11,6 +11,9 @@ import (
 // WorkerClient is a client to access the http api of the main server.
 type WorkerClient struct {
 	endpoint string
+	// binary is set once the server has sent a binary encoded organism.
+	// Older servers only understand the text encoding.
+	binary bool
 }
 
 func NewWorkerClient(endpoint string) *WorkerClient {
@@ -19,23 +22,38 @@ func NewWorkerClient(endpoint string) *WorkerClient {
 	return client
 }
 
+// get sends a GET request that accepts binary encoded organisms
+func (client *WorkerClient) get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", BinaryContentType+", */*")
+	return http.DefaultClient.Do(req)
+}
+
 func (client *WorkerClient) GetTopOrganism() (*Organism, error) {
-	resp, err := http.Get(fmt.Sprintf("%v/organism", client.endpoint))
+	resp, err := client.get(fmt.Sprintf("%v/organism", client.endpoint))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	client.binary = resp.Header.Get("Content-Type") == BinaryContentType
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 	organism := objectPool.BorrowOrganism()
-	organism.Load(data)
+	err = organism.Load(data)
+	if err != nil {
+		objectPool.ReturnOrganism(organism)
+		return nil, err
+	}
 	return organism, nil
 }
 
 func (client *WorkerClient) GetTopOrganismDelta(previous string) (*Patch, error) {
-	resp, err := http.Get(fmt.Sprintf("%v/organism/delta?previous=%v", client.endpoint, previous))
+	resp, err := client.get(fmt.Sprintf("%v/organism/delta?previous=%v", client.endpoint, previous))
 	if err != nil {
 		return nil, err
 	}
@@ -57,6 +75,14 @@ func (client *WorkerClient) GetTopOrganismDelta(previous string) (*Patch, error)
 }
 
 func (client *WorkerClient) SubmitOrganism(patch *Patch) error {
+	if client.binary {
+		encoded, err := patch.Encode(EncodingBinary)
+		if err != nil {
+			return err
+		}
+		defer objectPool.ReturnPatch(encoded)
+		patch = encoded
+	}
 	data, err := json.Marshal(patch)
 	if err != nil {
 		return err
@@ -20,6 +20,7 @@ type WorkerPortal struct {
 	lastImported    *Organism
 	patchProcessor  *PatchProcessor
 	outgoingPatches []*Patch
+	stopChan        chan VoidCallback
 }
 
 // NewWorkerPortal returns a new `WorkerPortal`
@@ -28,6 +29,7 @@ func NewWorkerPortal(workerClient *WorkerClient) *WorkerPortal {
 		workerClient: workerClient,
 		importQueue:  make(chan *Organism, 20),
 		exportQueue:  make(chan *Patch, 100),
+		stopChan:     make(chan VoidCallback),
 	}
 }
 
@@ -49,6 +51,11 @@ func (portal *WorkerPortal) Start() {
 				portal._import()
 			case patch := <-portal.exportQueue:
 				portal.outgoingPatches = append(portal.outgoingPatches, patch)
+			case callback := <-portal.stopChan:
+				ticker.Stop()
+				portal.flush()
+				callback <- nil
+				return
 			}
 			// time.Sleep(time.Second * time.Duration(config.SyncFrequency))
 			// portal.export()
@@ -57,6 +64,27 @@ func (portal *WorkerPortal) Start() {
 	}()
 }
 
+// Stop exports all pending patches to the server and stops the portal
+func (portal *WorkerPortal) Stop() {
+	callback := make(chan error)
+	portal.stopChan <- callback
+	<-callback
+}
+
+// flush exports the patches that are still waiting in the export queue
+func (portal *WorkerPortal) flush() {
+	for {
+		select {
+		case patch := <-portal.exportQueue:
+			portal.outgoingPatches = append(portal.outgoingPatches, patch)
+		default:
+			log.Printf("Flushing %v pending patches", len(portal.outgoingPatches))
+			portal.export()
+			return
+		}
+	}
+}
+
 // Export will export an organism to the server.
 func (portal *WorkerPortal) Export(organism *Organism) {
 	if organism.Patch == nil {